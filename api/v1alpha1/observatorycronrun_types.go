@@ -0,0 +1,83 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConcurrencyPolicy says what an ObservatoryCronRun does when a run is due
+// while an earlier one has not finished.
+type ConcurrencyPolicy string
+
+const (
+	// AllowConcurrent starts the new run alongside the unfinished ones.
+	AllowConcurrent ConcurrencyPolicy = "Allow"
+	// ForbidConcurrent holds the new run until the unfinished ones finish.
+	// It is skipped if that takes longer than startingDeadlineSeconds.
+	ForbidConcurrent ConcurrencyPolicy = "Forbid"
+	// ReplaceConcurrent deletes the unfinished runs and starts the new one.
+	ReplaceConcurrent ConcurrencyPolicy = "Replace"
+)
+
+// ObservatoryRunTemplate is the run an ObservatoryCronRun stamps out. Its
+// labels and annotations are copied onto every run.
+// +kubebuilder:object:generate=true
+type ObservatoryRunTemplate struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ObservatoryRunSpec `json:"spec"`
+}
+
+// +kubebuilder:object:generate=true
+type ObservatoryCronRunSpec struct {
+	// Schedule is a five-field cron expression or a descriptor such as
+	// @daily.
+	Schedule string `json:"schedule"`
+	// TimeZone is the IANA zone the schedule is evaluated in, e.g.
+	// "Europe/Berlin". Defaults to UTC.
+	TimeZone *string `json:"timeZone,omitempty"`
+	// ConcurrencyPolicy defaults to Allow.
+	ConcurrencyPolicy ConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+	// StartingDeadlineSeconds bounds how late a missed run may still start,
+	// e.g. after controller downtime. Runs missed by more are skipped.
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+	// SuccessfulRunsHistoryLimit is how many succeeded runs to keep.
+	// Defaults to 3.
+	SuccessfulRunsHistoryLimit *int32 `json:"successfulRunsHistoryLimit,omitempty"`
+	// FailedRunsHistoryLimit is how many failed runs to keep. Defaults to 1.
+	FailedRunsHistoryLimit *int32 `json:"failedRunsHistoryLimit,omitempty"`
+
+	RunTemplate ObservatoryRunTemplate `json:"runTemplate"`
+}
+
+// +kubebuilder:object:generate=true
+type ObservatoryCronRunStatus struct {
+	// Active lists the runs started by this ObservatoryCronRun that have
+	// not finished.
+	Active []corev1.ObjectReference `json:"active,omitempty"`
+	// LastScheduleTime is the scheduled time of the most recent run.
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+	// Message explains why the schedule cannot be evaluated.
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+type ObservatoryCronRun struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ObservatoryCronRunSpec   `json:"spec,omitempty"`
+	Status ObservatoryCronRunStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type ObservatoryCronRunList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ObservatoryCronRun `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ObservatoryCronRun{}, &ObservatoryCronRunList{})
+}
@@ -0,0 +1,77 @@
+package v1alpha1
+
+import (
+	"path"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultProject names the ObservatoryProject that governs runs without a
+// spec.project. Runs without one are not limited while it does not exist.
+const DefaultProject = "default"
+
+// ObservatoryProjectSpec sets limits for every ObservatoryRun whose
+// spec.project equals the project's name. The run webhook rejects runs
+// naming a project that does not exist.
+// +kubebuilder:object:generate=true
+type ObservatoryProjectSpec struct {
+	// MaxConcurrentRuns caps how many runs of the project are admitted and
+	// unfinished at once. Further runs wait in the Queued phase and are
+	// admitted oldest first as capacity frees up.
+	MaxConcurrentRuns *int32 `json:"maxConcurrentRuns,omitempty"`
+	// MaxConcurrentTasks caps how many task Jobs run at once across all the
+	// project's runs.
+	MaxConcurrentTasks *int32 `json:"maxConcurrentTasks,omitempty"`
+	// AllowedImages restricts task images to those matching one of these
+	// path.Match patterns, e.g. "ghcr.io/acme/*". Empty allows any image.
+	AllowedImages []string `json:"allowedImages,omitempty"`
+	// AllowedNamespaces restricts which namespaces may run the project.
+	// Empty allows any namespace.
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+}
+
+// AllowsNamespace reports whether runs of the project may run in ns.
+func (s *ObservatoryProjectSpec) AllowsNamespace(ns string) bool {
+	if len(s.AllowedNamespaces) == 0 {
+		return true
+	}
+	for _, allowed := range s.AllowedNamespaces {
+		if allowed == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsImage reports whether tasks of the project may use image.
+func (s *ObservatoryProjectSpec) AllowsImage(image string) bool {
+	if len(s.AllowedImages) == 0 {
+		return true
+	}
+	for _, pattern := range s.AllowedImages {
+		if ok, err := path.Match(pattern, image); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+type ObservatoryProject struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ObservatoryProjectSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type ObservatoryProjectList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ObservatoryProject `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ObservatoryProject{}, &ObservatoryProjectList{})
+}
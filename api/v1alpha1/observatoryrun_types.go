@@ -1,23 +1,204 @@
 package v1alpha1
 
 import (
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // +kubebuilder:object:generate=true
 type TaskSpec struct {
+	// Type selects what runs the task; see the TaskType constants. Empty
+	// runs a Job.
 	Type         string   `json:"type,omitempty"`
 	Image        string   `json:"image,omitempty"`
 	Command      string   `json:"command,omitempty"`
 	Args         []string `json:"args,omitempty"`
 	Dependencies []string `json:"dependencies,omitempty"`
 	Retries      *int32   `json:"retries,omitempty"`
+	// Resources overrides the run-level resources for this task, key by key.
+	Resources *ResourcesSpec `json:"resources,omitempty"`
+	// Timeout bounds the task's Job via activeDeadlineSeconds, each call of
+	// an http task, or how long an approval task waits for a decision.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// Env is added to the task container. Values may reference upstream
+	// outputs with {{tasks.<task>.outputs.<name>}}, as may Command and Args.
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// Outputs are named values the task publishes for downstream tasks.
+	Outputs []OutputSpec `json:"outputs,omitempty"`
+	// When is evaluated once the task's dependencies are satisfied; if it is
+	// false the task is Skipped instead of run, e.g.
+	// `{{inputs.parameters.env}} == prod && {{tasks.build.outputs.changed}}`.
+	When string `json:"when,omitempty"`
+	// DependencyConditions sets, per dependency name, which outcome of that
+	// dependency lets this task run. Dependencies not listed use onSuccess.
+	DependencyConditions map[string]DependencyCondition `json:"dependencyConditions,omitempty"`
+	// WithItems fans the task out into one child Job per item. Children
+	// read their item through {{item}}.
+	WithItems []string `json:"withItems,omitempty"`
+	// WithParam fans the task out over a JSON list, usually an upstream
+	// output such as {{tasks.list.outputs.shards}}. String elements are
+	// passed to {{item}} as-is, other elements as compact JSON.
+	WithParam string `json:"withParam,omitempty"`
+	// Parallelism caps how many children of a fan-out task run at once.
+	// Unset runs them all.
+	Parallelism *int32 `json:"parallelism,omitempty"`
+	// Priority orders ready tasks when the workflow's parallelism limit
+	// leaves room for only some of them: higher first, then by name.
+	Priority int32 `json:"priority,omitempty"`
+	// RetryStrategy retries failed attempts with a fresh Job each, after a
+	// backoff. It replaces Retries, which restarts pods within one Job.
+	RetryStrategy *RetryStrategy `json:"retryStrategy,omitempty"`
+	// HTTP is the request made by a task of type http.
+	HTTP *HTTPSpec `json:"http,omitempty"`
+	// Approval configures a task of type approval.
+	Approval *ApprovalSpec `json:"approval,omitempty"`
+}
+
+// IsFanOut reports whether the task runs once per item.
+func (t *TaskSpec) IsFanOut() bool {
+	return len(t.WithItems) > 0 || t.WithParam != ""
+}
+
+// Task types.
+const (
+	// TaskTypeJob runs the task's image as a Job.
+	TaskTypeJob = "job"
+	// TaskTypeShell is an older name for TaskTypeJob.
+	TaskTypeShell = "shell"
+	// TaskTypeHTTP makes the request in TaskSpec.HTTP from the controller,
+	// without a pod. Timeout bounds each call, and Retries or RetryStrategy
+	// retry failed ones.
+	TaskTypeHTTP = "http"
+	// TaskTypeApproval runs nothing: the task waits in TaskAwaitingApproval
+	// until someone approves or rejects it through ApprovalAnnotationPrefix.
+	// Timeout bounds the wait; Approval.OnTimeout decides what then happens.
+	TaskTypeApproval = "approval"
+)
+
+// ApprovalSpec configures an approval task.
+// +kubebuilder:object:generate=true
+type ApprovalSpec struct {
+	// OnTimeout is what happens when the task's timeout passes without a
+	// decision. Defaults to Fail.
+	OnTimeout ApprovalTimeoutAction `json:"onTimeout,omitempty"`
+}
+
+// ApprovalTimeoutAction decides an approval task nobody decided in time.
+type ApprovalTimeoutAction string
+
+const (
+	// ApprovalTimeoutFail fails the task as TimedOut.
+	ApprovalTimeoutFail ApprovalTimeoutAction = "Fail"
+	// ApprovalTimeoutApprove approves the task as AutoApproved.
+	ApprovalTimeoutApprove ApprovalTimeoutAction = "Approve"
+)
+
+// HTTPSpec is the request of an http task. URL, Body and header values may
+// reference parameters and upstream outputs, as Command may. The task
+// succeeds on an expected response status.
+// +kubebuilder:object:generate=true
+type HTTPSpec struct {
+	// Method defaults to GET, or POST when Body is set.
+	Method string `json:"method,omitempty"`
+	// URL is called from the controller; its host must be one the
+	// controller's --http-allowed-hosts allows.
+	URL string `json:"url"`
+	// Headers are sent with the request.
+	Headers []HTTPHeader `json:"headers,omitempty"`
+	Body    string       `json:"body,omitempty"`
+	// ExpectedStatus lists the response codes that count as success. Any
+	// 2xx does when empty.
+	ExpectedStatus []int32 `json:"expectedStatus,omitempty"`
+}
+
+// HTTPHeader is a request header whose value is given inline or read from a
+// Secret in the run's namespace.
+// +kubebuilder:object:generate=true
+type HTTPHeader struct {
+	Name         string                    `json:"name"`
+	Value        string                    `json:"value,omitempty"`
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// DependencyCondition selects which outcome of a dependency satisfies it.
+type DependencyCondition string
+
+const (
+	// DependencyOnSuccess is satisfied when the dependency succeeded or was
+	// skipped by its `when` condition.
+	DependencyOnSuccess DependencyCondition = "onSuccess"
+	// DependencyOnFailure is satisfied when the dependency failed.
+	DependencyOnFailure DependencyCondition = "onFailure"
+	// DependencyAlways is satisfied once the dependency finished, whatever
+	// the outcome.
+	DependencyAlways DependencyCondition = "always"
+)
+
+// RetryPolicy selects which failed attempts a RetryStrategy retries.
+type RetryPolicy string
+
+const (
+	// RetryOnFailure retries every failed attempt.
+	RetryOnFailure RetryPolicy = "OnFailure"
+	// RetryOnError retries attempts that failed without the task's
+	// container exiting on its own: image pull errors, evicted or lost pods.
+	// For http tasks, calls that got no response or a 5xx one.
+	RetryOnError RetryPolicy = "OnError"
+	// RetryOnExitCode retries attempts whose container exited with one of
+	// RetryStrategy.ExitCodes, or http calls answered with one of them as
+	// their status.
+	RetryOnExitCode RetryPolicy = "OnExitCode"
+)
+
+// +kubebuilder:object:generate=true
+type RetryStrategy struct {
+	// Limit is the number of retries after the first attempt.
+	Limit int32 `json:"limit"`
+	// RetryOn defaults to OnFailure.
+	RetryOn   RetryPolicy `json:"retryOn,omitempty"`
+	ExitCodes []int32     `json:"exitCodes,omitempty"`
+	Backoff   *Backoff    `json:"backoff,omitempty"`
+}
+
+// Backoff delays the n-th retry (from 0) by Duration * Factor^n, capped at
+// MaxDuration.
+// +kubebuilder:object:generate=true
+type Backoff struct {
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// Factor defaults to 2.
+	Factor      *int32           `json:"factor,omitempty"`
+	MaxDuration *metav1.Duration `json:"maxDuration,omitempty"`
+}
+
+// OutputSpec declares a task output. Without a Path the task writes
+// name=value lines to /dev/termination-log itself; with a Path the file's
+// content is copied there after the task's command exits. Kubernetes caps the
+// termination message at 4KiB, so outputs are meant for short values
+// (digests, paths), not data.
+// +kubebuilder:object:generate=true
+type OutputSpec struct {
+	Name string `json:"name"`
+	Path string `json:"path,omitempty"`
+	// JSONPath selects an http task's output from its JSON response, e.g.
+	// {.items[0].id}. Without it the output is the whole response body.
+	JSONPath string `json:"jsonPath,omitempty"`
 }
 
 // +kubebuilder:object:generate=true
 type WorkflowSpec struct {
-	Tasks         map[string]TaskSpec `json:"tasks,omitempty"`
+	Tasks map[string]TaskSpec `json:"tasks,omitempty"`
+	// Finally tasks run once every task in Tasks has finished, whether the
+	// workflow succeeded or failed (including after FailurePolicy Stop).
+	// They may depend on each other but not on Tasks, and can read the
+	// workflow's outcome through {{run.phase}}.
+	Finally       map[string]TaskSpec `json:"finally,omitempty"`
 	FailurePolicy string              `json:"failurePolicy,omitempty"` // "Continue" (default) or "Stop"
+	// Parallelism caps how many task Jobs, fan-out children included, run
+	// at once across the workflow. Unset runs every ready task.
+	Parallelism *int32 `json:"parallelism,omitempty"`
 }
 
 // Back-compat alias: tests and older code expect 'Workflow'.
@@ -30,6 +211,33 @@ type ResourcesSpec struct {
 	Limits   map[string]string `json:"limits,omitempty"`
 }
 
+// MergeResources returns the effective resources for a task: the run-level
+// base with any keys set in override replacing the base value.
+func MergeResources(base, override *ResourcesSpec) *ResourcesSpec {
+	if base == nil && override == nil {
+		return nil
+	}
+	out := &ResourcesSpec{}
+	for _, rs := range []*ResourcesSpec{base, override} {
+		if rs == nil {
+			continue
+		}
+		for k, v := range rs.Requests {
+			if out.Requests == nil {
+				out.Requests = map[string]string{}
+			}
+			out.Requests[k] = v
+		}
+		for k, v := range rs.Limits {
+			if out.Limits == nil {
+				out.Limits = map[string]string{}
+			}
+			out.Limits[k] = v
+		}
+	}
+	return out
+}
+
 // +kubebuilder:object:generate=true
 type ObservabilitySpec struct {
 	OTel *OTelSpec `json:"otel,omitempty"`
@@ -41,12 +249,63 @@ type OTelSpec struct {
 	Attributes map[string]string `json:"attributes,omitempty"`
 }
 
+// ParameterSpec declares a run input referenced as
+// {{inputs.parameters.<name>}} in task image, command, args and env, and
+// in the url, body and header values of http tasks.
+// A parameter with neither Value nor Default is required.
+// +kubebuilder:object:generate=true
+type ParameterSpec struct {
+	Name        string   `json:"name"`
+	Value       *string  `json:"value,omitempty"`
+	Default     *string  `json:"default,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// ResolveParameters returns the effective value of every parameter: its
+// Value, else its Default. It fails on a missing required parameter or a
+// value outside Enum.
+func ResolveParameters(params []ParameterSpec) (map[string]string, error) {
+	out := make(map[string]string, len(params))
+	for _, p := range params {
+		var v string
+		switch {
+		case p.Value != nil:
+			v = *p.Value
+		case p.Default != nil:
+			v = *p.Default
+		default:
+			return nil, fmt.Errorf("parameter '%s' is required", p.Name)
+		}
+		if len(p.Enum) > 0 && !slices.Contains(p.Enum, v) {
+			return nil, fmt.Errorf("parameter '%s' value %q is not one of %v", p.Name, v, p.Enum)
+		}
+		out[p.Name] = v
+	}
+	return out, nil
+}
+
 // +kubebuilder:object:generate=true
 type ObservatoryRunSpec struct {
+	// Project names the run's ObservatoryProject, whose quotas and policies
+	// apply to the run. It must exist. Empty means the DefaultProject.
 	Project       string            `json:"project,omitempty"`
-	Workflow      WorkflowSpec      `json:"workflow"`
+	Parameters    []ParameterSpec   `json:"parameters,omitempty"`
+	Workflow      WorkflowSpec      `json:"workflow,omitempty"`
 	Resources     *ResourcesSpec    `json:"resources,omitempty"`
 	Observability *ObservabilitySpec `json:"observability,omitempty"`
+	// ActiveDeadline bounds the whole run, measured from its creation. When it
+	// passes, active Jobs are deleted and unfinished tasks fail as TimedOut.
+	ActiveDeadline *metav1.Duration `json:"activeDeadline,omitempty"`
+	// WorkflowTemplateRef runs a workflow template instead of an inline
+	// Workflow; Parameters then override the template's parameters.
+	WorkflowTemplateRef *WorkflowTemplateRef `json:"workflowTemplateRef,omitempty"`
+	// Suspend stops new tasks from starting while running ones finish.
+	// Clearing it resumes the run where it left off.
+	Suspend bool `json:"suspend,omitempty"`
+	// Cancel deletes the Jobs of running tasks and marks every unfinished
+	// task, finally tasks included, Cancelled. The run ends Cancelled.
+	Cancel bool `json:"cancel,omitempty"`
 }
 
 type TaskState string
@@ -56,6 +315,41 @@ const (
 	TaskRunning   TaskState = "Running"
 	TaskSucceeded TaskState = "Succeeded"
 	TaskFailed    TaskState = "Failed"
+	// TaskSkipped is a task whose `when` condition was false. It satisfies
+	// downstream dependencies like TaskSucceeded.
+	TaskSkipped TaskState = "Skipped"
+	// TaskCancelled is an unfinished task of a cancelled run.
+	TaskCancelled TaskState = "Cancelled"
+	// TaskAwaitingApproval is an approval task waiting for a decision.
+	TaskAwaitingApproval TaskState = "AwaitingApproval"
+)
+
+// Reasons recorded on TaskStatus and ObservatoryRunStatus.
+const (
+	// ReasonTimedOut marks a task whose Job hit its timeout, or a run (and its
+	// unfinished tasks) that passed spec.activeDeadline.
+	ReasonTimedOut = "TimedOut"
+	// ReasonInvalidParameters marks a run whose parameters could not be resolved.
+	ReasonInvalidParameters = "InvalidParameters"
+	// ReasonDependencyNotMet marks a task Skipped because a dependency
+	// finished with an outcome its dependency condition does not accept.
+	// Unlike a `when` skip, it does not satisfy onSuccess dependents.
+	ReasonDependencyNotMet = "DependencyNotMet"
+	// ReasonQueued marks a run waiting for its project's run quota.
+	ReasonQueued = "Queued"
+	// ReasonNotAllowed marks a run in a namespace, or a task using an image,
+	// that its ObservatoryProject does not allow.
+	ReasonNotAllowed = "NotAllowed"
+	// ReasonInvalidTemplate marks a run whose workflow template could not be
+	// loaded or whose parameters do not fit it.
+	ReasonInvalidTemplate = "InvalidTemplate"
+	// ReasonCancelled marks a run cancelled through spec.cancel, and its
+	// tasks that had not finished.
+	ReasonCancelled = "Cancelled"
+	// ReasonRejected marks an approval task that was rejected.
+	ReasonRejected = "Rejected"
+	// ReasonAutoApproved marks an approval task approved by its timeout.
+	ReasonAutoApproved = "AutoApproved"
 )
 
 // +kubebuilder:object:generate=true
@@ -63,25 +357,144 @@ type TaskStatus struct {
 	State   TaskState `json:"state,omitempty"`
 	JobName string    `json:"jobName,omitempty"`
 	Message string    `json:"message,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	// Outputs holds the declared outputs read from the succeeded task's pod.
+	Outputs map[string]string `json:"outputs,omitempty"`
+	// Approver is who approved or rejected an approval task, as the API
+	// server authenticated them.
+	Approver string `json:"approver,omitempty"`
+	// Attempt numbers, from 0, the current or last Job of a task with a
+	// retryStrategy.
+	Attempt int32 `json:"attempt,omitempty"`
+	// NextAttemptAt holds the next attempt back until its backoff passes.
+	NextAttemptAt *metav1.Time `json:"nextAttemptAt,omitempty"`
+	// StartedAt is when the task's first attempt started.
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// FinishedAt is when the task reached its final state.
+	FinishedAt *metav1.Time `json:"finishedAt,omitempty"`
+	// AttemptCount is the number of attempts so far, including those no
+	// longer listed in Attempts.
+	AttemptCount int32 `json:"attemptCount,omitempty"`
+	// Attempts holds the most recent attempts, oldest first. Only the last
+	// few are kept so the run status stays small.
+	Attempts []TaskAttempt `json:"attempts,omitempty"`
+}
+
+// TaskAttempt is one attempt of a task: a pod of its Job, or for a task
+// with a retryStrategy, the Job of that attempt.
+type TaskAttempt struct {
+	Attempt int32     `json:"attempt"`
+	JobName string    `json:"jobName"`
+	PodName string    `json:"podName,omitempty"`
+	State   TaskState `json:"state"`
+	// Reason is why the attempt ended, e.g. Completed, Error, OOMKilled,
+	// DeadlineExceeded, Evicted or ImagePullBackOff.
+	Reason     string       `json:"reason,omitempty"`
+	ExitCode   *int32       `json:"exitCode,omitempty"`
+	Message    string       `json:"message,omitempty"`
+	StartedAt  *metav1.Time `json:"startedAt,omitempty"`
+	FinishedAt *metav1.Time `json:"finishedAt,omitempty"`
 }
 
 type Phase string
 
 const (
 	PhasePending   Phase = "Pending"
+	PhaseQueued    Phase = "Queued" // waiting for its ObservatoryProject's run quota
 	PhaseRunning   Phase = "Running"
+	PhaseSuspended Phase = "Suspended" // spec.suspend is set; running tasks may still finish
 	PhaseSucceeded Phase = "Succeeded"
 	PhaseFailed    Phase = "Failed"
+	PhaseCancelled Phase = "Cancelled"
 )
 
 // +kubebuilder:object:generate=true
 type ObservatoryRunStatus struct {
 	Phase        Phase                 `json:"phase,omitempty"`
+	Reason       string                `json:"reason,omitempty"`
+	// Message explains a run-level Reason.
+	Message string `json:"message,omitempty"`
+	// Parameters records the values resolved when the run started; tasks
+	// are templated from these, not from later spec edits.
+	Parameters map[string]string `json:"parameters,omitempty"`
 	TaskStatuses map[string]*TaskStatus `json:"taskStatuses,omitempty"`
+	// Workflow is the template workflow snapshotted when a run with a
+	// workflowTemplateRef started; later template edits do not affect it.
+	Workflow *WorkflowSpec `json:"workflow,omitempty"`
+	// RetryGeneration counts how often the run was retried through
+	// RetryAnnotation.
+	RetryGeneration int64 `json:"retryGeneration,omitempty"`
+	// LastRetryTime is when the run was last retried. spec.activeDeadline
+	// is measured from here rather than from creation once set.
+	LastRetryTime *metav1.Time `json:"lastRetryTime,omitempty"`
+
+	// ObservedGeneration is the spec generation the status reflects.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are Ready, Progressing, Failed and Suspended, for
+	// `kubectl wait` and GitOps health checks.
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// StartTime is when the run was admitted; CompletionTime when it
+	// finished. A retry clears CompletionTime.
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// SucceededTasks, FailedTasks and TotalTasks count the workflow and
+	// finally tasks, a fan-out task counting once.
+	SucceededTasks int32 `json:"succeededTasks,omitempty"`
+	FailedTasks    int32 `json:"failedTasks,omitempty"`
+	TotalTasks     int32 `json:"totalTasks,omitempty"`
 }
 
+// Condition types of ObservatoryRunStatus.Conditions.
+const (
+	// ConditionReady is True once the run has succeeded.
+	ConditionReady = "Ready"
+	// ConditionProgressing is True while the run is pending, queued or
+	// running.
+	ConditionProgressing = "Progressing"
+	// ConditionFailed is True once the run has failed or was cancelled.
+	ConditionFailed = "Failed"
+	// ConditionSuspended is True while spec.suspend holds an unfinished run.
+	ConditionSuspended = "Suspended"
+)
+
+// RetryAnnotation retries a Failed or Cancelled run from the point of
+// failure when set to any value: failed and cancelled tasks, and those
+// skipped because of them, are reset and their Jobs deleted, while
+// succeeded tasks keep their outputs. Finally tasks run again. The
+// controller removes the annotation once it has acted on it.
+const RetryAnnotation = "observatory.seventh-horizon.io/retry"
+
+// ApprovalAnnotationPrefix followed by a task name decides that approval
+// task: ApprovalApprove approves it and ApprovalReject rejects it, e.g.
+//
+//	kubectl annotate observatoryrun/release approval.observatory.seventh-horizon.io/deploy=approve
+//
+// A retry of the run removes the annotations of tasks that had not
+// succeeded, so those are decided again.
+const ApprovalAnnotationPrefix = "approval.observatory.seventh-horizon.io/"
+
+// ApproverAnnotationPrefix followed by a task name records who set that
+// task's approval annotation. The mutating webhook sets it from the
+// admission request and overwrites any value users give it.
+const ApproverAnnotationPrefix = "approver.observatory.seventh-horizon.io/"
+
+// Values of an approval annotation.
+const (
+	ApprovalApprove = "approve"
+	ApprovalReject  = "reject"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Project",type=string,JSONPath=`.spec.project`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Succeeded",type=integer,JSONPath=`.status.succeededTasks`
+// +kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.failedTasks`
+// +kubebuilder:printcolumn:name="Total",type=integer,JSONPath=`.status.totalTasks`
+// +kubebuilder:printcolumn:name="Started",type=date,JSONPath=`.status.startTime`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 type ObservatoryRun struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -1,40 +1,232 @@
 package v1alpha1
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
 	"strings"
 
+	"github.com/example/observatory-operator/internal/templating"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/jsonpath"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 var observatoryrunlog = logf.Log.WithName("observatoryrun-resource")
 
-func (r *ObservatoryRun) SetupWebhookWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewWebhookManagedBy(mgr).For(r).Complete()
+// SetupWebhookWithManager serves the ObservatoryRun webhooks. onRejected,
+// when set, is called for every request the validating webhook rejects.
+func (r *ObservatoryRun) SetupWebhookWithManager(mgr ctrl.Manager, onRejected func()) error {
+	var v admission.CustomValidator = &runValidator{reader: mgr.GetAPIReader()}
+	if onRejected != nil {
+		v = &rejectionHook{CustomValidator: v, onRejected: onRejected}
+	}
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(v).
+		WithDefaulter(&approverRecorder{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-observatory-seventh-horizon-io-v1alpha1-observatoryrun,mutating=true,failurePolicy=fail,sideEffects=None,groups=observatory.seventh-horizon.io,resources=observatoryruns,verbs=create;update,versions=v1alpha1,name=mobservatoryrun.kb.io,admissionReviewVersions=v1
+
+// approverRecorder is the admission defaulter served for ObservatoryRuns.
+// It records who set each approval annotation, so the controller never
+// takes an approver's name from the user.
+type approverRecorder struct{}
+
+var _ admission.CustomDefaulter = &approverRecorder{}
+
+func (d *approverRecorder) Default(ctx context.Context, obj runtime.Object) error {
+	run := obj.(*ObservatoryRun)
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	var old *ObservatoryRun
+	if len(req.OldObject.Raw) > 0 {
+		old = &ObservatoryRun{}
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return err
+		}
+	}
+	recordApprovers(run, old, req.UserInfo.Username)
+	return nil
+}
+
+// recordApprovers sets the approver annotation of every approval annotation
+// that user set or changed since old, which is nil on create. Others keep
+// the approver old had, and approver annotations without an approval
+// annotation are dropped.
+func recordApprovers(run, old *ObservatoryRun, user string) {
+	var before map[string]string
+	if old != nil {
+		before = old.Annotations
+	}
+	approvers := map[string]string{}
+	for k, v := range run.Annotations {
+		task, ok := strings.CutPrefix(k, ApprovalAnnotationPrefix)
+		if !ok {
+			continue
+		}
+		key := ApproverAnnotationPrefix + task
+		if prev, seen := before[k]; !seen || prev != v {
+			approvers[key] = user
+		} else if who, ok := before[key]; ok {
+			approvers[key] = who
+		}
+	}
+	for k := range run.Annotations {
+		if strings.HasPrefix(k, ApproverAnnotationPrefix) {
+			delete(run.Annotations, k)
+		}
+	}
+	for k, v := range approvers {
+		run.Annotations[k] = v
+	}
 }
 
 // +kubebuilder:webhook:path=/validate-observatory-seventh-horizon-io-v1alpha1-observatoryrun,mutating=false,failurePolicy=fail,sideEffects=None,groups=observatory.seventh-horizon.io,resources=observatoryruns,verbs=create;update,versions=v1alpha1,name=vobservatoryrun.kb.io,admissionReviewVersions=v1
 
-var _ webhook.Validator = &ObservatoryRun{}
+// runValidator is the admission validator served for ObservatoryRuns. It
+// resolves workflowTemplateRef first, so a run is checked against the
+// workflow it will actually execute.
+type runValidator struct {
+	reader client.Reader
+}
+
+var _ admission.CustomValidator = &runValidator{}
 
-func (r *ObservatoryRun) ValidateCreate() (admission.Warnings, error) {
-	observatoryrunlog.Info("validate create", "name", r.Name)
-	return r.validate()
+func (v *runValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	run := obj.(*ObservatoryRun)
+	observatoryrunlog.Info("validate create", "name", run.Name)
+	if err := v.validateProject(ctx, run); err != nil {
+		return nil, err
+	}
+	return v.validate(ctx, run)
 }
 
-func (r *ObservatoryRun) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
-	observatoryrunlog.Info("validate update", "name", r.Name)
-	return r.validate()
+// ValidateUpdate only checks spec edits. Removing the finalizer and
+// setting annotations such as the retry annotation must go through even when
+// the run would no longer pass, e.g. because its template was deleted.
+func (v *runValidator) ValidateUpdate(ctx context.Context, oldObj, obj runtime.Object) (admission.Warnings, error) {
+	run, old := obj.(*ObservatoryRun), oldObj.(*ObservatoryRun)
+	observatoryrunlog.Info("validate update", "name", run.Name)
+	if !run.DeletionTimestamp.IsZero() || equality.Semantic.DeepEqual(old.Spec, run.Spec) {
+		return nil, nil
+	}
+	if run.Spec.Project != old.Spec.Project {
+		if err := v.validateProject(ctx, run); err != nil {
+			return nil, err
+		}
+	}
+	if run.Spec.WorkflowTemplateRef != nil && old.Status.Workflow != nil {
+		// A started run executes the workflow snapshotted in its status,
+		// whatever its template says now.
+		return validateSnapshot(run, old.Status)
+	}
+	return v.validate(ctx, run)
 }
 
-func (r *ObservatoryRun) ValidateDelete() (admission.Warnings, error) {
+func (v *runValidator) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
 	return nil, nil
 }
 
+// rejectionHook calls onRejected whenever the validator it wraps returns an
+// error.
+type rejectionHook struct {
+	admission.CustomValidator
+	onRejected func()
+}
+
+func (h *rejectionHook) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return h.observe(h.CustomValidator.ValidateCreate(ctx, obj))
+}
+
+func (h *rejectionHook) ValidateUpdate(ctx context.Context, old, obj runtime.Object) (admission.Warnings, error) {
+	return h.observe(h.CustomValidator.ValidateUpdate(ctx, old, obj))
+}
+
+func (h *rejectionHook) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return h.observe(h.CustomValidator.ValidateDelete(ctx, obj))
+}
+
+func (h *rejectionHook) observe(warns admission.Warnings, err error) (admission.Warnings, error) {
+	if err != nil {
+		h.onRejected()
+	}
+	return warns, err
+}
+
+func (v *runValidator) validate(ctx context.Context, r *ObservatoryRun) (admission.Warnings, error) {
+	ref := r.Spec.WorkflowTemplateRef
+	if ref == nil {
+		return r.validate()
+	}
+	if len(r.Spec.Workflow.Tasks) > 0 || len(r.Spec.Workflow.Finally) > 0 {
+		return nil, validationFailed([]string{"workflow and workflowTemplateRef are mutually exclusive"})
+	}
+	tmpl, err := GetWorkflowTemplate(ctx, v.reader, r.Namespace, ref)
+	if apierrors.IsNotFound(err) {
+		return nil, validationFailed([]string{fmt.Sprintf("workflowTemplateRef: template '%s' not found", ref.Name)})
+	} else if err != nil {
+		return nil, err
+	}
+	params, err := MergeTemplateParameters(tmpl.Parameters, r.Spec.Parameters)
+	if err != nil {
+		return nil, validationFailed([]string{"workflowTemplateRef: " + err.Error()})
+	}
+	merged := r.DeepCopy()
+	merged.Spec.Workflow = *tmpl.Workflow.DeepCopy()
+	merged.Spec.Parameters = params
+	return merged.validate()
+}
+
+// validateProject checks that the ObservatoryProject the run names exists,
+// so that a misspelled project does not escape its quotas and policies.
+func (v *runValidator) validateProject(ctx context.Context, run *ObservatoryRun) error {
+	name := run.Spec.Project
+	if name == "" {
+		return nil
+	}
+	notFound := validationFailed([]string{fmt.Sprintf("project: ObservatoryProject '%s' not found", name)})
+	if len(validation.IsDNS1123Subdomain(name)) > 0 {
+		return notFound
+	}
+	err := v.reader.Get(ctx, client.ObjectKey{Name: name}, &ObservatoryProject{})
+	if apierrors.IsNotFound(err) {
+		return notFound
+	}
+	return err
+}
+
+// validateSnapshot checks run against the workflow and parameters st
+// recorded when the run started.
+func validateSnapshot(run *ObservatoryRun, st ObservatoryRunStatus) (admission.Warnings, error) {
+	if len(run.Spec.Workflow.Tasks) > 0 || len(run.Spec.Workflow.Finally) > 0 {
+		return nil, validationFailed([]string{"workflow and workflowTemplateRef are mutually exclusive"})
+	}
+	merged := run.DeepCopy()
+	merged.Spec.Workflow = *st.Workflow.DeepCopy()
+	merged.Spec.Parameters = nil
+	for _, name := range sortedKeys(st.Parameters) {
+		value := st.Parameters[name]
+		merged.Spec.Parameters = append(merged.Spec.Parameters, ParameterSpec{Name: name, Value: &value})
+	}
+	return merged.validate()
+}
+
 func (r *ObservatoryRun) validate() (admission.Warnings, error) {
 	var errs []string
 	var warns admission.Warnings
@@ -43,35 +235,136 @@ func (r *ObservatoryRun) validate() (admission.Warnings, error) {
 		errs = append(errs, "workflow must have at least one task")
 	}
 
+	errs = append(errs, validateParameters(r.Spec.Parameters)...)
+	declared := map[string]bool{}
+	for _, p := range r.Spec.Parameters {
+		declared[p.Name] = true
+	}
+
 	for name, spec := range r.Spec.Workflow.Tasks {
-		if err := validateTaskName(name); err != nil {
-			errs = append(errs, fmt.Sprintf("task '%s': %v", name, err))
-		}
-		for _, dep := range spec.Dependencies {
-			if _, ok := r.Spec.Workflow.Tasks[dep]; !ok {
-				errs = append(errs, fmt.Sprintf("task '%s' depends on non-existent task '%s'", name, dep))
-			}
-			if dep == name {
-				errs = append(errs, fmt.Sprintf("task '%s' cannot depend on itself", name))
-			}
-		}
-		if spec.Retries != nil && *spec.Retries < 0 {
-			errs = append(errs, fmt.Sprintf("task '%s': retries cannot be negative", name))
-		}
-		if spec.Retries != nil && *spec.Retries > 10 {
-			warns = append(warns, fmt.Sprintf("task '%s' has high retry count (%d)", name, *spec.Retries))
+		e, w := r.validateTask(name, spec, r.Spec.Workflow.Tasks, false, declared)
+		errs, warns = append(errs, e...), append(warns, w...)
+	}
+	for name, spec := range r.Spec.Workflow.Finally {
+		if _, ok := r.Spec.Workflow.Tasks[name]; ok {
+			errs = append(errs, fmt.Sprintf("finally task '%s' has the same name as a workflow task", name))
 		}
+		e, w := r.validateTask(name, spec, r.Spec.Workflow.Finally, true, declared)
+		errs, warns = append(errs, e...), append(warns, w...)
 	}
+	errs = append(errs, r.validateApprovals()...)
+	if p := r.Spec.Workflow.Parallelism; p != nil && *p < 1 {
+		errs = append(errs, "workflow parallelism must be at least 1")
+	}
+	if r.Spec.ActiveDeadline != nil && r.Spec.ActiveDeadline.Duration <= 0 {
+		errs = append(errs, "activeDeadline must be positive")
+	}
+	errs = append(errs, validateResources("resources", r.Spec.Resources)...)
+	errs = append(errs, validateRequestsWithinLimits("resources", r.Spec.Resources)...)
 	if err := validateNoCycles(r.Spec.Workflow.Tasks); err != nil {
 		errs = append(errs, err.Error())
 	}
+	if err := validateNoCycles(r.Spec.Workflow.Finally); err != nil {
+		errs = append(errs, "finally: "+err.Error())
+	}
 
 	if len(errs) > 0 {
-		return warns, fmt.Errorf("validation failed:\n  - %s", strings.Join(errs, "\n  - "))
+		return warns, validationFailed(errs)
 	}
 	return warns, nil
 }
 
+func validationFailed(errs []string) error {
+	return fmt.Errorf("validation failed:\n  - %s", strings.Join(errs, "\n  - "))
+}
+
+// validateTask checks one task against its siblings: the workflow tasks, or
+// the finally tasks when finally is set.
+func (r *ObservatoryRun) validateTask(name string, spec TaskSpec, siblings map[string]TaskSpec, finally bool, declared map[string]bool) (errs []string, warns admission.Warnings) {
+	if err := validateTaskName(name); err != nil {
+		errs = append(errs, fmt.Sprintf("task '%s': %v", name, err))
+	}
+	switch spec.Type {
+	case "", TaskTypeJob, TaskTypeShell:
+		if spec.HTTP != nil {
+			errs = append(errs, fmt.Sprintf("task '%s': http requires type http", name))
+		}
+		for _, o := range spec.Outputs {
+			if o.JSONPath != "" {
+				errs = append(errs, fmt.Sprintf("task '%s' output '%s': jsonPath is only for http tasks", name, o.Name))
+			}
+		}
+	case TaskTypeHTTP:
+		errs = append(errs, validateHTTP(name, spec)...)
+	case TaskTypeApproval:
+		errs = append(errs, validateApproval(name, spec)...)
+	default:
+		errs = append(errs, fmt.Sprintf("task '%s': unknown type '%s' (want job, http or approval)", name, spec.Type))
+	}
+	if spec.Approval != nil && spec.Type != TaskTypeApproval {
+		errs = append(errs, fmt.Sprintf("task '%s': approval requires type approval", name))
+	}
+	for _, dep := range spec.Dependencies {
+		if _, ok := siblings[dep]; !ok {
+			if _, main := r.Spec.Workflow.Tasks[dep]; finally && main {
+				errs = append(errs, fmt.Sprintf("finally task '%s' cannot depend on workflow task '%s'; finally tasks already run after every workflow task", name, dep))
+			} else {
+				errs = append(errs, fmt.Sprintf("task '%s' depends on non-existent task '%s'", name, dep))
+			}
+		}
+		if dep == name {
+			errs = append(errs, fmt.Sprintf("task '%s' cannot depend on itself", name))
+		}
+	}
+	for _, dep := range sortedKeys(spec.DependencyConditions) {
+		if !slices.Contains(spec.Dependencies, dep) {
+			errs = append(errs, fmt.Sprintf("task '%s' has a dependency condition for '%s', which is not one of its dependencies", name, dep))
+		}
+		switch c := spec.DependencyConditions[dep]; c {
+		case DependencyOnSuccess, DependencyOnFailure, DependencyAlways:
+		default:
+			errs = append(errs, fmt.Sprintf("task '%s' dependency '%s': unknown condition '%s' (want onSuccess, onFailure or always)", name, dep, c))
+		}
+	}
+	if spec.Retries != nil && *spec.Retries < 0 {
+		errs = append(errs, fmt.Sprintf("task '%s': retries cannot be negative", name))
+	}
+	if spec.Retries != nil && *spec.Retries > 10 {
+		warns = append(warns, fmt.Sprintf("task '%s' has high retry count (%d)", name, *spec.Retries))
+	}
+	errs = append(errs, validateOutputs(name, spec.Outputs)...)
+	errs = append(errs, validateFanOut(name, spec)...)
+	errs = append(errs, validateRetryStrategy(name, spec)...)
+	upstream := map[string]TaskSpec{}
+	for dep := range ancestors(name, siblings) {
+		upstream[dep] = siblings[dep]
+	}
+	if finally {
+		for n, t := range r.Spec.Workflow.Tasks {
+			upstream[n] = t
+		}
+	}
+	errs = append(errs, validateTaskRefs(name, spec, upstream, declared, finally)...)
+	if spec.When != "" {
+		if _, err := templating.ParseCondition(spec.When); err != nil {
+			errs = append(errs, fmt.Sprintf("task '%s' when: %v", name, err))
+		}
+	}
+	if spec.Timeout != nil {
+		if spec.Timeout.Duration <= 0 {
+			errs = append(errs, fmt.Sprintf("task '%s': timeout must be positive", name))
+		} else if d := r.Spec.ActiveDeadline; d != nil && spec.Timeout.Duration > d.Duration {
+			warns = append(warns, fmt.Sprintf("task '%s' timeout (%s) exceeds the run activeDeadline (%s)", name, spec.Timeout.Duration, d.Duration))
+		}
+	}
+	if spec.Resources != nil {
+		field := fmt.Sprintf("task '%s' resources", name)
+		errs = append(errs, validateResources(field, spec.Resources)...)
+		errs = append(errs, validateRequestsWithinLimits(field, MergeResources(r.Spec.Resources, spec.Resources))...)
+	}
+	return errs, warns
+}
+
 func validateTaskName(name string) error {
 	if name == "" { return fmt.Errorf("task name cannot be empty") }
 	if len(name) > 63 { return fmt.Errorf("task name too long (max 63)") }
@@ -87,6 +380,350 @@ func validateTaskName(name string) error {
 	return nil
 }
 
+func validateOutputs(task string, outputs []OutputSpec) []string {
+	var errs []string
+	seen := map[string]bool{}
+	for _, o := range outputs {
+		if err := validateTaskName(o.Name); err != nil {
+			errs = append(errs, fmt.Sprintf("task '%s' output '%s': %v", task, o.Name, err))
+		}
+		if seen[o.Name] {
+			errs = append(errs, fmt.Sprintf("task '%s' declares output '%s' more than once", task, o.Name))
+		}
+		seen[o.Name] = true
+	}
+	return errs
+}
+
+// validateHTTP checks the request of an http task. A URL without
+// placeholders must already be an absolute http or https URL.
+func validateHTTP(task string, spec TaskSpec) []string {
+	h := spec.HTTP
+	if h == nil {
+		return []string{fmt.Sprintf("task '%s': type http requires http", task)}
+	}
+	var errs []string
+	if spec.Image != "" || spec.Command != "" || len(spec.Args) > 0 || len(spec.Env) > 0 {
+		errs = append(errs, fmt.Sprintf("task '%s': http tasks run no container and cannot set image, command, args or env", task))
+	}
+	switch {
+	case h.URL == "":
+		errs = append(errs, fmt.Sprintf("task '%s': http.url is required", task))
+	case len(templating.Refs(h.URL)) == 0:
+		if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("task '%s': http.url %q is not an absolute http or https URL", task, h.URL))
+		}
+	}
+	switch h.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		errs = append(errs, fmt.Sprintf("task '%s': unknown http.method '%s'", task, h.Method))
+	}
+	for i, hdr := range h.Headers {
+		switch {
+		case hdr.Name == "":
+			errs = append(errs, fmt.Sprintf("task '%s': http.headers[%d] has no name", task, i))
+		case (hdr.Value != "") == (hdr.SecretKeyRef != nil):
+			errs = append(errs, fmt.Sprintf("task '%s': http header '%s' needs exactly one of value and secretKeyRef", task, hdr.Name))
+		case hdr.SecretKeyRef != nil && (hdr.SecretKeyRef.Name == "" || hdr.SecretKeyRef.Key == ""):
+			errs = append(errs, fmt.Sprintf("task '%s': http header '%s' secretKeyRef needs a name and a key", task, hdr.Name))
+		}
+	}
+	for _, code := range h.ExpectedStatus {
+		if code < 100 || code > 599 {
+			errs = append(errs, fmt.Sprintf("task '%s': http.expectedStatus %d is not an HTTP status", task, code))
+		}
+	}
+	for _, o := range spec.Outputs {
+		if o.Path != "" {
+			errs = append(errs, fmt.Sprintf("task '%s' output '%s': path is only for job tasks; use jsonPath", task, o.Name))
+		}
+		if o.JSONPath != "" {
+			if err := jsonpath.New(o.Name).Parse(o.JSONPath); err != nil {
+				errs = append(errs, fmt.Sprintf("task '%s' output '%s' jsonPath: %v", task, o.Name, err))
+			}
+		}
+	}
+	return errs
+}
+
+// validateApproval checks an approval task, which runs nothing and so
+// takes none of the fields that describe what to run.
+func validateApproval(task string, spec TaskSpec) []string {
+	var errs []string
+	if spec.Image != "" || spec.Command != "" || len(spec.Args) > 0 || len(spec.Env) > 0 || spec.HTTP != nil || spec.Resources != nil {
+		errs = append(errs, fmt.Sprintf("task '%s': approval tasks run nothing and cannot set image, command, args, env, http or resources", task))
+	}
+	if spec.Retries != nil || spec.RetryStrategy != nil {
+		errs = append(errs, fmt.Sprintf("task '%s': approval tasks cannot be retried; retry the run instead", task))
+	}
+	if len(spec.Outputs) > 0 || spec.IsFanOut() {
+		errs = append(errs, fmt.Sprintf("task '%s': approval tasks cannot declare outputs or fan out", task))
+	}
+	if a := spec.Approval; a != nil {
+		switch a.OnTimeout {
+		case "", ApprovalTimeoutFail:
+		case ApprovalTimeoutApprove:
+			if spec.Timeout == nil {
+				errs = append(errs, fmt.Sprintf("task '%s': approval.onTimeout Approve requires a timeout", task))
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("task '%s': unknown approval.onTimeout '%s' (want Fail or Approve)", task, a.OnTimeout))
+		}
+	}
+	return errs
+}
+
+// validateApprovals checks the run's approval annotations: each must name
+// an approval task and approve or reject it.
+func (r *ObservatoryRun) validateApprovals() []string {
+	var errs []string
+	for _, k := range sortedKeys(r.Annotations) {
+		task, ok := strings.CutPrefix(k, ApprovalAnnotationPrefix)
+		if !ok {
+			continue
+		}
+		spec, found := r.Spec.Workflow.Tasks[task]
+		if !found {
+			spec, found = r.Spec.Workflow.Finally[task]
+		}
+		if !found || spec.Type != TaskTypeApproval {
+			errs = append(errs, fmt.Sprintf("annotation %s: '%s' is not an approval task", k, task))
+		}
+		if v := r.Annotations[k]; v != ApprovalApprove && v != ApprovalReject {
+			errs = append(errs, fmt.Sprintf("annotation %s: unknown value '%s' (want %s or %s)", k, v, ApprovalApprove, ApprovalReject))
+		}
+	}
+	return errs
+}
+
+// validateFanOut checks withItems, withParam and parallelism. A literal
+// withParam (one without placeholders) must already be a JSON list.
+func validateFanOut(task string, spec TaskSpec) []string {
+	var errs []string
+	if len(spec.WithItems) > 0 && spec.WithParam != "" {
+		errs = append(errs, fmt.Sprintf("task '%s': withItems and withParam are mutually exclusive", task))
+	}
+	if spec.WithParam != "" && len(templating.Refs(spec.WithParam)) == 0 {
+		var items []json.RawMessage
+		if err := json.Unmarshal([]byte(spec.WithParam), &items); err != nil {
+			errs = append(errs, fmt.Sprintf("task '%s': withParam is not a JSON list: %v", task, err))
+		}
+	}
+	if spec.Parallelism != nil {
+		if !spec.IsFanOut() {
+			errs = append(errs, fmt.Sprintf("task '%s': parallelism requires withItems or withParam", task))
+		} else if *spec.Parallelism < 1 {
+			errs = append(errs, fmt.Sprintf("task '%s': parallelism must be at least 1", task))
+		}
+	}
+	if spec.IsFanOut() && len(spec.Outputs) > 0 {
+		errs = append(errs, fmt.Sprintf("task '%s': fan-out tasks cannot declare outputs", task))
+	}
+	return errs
+}
+
+func validateRetryStrategy(task string, spec TaskSpec) []string {
+	rs := spec.RetryStrategy
+	if rs == nil {
+		return nil
+	}
+	var errs []string
+	if spec.Retries != nil {
+		errs = append(errs, fmt.Sprintf("task '%s': retries and retryStrategy are mutually exclusive", task))
+	}
+	if rs.Limit < 0 {
+		errs = append(errs, fmt.Sprintf("task '%s': retryStrategy.limit cannot be negative", task))
+	}
+	switch rs.RetryOn {
+	case "", RetryOnFailure, RetryOnError:
+		if len(rs.ExitCodes) > 0 {
+			errs = append(errs, fmt.Sprintf("task '%s': retryStrategy.exitCodes requires retryOn OnExitCode", task))
+		}
+	case RetryOnExitCode:
+		if len(rs.ExitCodes) == 0 {
+			errs = append(errs, fmt.Sprintf("task '%s': retryOn OnExitCode requires retryStrategy.exitCodes", task))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("task '%s': unknown retryOn '%s' (want OnFailure, OnError or OnExitCode)", task, rs.RetryOn))
+	}
+	if b := rs.Backoff; b != nil {
+		if b.Duration != nil && b.Duration.Duration < 0 || b.MaxDuration != nil && b.MaxDuration.Duration < 0 {
+			errs = append(errs, fmt.Sprintf("task '%s': retryStrategy.backoff durations cannot be negative", task))
+		}
+		if b.Factor != nil && *b.Factor < 1 {
+			errs = append(errs, fmt.Sprintf("task '%s': retryStrategy.backoff.factor must be at least 1", task))
+		}
+	}
+	return errs
+}
+
+func validateParameters(params []ParameterSpec) []string {
+	var errs []string
+	seen := map[string]bool{}
+	for _, p := range params {
+		if err := validateTaskName(p.Name); err != nil {
+			errs = append(errs, fmt.Sprintf("parameter '%s': %v", p.Name, err))
+		}
+		if seen[p.Name] {
+			errs = append(errs, fmt.Sprintf("parameter '%s' is declared more than once", p.Name))
+		}
+		seen[p.Name] = true
+		if p.Default != nil && len(p.Enum) > 0 && !slices.Contains(p.Enum, *p.Default) {
+			errs = append(errs, fmt.Sprintf("parameter '%s' default %q is not one of %v", p.Name, *p.Default, p.Enum))
+		}
+	}
+	if len(errs) == 0 {
+		if _, err := ResolveParameters(params); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	return errs
+}
+
+// templatedFields returns the task fields that accept {{...}} placeholders,
+// keyed by a human-readable field path.
+func templatedFields(spec TaskSpec) map[string]string {
+	fields := map[string]string{"image": spec.Image, "command": spec.Command, "when": spec.When, "withParam": spec.WithParam}
+	for i, a := range spec.Args {
+		fields[fmt.Sprintf("args[%d]", i)] = a
+	}
+	for _, e := range spec.Env {
+		fields[fmt.Sprintf("env[%s]", e.Name)] = e.Value
+	}
+	if h := spec.HTTP; h != nil {
+		fields["http.url"], fields["http.body"] = h.URL, h.Body
+		for _, hdr := range h.Headers {
+			fields[fmt.Sprintf("http.headers[%s]", hdr.Name)] = hdr.Value
+		}
+	}
+	return fields
+}
+
+// validateTaskRefs checks every placeholder in a task's templated fields.
+// Parameter references must name a declared parameter. Output references
+// must name a task in upstream (the tasks that have finished before this
+// one starts) and an output that task declares. {{run.phase}} is only
+// available to finally tasks.
+func validateTaskRefs(name string, spec TaskSpec, upstream map[string]TaskSpec, params map[string]bool, finally bool) []string {
+	var errs []string
+	fields := templatedFields(spec)
+	for _, field := range sortedKeys(fields) {
+		for _, expr := range templating.Refs(fields[field]) {
+			if expr == templating.RunPhaseRef {
+				if !finally {
+					errs = append(errs, fmt.Sprintf("task '%s' %s: {{%s}} is only available to finally tasks", name, field, expr))
+				}
+				continue
+			}
+			if expr == templating.ItemRef {
+				if !spec.IsFanOut() || field == "withParam" {
+					errs = append(errs, fmt.Sprintf("task '%s' %s: {{%s}} is only available to the children of fan-out tasks", name, field, expr))
+				}
+				continue
+			}
+			if param, ok, err := templating.ParseParameterRef(expr); ok {
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("task '%s' %s: %v", name, field, err))
+				} else if !params[param] {
+					errs = append(errs, fmt.Sprintf("task '%s' %s: {{%s}} references undeclared parameter '%s'", name, field, expr, param))
+				}
+				continue
+			}
+			ref, ok, err := templating.ParseTaskOutputRef(expr)
+			up, isUpstream := upstream[ref.Task]
+			switch {
+			case err != nil:
+				errs = append(errs, fmt.Sprintf("task '%s' %s: %v", name, field, err))
+			case !ok:
+				errs = append(errs, fmt.Sprintf("task '%s' %s: unsupported reference {{%s}}", name, field, expr))
+			case !isUpstream:
+				errs = append(errs, fmt.Sprintf("task '%s' %s: {{%s}} references '%s', which is not an upstream dependency", name, field, expr, ref.Task))
+			case !declaresOutput(up, ref.Output):
+				errs = append(errs, fmt.Sprintf("task '%s' %s: task '%s' does not declare output '%s'", name, field, ref.Task, ref.Output))
+			}
+		}
+	}
+	return errs
+}
+
+// ancestors returns every task that name transitively depends on.
+func ancestors(name string, tasks map[string]TaskSpec) map[string]bool {
+	seen := map[string]bool{}
+	stack := append([]string{}, tasks[name].Dependencies...)
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		stack = append(stack, tasks[n].Dependencies...)
+	}
+	return seen
+}
+
+func declaresOutput(spec TaskSpec, output string) bool {
+	for _, o := range spec.Outputs {
+		if o.Name == output {
+			return true
+		}
+	}
+	return false
+}
+
+// validateResources checks that every request and limit parses as a
+// resource.Quantity.
+func validateResources(field string, rs *ResourcesSpec) []string {
+	if rs == nil {
+		return nil
+	}
+	var errs []string
+	for _, k := range sortedKeys(rs.Requests) {
+		if _, err := resource.ParseQuantity(rs.Requests[k]); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: invalid requests.%s %q: %v", field, k, rs.Requests[k], err))
+		}
+	}
+	for _, k := range sortedKeys(rs.Limits) {
+		if _, err := resource.ParseQuantity(rs.Limits[k]); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: invalid limits.%s %q: %v", field, k, rs.Limits[k], err))
+		}
+	}
+	return errs
+}
+
+// validateRequestsWithinLimits rejects requests larger than the matching
+// limit. Unparsable values are skipped; validateResources reports those.
+func validateRequestsWithinLimits(field string, rs *ResourcesSpec) []string {
+	if rs == nil {
+		return nil
+	}
+	var errs []string
+	for _, k := range sortedKeys(rs.Requests) {
+		req, err := resource.ParseQuantity(rs.Requests[k])
+		if err != nil {
+			continue
+		}
+		lim, err := resource.ParseQuantity(rs.Limits[k])
+		if err != nil {
+			continue
+		}
+		if req.Cmp(lim) > 0 {
+			errs = append(errs, fmt.Sprintf("%s: requests.%s (%s) exceeds limits.%s (%s)", field, k, rs.Requests[k], k, rs.Limits[k]))
+		}
+	}
+	return errs
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func validateNoCycles(tasks map[string]TaskSpec) error {
 	graph := map[string][]string{}
 	for n, t := range tasks {
@@ -0,0 +1,514 @@
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func runWithTasks(tasks map[string]TaskSpec) *ObservatoryRun {
+	return &ObservatoryRun{
+		Spec: ObservatoryRunSpec{
+			Project:  "test",
+			Workflow: WorkflowSpec{Tasks: tasks},
+		},
+	}
+}
+
+func TestValidateResources(t *testing.T) {
+	g := NewWithT(t)
+
+	run := runWithTasks(map[string]TaskSpec{
+		"a": {Resources: &ResourcesSpec{Requests: map[string]string{"memory": "256Mi"}}},
+	})
+	run.Spec.Resources = &ResourcesSpec{
+		Requests: map[string]string{"cpu": "100m"},
+		Limits:   map[string]string{"cpu": "1", "memory": "512Mi"},
+	}
+	_, err := run.validate()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	run.Spec.Resources.Requests["cpu"] = "lots"
+	_, err = run.validate()
+	g.Expect(err).To(MatchError(ContainSubstring(`invalid requests.cpu "lots"`)))
+}
+
+func TestValidateTaskRequestsWithinMergedLimits(t *testing.T) {
+	g := NewWithT(t)
+
+	run := runWithTasks(map[string]TaskSpec{
+		"a": {Resources: &ResourcesSpec{Requests: map[string]string{"memory": "1Gi"}}},
+	})
+	run.Spec.Resources = &ResourcesSpec{Limits: map[string]string{"memory": "512Mi"}}
+
+	_, err := run.validate()
+	g.Expect(err).To(MatchError(ContainSubstring("task 'a' resources: requests.memory (1Gi) exceeds limits.memory (512Mi)")))
+}
+
+func TestValidateOutputReferences(t *testing.T) {
+	g := NewWithT(t)
+
+	tasks := map[string]TaskSpec{
+		"a": {Outputs: []OutputSpec{{Name: "digest"}}},
+		"b": {Dependencies: []string{"a"}},
+		"c": {
+			Dependencies: []string{"b"},
+			Command:      "deploy {{tasks.a.outputs.digest}}",
+			Env:          []corev1.EnvVar{{Name: "D", Value: "{{ tasks.a.outputs.digest }}"}},
+		},
+	}
+	_, err := runWithTasks(tasks).validate()
+	g.Expect(err).NotTo(HaveOccurred(), "transitive upstream outputs are allowed")
+
+	tasks["d"] = TaskSpec{Args: []string{"{{tasks.c.outputs.digest}}"}}
+	tasks["e"] = TaskSpec{Dependencies: []string{"a"}, Command: "{{tasks.a.outputs.missing}}"}
+	_, err = runWithTasks(tasks).validate()
+	g.Expect(err).To(MatchError(ContainSubstring("task 'd' args[0]: {{tasks.c.outputs.digest}} references 'c', which is not an upstream dependency")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'e' command: task 'a' does not declare output 'missing'")))
+}
+
+func TestValidateParameters(t *testing.T) {
+	g := NewWithT(t)
+	str := func(s string) *string { return &s }
+
+	run := runWithTasks(map[string]TaskSpec{
+		"a": {Image: "busybox:{{inputs.parameters.tag}}", Command: "echo {{inputs.parameters.env}}"},
+	})
+	run.Spec.Parameters = []ParameterSpec{
+		{Name: "env", Enum: []string{"dev", "prod"}, Value: str("prod")},
+		{Name: "tag", Default: str("1.36")},
+	}
+	_, err := run.validate()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	run.Spec.Parameters[0].Value = nil
+	run.Spec.Workflow.Tasks["b"] = TaskSpec{Args: []string{"{{inputs.parameters.nope}}"}}
+	_, err = run.validate()
+	g.Expect(err).To(MatchError(ContainSubstring("parameter 'env' is required")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'b' args[0]: {{inputs.parameters.nope}} references undeclared parameter 'nope'")))
+
+	run.Spec.Parameters[0].Value = str("staging")
+	_, err = run.validate()
+	g.Expect(err).To(MatchError(ContainSubstring(`parameter 'env' value "staging" is not one of [dev prod]`)))
+}
+
+func TestValidateWhen(t *testing.T) {
+	g := NewWithT(t)
+
+	run := runWithTasks(map[string]TaskSpec{
+		"a": {},
+		"b": {Dependencies: []string{"a"}, When: "{{inputs.parameters.env}} == prod &&"},
+		"c": {When: "{{inputs.parameters.missing}} == x"},
+	})
+	run.Spec.Parameters = []ParameterSpec{{Name: "env", Default: new(string)}}
+	_, err := run.validate()
+	g.Expect(err).To(MatchError(ContainSubstring("task 'b' when: unexpected end of expression")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'c' when: {{inputs.parameters.missing}} references undeclared parameter 'missing'")))
+}
+
+func TestValidateDependencyConditions(t *testing.T) {
+	g := NewWithT(t)
+
+	run := runWithTasks(map[string]TaskSpec{
+		"a": {},
+		"b": {Dependencies: []string{"a"}, DependencyConditions: map[string]DependencyCondition{"a": DependencyOnFailure}},
+	})
+	_, err := run.validate()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	run.Spec.Workflow.Tasks["c"] = TaskSpec{
+		Dependencies:         []string{"a"},
+		DependencyConditions: map[string]DependencyCondition{"a": "sometimes", "b": DependencyAlways},
+	}
+	_, err = run.validate()
+	g.Expect(err).To(MatchError(ContainSubstring("task 'c' dependency 'a': unknown condition 'sometimes'")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'c' has a dependency condition for 'b', which is not one of its dependencies")))
+}
+
+func TestValidateFinally(t *testing.T) {
+	g := NewWithT(t)
+
+	run := runWithTasks(map[string]TaskSpec{
+		"build": {Outputs: []OutputSpec{{Name: "digest"}}},
+	})
+	run.Spec.Workflow.Finally = map[string]TaskSpec{
+		"unlock": {},
+		"report": {Dependencies: []string{"unlock"}, Command: "echo {{run.phase}} {{tasks.build.outputs.digest}}"},
+	}
+	_, err := run.validate()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	run.Spec.Workflow.Tasks["deploy"] = TaskSpec{Command: "echo {{run.phase}}"}
+	run.Spec.Workflow.Finally["build"] = TaskSpec{}
+	run.Spec.Workflow.Finally["upload"] = TaskSpec{Dependencies: []string{"deploy"}}
+	_, err = run.validate()
+	g.Expect(err).To(MatchError(ContainSubstring("task 'deploy' command: {{run.phase}} is only available to finally tasks")))
+	g.Expect(err).To(MatchError(ContainSubstring("finally task 'build' has the same name as a workflow task")))
+	g.Expect(err).To(MatchError(ContainSubstring("finally task 'upload' cannot depend on workflow task 'deploy'")))
+}
+
+func TestValidateFanOut(t *testing.T) {
+	g := NewWithT(t)
+
+	zero := int32(0)
+	run := runWithTasks(map[string]TaskSpec{
+		"list":  {Outputs: []OutputSpec{{Name: "shards"}}},
+		"shard": {Dependencies: []string{"list"}, WithParam: "{{tasks.list.outputs.shards}}", Command: "run {{item}}"},
+		"fixed": {WithItems: []string{"eu", "us"}, Command: "deploy {{item}}"},
+	})
+	_, err := run.validate()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	run.Spec.Workflow.Tasks["both"] = TaskSpec{WithItems: []string{"x"}, WithParam: `["y"]`, Parallelism: &zero}
+	run.Spec.Workflow.Tasks["literal"] = TaskSpec{WithParam: "x,y", Outputs: []OutputSpec{{Name: "o"}}}
+	run.Spec.Workflow.Tasks["plain"] = TaskSpec{Command: "echo {{item}}"}
+	_, err = run.validate()
+	g.Expect(err).To(MatchError(ContainSubstring("task 'both': withItems and withParam are mutually exclusive")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'both': parallelism must be at least 1")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'literal': withParam is not a JSON list")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'literal': fan-out tasks cannot declare outputs")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'plain' command: {{item}} is only available to the children of fan-out tasks")))
+}
+
+func TestValidateTaskType(t *testing.T) {
+	g := NewWithT(t)
+
+	run := runWithTasks(map[string]TaskSpec{"a": {}, "b": {Type: TaskTypeJob}, "c": {Type: TaskTypeShell}})
+	_, err := run.validate()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	run.Spec.Workflow.Tasks["d"] = TaskSpec{Type: "lambda"}
+	_, err = run.validate()
+	g.Expect(err).To(MatchError(ContainSubstring("task 'd': unknown type 'lambda'")))
+}
+
+func TestValidateHTTPTask(t *testing.T) {
+	g := NewWithT(t)
+
+	run := runWithTasks(map[string]TaskSpec{
+		"notify": {Type: TaskTypeHTTP, HTTP: &HTTPSpec{
+			Method:         "POST",
+			URL:            "https://hooks.example.com/{{inputs.parameters.channel}}",
+			Headers:        []HTTPHeader{{Name: "Authorization", SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "hook"}, Key: "token"}}},
+			Body:           `{"text": "done"}`,
+			ExpectedStatus: []int32{200, 202},
+		}, Outputs: []OutputSpec{{Name: "id", JSONPath: "{.id}"}}},
+	})
+	channel := "ops"
+	run.Spec.Parameters = []ParameterSpec{{Name: "channel", Default: &channel}}
+	_, err := run.validate()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	run.Spec.Workflow.Tasks["bare"] = TaskSpec{Type: TaskTypeHTTP}
+	run.Spec.Workflow.Tasks["bad"] = TaskSpec{Type: TaskTypeHTTP, Image: "curl", HTTP: &HTTPSpec{
+		Method:         "FETCH",
+		URL:            "hooks.example.com/x",
+		Headers:        []HTTPHeader{{Name: "X-Token"}},
+		ExpectedStatus: []int32{42},
+	}, Outputs: []OutputSpec{{Name: "f", Path: "/out"}, {Name: "j", JSONPath: "{.items["}}}
+	run.Spec.Workflow.Tasks["job"] = TaskSpec{HTTP: &HTTPSpec{URL: "http://x"}, Outputs: []OutputSpec{{Name: "j", JSONPath: "{.id}"}}}
+	_, err = run.validate()
+	g.Expect(err).To(MatchError(ContainSubstring("task 'bare': type http requires http")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'bad': http tasks run no container")))
+	g.Expect(err).To(MatchError(ContainSubstring(`task 'bad': http.url "hooks.example.com/x" is not an absolute http or https URL`)))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'bad': unknown http.method 'FETCH'")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'bad': http header 'X-Token' needs exactly one of value and secretKeyRef")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'bad': http.expectedStatus 42 is not an HTTP status")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'bad' output 'f': path is only for job tasks")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'bad' output 'j' jsonPath:")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'job': http requires type http")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'job' output 'j': jsonPath is only for http tasks")))
+}
+
+func TestValidateApprovalTask(t *testing.T) {
+	g := NewWithT(t)
+
+	run := runWithTasks(map[string]TaskSpec{
+		"gate":   {Type: TaskTypeApproval},
+		"expiry": {Type: TaskTypeApproval, Timeout: &metav1.Duration{Duration: time.Hour}, Approval: &ApprovalSpec{OnTimeout: ApprovalTimeoutApprove}},
+		"deploy": {Dependencies: []string{"gate", "expiry"}},
+	})
+	run.Annotations = map[string]string{ApprovalAnnotationPrefix + "gate": ApprovalApprove}
+	_, err := run.validate()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	run.Spec.Workflow.Tasks["busy"] = TaskSpec{Type: TaskTypeApproval, Image: "busybox", RetryStrategy: &RetryStrategy{Limit: 1}, WithItems: []string{"a"}}
+	run.Spec.Workflow.Tasks["forever"] = TaskSpec{Type: TaskTypeApproval, Approval: &ApprovalSpec{OnTimeout: ApprovalTimeoutApprove}}
+	run.Spec.Workflow.Tasks["odd"] = TaskSpec{Type: TaskTypeApproval, Approval: &ApprovalSpec{OnTimeout: "Escalate"}}
+	run.Spec.Workflow.Tasks["job"] = TaskSpec{Approval: &ApprovalSpec{}}
+	run.Annotations[ApprovalAnnotationPrefix+"gate"] = "yes"
+	run.Annotations[ApprovalAnnotationPrefix+"deploy"] = ApprovalApprove
+	_, err = run.validate()
+	g.Expect(err).To(MatchError(ContainSubstring("task 'busy': approval tasks run nothing")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'busy': approval tasks cannot be retried")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'busy': approval tasks cannot declare outputs or fan out")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'forever': approval.onTimeout Approve requires a timeout")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'odd': unknown approval.onTimeout 'Escalate'")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'job': approval requires type approval")))
+	g.Expect(err).To(MatchError(ContainSubstring("annotation approval.observatory.seventh-horizon.io/gate: unknown value 'yes'")))
+	g.Expect(err).To(MatchError(ContainSubstring("annotation approval.observatory.seventh-horizon.io/deploy: 'deploy' is not an approval task")))
+}
+
+func TestApproverRecorder(t *testing.T) {
+	g := NewWithT(t)
+	d := &approverRecorder{}
+	admit := func(run, old *ObservatoryRun, user string) {
+		req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{UserInfo: authenticationv1.UserInfo{Username: user}}}
+		if old != nil {
+			raw, err := json.Marshal(old)
+			g.Expect(err).NotTo(HaveOccurred())
+			req.OldObject.Raw = raw
+		}
+		g.Expect(d.Default(admission.NewContextWithRequest(context.Background(), req), run)).To(Succeed())
+	}
+	approval, approver := ApprovalAnnotationPrefix+"gate", ApproverAnnotationPrefix+"gate"
+
+	// Set on create, and a forged approver is replaced.
+	run := runWithTasks(nil)
+	run.Annotations = map[string]string{approval: ApprovalApprove, approver: "someone-else"}
+	admit(run, nil, "alice")
+	g.Expect(run.Annotations).To(HaveKeyWithValue(approver, "alice"))
+
+	// Kept across updates that leave the approval alone, forged or not.
+	old := run.DeepCopy()
+	run.Annotations["other"] = "x"
+	run.Annotations[approver] = "mallory"
+	admit(run, old, "controller")
+	g.Expect(run.Annotations).To(HaveKeyWithValue(approver, "alice"))
+
+	// Replaced when the approval changes, dropped when it is removed.
+	old = run.DeepCopy()
+	run.Annotations[approval] = ApprovalReject
+	admit(run, old, "bob")
+	g.Expect(run.Annotations).To(HaveKeyWithValue(approver, "bob"))
+	old = run.DeepCopy()
+	delete(run.Annotations, approval)
+	admit(run, old, "bob")
+	g.Expect(run.Annotations).NotTo(HaveKey(approver))
+}
+
+func TestValidateRetryStrategy(t *testing.T) {
+	g := NewWithT(t)
+
+	two, zero := int32(2), int32(0)
+	run := runWithTasks(map[string]TaskSpec{
+		"a": {RetryStrategy: &RetryStrategy{Limit: 3, Backoff: &Backoff{Duration: &metav1.Duration{Duration: time.Second}, Factor: &two}}},
+		"b": {RetryStrategy: &RetryStrategy{Limit: 1, RetryOn: RetryOnExitCode, ExitCodes: []int32{75}}},
+	})
+	_, err := run.validate()
+	g.Expect(err).NotTo(HaveOccurred())
+
+	run.Spec.Workflow.Tasks["both"] = TaskSpec{Retries: &two, RetryStrategy: &RetryStrategy{Limit: -1}}
+	run.Spec.Workflow.Tasks["codes"] = TaskSpec{RetryStrategy: &RetryStrategy{RetryOn: RetryOnExitCode}}
+	run.Spec.Workflow.Tasks["policy"] = TaskSpec{RetryStrategy: &RetryStrategy{RetryOn: "Always", Backoff: &Backoff{Factor: &zero}}}
+	_, err = run.validate()
+	g.Expect(err).To(MatchError(ContainSubstring("task 'both': retries and retryStrategy are mutually exclusive")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'both': retryStrategy.limit cannot be negative")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'codes': retryOn OnExitCode requires retryStrategy.exitCodes")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'policy': unknown retryOn 'Always'")))
+	g.Expect(err).To(MatchError(ContainSubstring("task 'policy': retryStrategy.backoff.factor must be at least 1")))
+}
+
+func TestValidateWorkflowParallelism(t *testing.T) {
+	g := NewWithT(t)
+
+	limit := int32(0)
+	run := runWithTasks(map[string]TaskSpec{"a": {Priority: -1}})
+	run.Spec.Workflow.Parallelism = &limit
+	_, err := run.validate()
+	g.Expect(err).To(MatchError(ContainSubstring("workflow parallelism must be at least 1")))
+
+	limit = 4
+	_, err = run.validate()
+	g.Expect(err).NotTo(HaveOccurred())
+}
+
+func TestValidateWorkflowTemplateRef(t *testing.T) {
+	g := NewWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(AddToScheme(scheme)).To(Succeed())
+	tmpl := &ObservatoryWorkflowTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "etl", Namespace: "ns"},
+		Spec: WorkflowTemplateSpec{
+			Parameters: []ParameterSpec{{Name: "env", Enum: []string{"dev", "prod"}}},
+			Workflow: WorkflowSpec{Tasks: map[string]TaskSpec{
+				"a": {Command: "deploy {{inputs.parameters.env}}"},
+				"b": {Dependencies: []string{"a"}},
+			}},
+		},
+	}
+	cyclic := &ObservatoryClusterWorkflowTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "cyclic"},
+		Spec: WorkflowTemplateSpec{Workflow: WorkflowSpec{Tasks: map[string]TaskSpec{
+			"a": {Dependencies: []string{"b"}},
+			"b": {Dependencies: []string{"a"}},
+		}}},
+	}
+	project := &ObservatoryProject{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	v := &runValidator{reader: fake.NewClientBuilder().WithScheme(scheme).WithObjects(tmpl, cyclic, project).Build()}
+	ctx := context.Background()
+
+	prod := "prod"
+	run := &ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns"},
+		Spec: ObservatoryRunSpec{
+			Project:             "test",
+			WorkflowTemplateRef: &WorkflowTemplateRef{Name: "etl"},
+			Parameters:          []ParameterSpec{{Name: "env", Value: &prod}},
+		},
+	}
+	_, err := v.ValidateCreate(ctx, run)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// The merged parameters are checked against the template's enum.
+	staging := "staging"
+	run.Spec.Parameters[0].Value = &staging
+	_, err = v.ValidateCreate(ctx, run)
+	g.Expect(err).To(MatchError(ContainSubstring("not one of [dev prod]")))
+
+	run.Spec.Parameters = []ParameterSpec{{Name: "region", Value: &prod}}
+	_, err = v.ValidateCreate(ctx, run)
+	g.Expect(err).To(MatchError(ContainSubstring("parameter 'region' is not declared by the template")))
+
+	run.Spec.Parameters = nil
+	run.Spec.WorkflowTemplateRef = &WorkflowTemplateRef{Name: "cyclic", ClusterScope: true}
+	_, err = v.ValidateCreate(ctx, run)
+	g.Expect(err).To(MatchError(ContainSubstring("circular dependency detected")))
+
+	run.Spec.WorkflowTemplateRef = &WorkflowTemplateRef{Name: "missing"}
+	_, err = v.ValidateCreate(ctx, run)
+	g.Expect(err).To(MatchError(ContainSubstring("template 'missing' not found")))
+
+	run.Spec.WorkflowTemplateRef = &WorkflowTemplateRef{Name: "etl"}
+	run.Spec.Workflow.Tasks = map[string]TaskSpec{"x": {}}
+	_, err = v.ValidateCreate(ctx, run)
+	g.Expect(err).To(MatchError(ContainSubstring("workflow and workflowTemplateRef are mutually exclusive")))
+}
+
+func TestValidateUpdateOfTemplateRun(t *testing.T) {
+	g := NewWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(AddToScheme(scheme)).To(Succeed())
+	// The template the run started from has since been deleted.
+	v := &runValidator{reader: fake.NewClientBuilder().WithScheme(scheme).Build()}
+	ctx := context.Background()
+
+	env := "prod"
+	old := &ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns", Finalizers: []string{"observatory/finalizer"}},
+		Spec: ObservatoryRunSpec{
+			Project:             "test",
+			WorkflowTemplateRef: &WorkflowTemplateRef{Name: "etl"},
+		},
+		Status: ObservatoryRunStatus{
+			Parameters: map[string]string{"env": env},
+			Workflow: &WorkflowSpec{Tasks: map[string]TaskSpec{
+				"a": {Command: "deploy {{inputs.parameters.env}}"},
+			}},
+		},
+	}
+
+	annotated := old.DeepCopy()
+	annotated.Annotations = map[string]string{RetryAnnotation: "1"}
+	_, err := v.ValidateUpdate(ctx, old, annotated)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	deleting := old.DeepCopy()
+	deleting.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	deleting.Finalizers = nil
+	deleting.Spec.Suspend = true
+	_, err = v.ValidateUpdate(ctx, old, deleting)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// Spec edits are checked against the snapshot, not the missing template.
+	suspended := old.DeepCopy()
+	suspended.Spec.Suspend = true
+	_, err = v.ValidateUpdate(ctx, old, suspended)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	suspended.Spec.ActiveDeadline = &metav1.Duration{Duration: -time.Minute}
+	_, err = v.ValidateUpdate(ctx, old, suspended)
+	g.Expect(err).To(MatchError(ContainSubstring("activeDeadline must be positive")))
+
+	// A run that has not started yet still needs its template.
+	unstarted := old.DeepCopy()
+	unstarted.Status = ObservatoryRunStatus{}
+	edited := unstarted.DeepCopy()
+	edited.Spec.Suspend = true
+	_, err = v.ValidateUpdate(ctx, unstarted, edited)
+	g.Expect(err).To(MatchError(ContainSubstring("template 'etl' not found")))
+}
+
+func TestValidateProject(t *testing.T) {
+	g := NewWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(AddToScheme(scheme)).To(Succeed())
+	project := &ObservatoryProject{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	v := &runValidator{reader: fake.NewClientBuilder().WithScheme(scheme).WithObjects(project).Build()}
+	ctx := context.Background()
+
+	run := runWithTasks(map[string]TaskSpec{"a": {}})
+	_, err := v.ValidateCreate(ctx, run)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// Runs without a project fall to the default project, if there is one.
+	run.Spec.Project = ""
+	_, err = v.ValidateCreate(ctx, run)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	for _, name := range []string{"tset", "Free form"} {
+		run.Spec.Project = name
+		_, err = v.ValidateCreate(ctx, run)
+		g.Expect(err).To(MatchError(ContainSubstring("project: ObservatoryProject '%s' not found", name)))
+	}
+
+	// A run keeps a project deleted after it was created, but may not move
+	// to one that does not exist.
+	old := runWithTasks(map[string]TaskSpec{"a": {}})
+	old.Spec.Project = "gone"
+	edited := old.DeepCopy()
+	edited.Spec.Suspend = true
+	_, err = v.ValidateUpdate(ctx, old, edited)
+	g.Expect(err).NotTo(HaveOccurred())
+	edited.Spec.Project = "tset"
+	_, err = v.ValidateUpdate(ctx, old, edited)
+	g.Expect(err).To(MatchError(ContainSubstring("ObservatoryProject 'tset' not found")))
+}
+
+func TestRejectionHook(t *testing.T) {
+	g := NewWithT(t)
+	rejected := 0
+	scheme := runtime.NewScheme()
+	g.Expect(AddToScheme(scheme)).To(Succeed())
+	project := &ObservatoryProject{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	v := &rejectionHook{
+		CustomValidator: &runValidator{reader: fake.NewClientBuilder().WithScheme(scheme).WithObjects(project).Build()},
+		onRejected:      func() { rejected++ },
+	}
+	ctx := context.Background()
+
+	good := runWithTasks(map[string]TaskSpec{"a": {}})
+	_, err := v.ValidateCreate(ctx, good)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rejected).To(Equal(0))
+
+	bad := runWithTasks(nil)
+	_, err = v.ValidateCreate(ctx, bad)
+	g.Expect(err).To(HaveOccurred())
+	_, err = v.ValidateUpdate(ctx, good, bad)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(rejected).To(Equal(2))
+}
@@ -0,0 +1,105 @@
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// WorkflowTemplateSpec is a reusable workflow and the parameters it takes.
+// +kubebuilder:object:generate=true
+type WorkflowTemplateSpec struct {
+	Parameters []ParameterSpec `json:"parameters,omitempty"`
+	Workflow   WorkflowSpec    `json:"workflow"`
+}
+
+// WorkflowTemplateRef names the template an ObservatoryRun executes.
+type WorkflowTemplateRef struct {
+	Name string `json:"name"`
+	// ClusterScope selects an ObservatoryClusterWorkflowTemplate instead of
+	// an ObservatoryWorkflowTemplate in the run's namespace.
+	ClusterScope bool `json:"clusterScope,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type ObservatoryWorkflowTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WorkflowTemplateSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type ObservatoryWorkflowTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ObservatoryWorkflowTemplate `json:"items"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+type ObservatoryClusterWorkflowTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WorkflowTemplateSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type ObservatoryClusterWorkflowTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ObservatoryClusterWorkflowTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(
+		&ObservatoryWorkflowTemplate{}, &ObservatoryWorkflowTemplateList{},
+		&ObservatoryClusterWorkflowTemplate{}, &ObservatoryClusterWorkflowTemplateList{},
+	)
+}
+
+// GetWorkflowTemplate fetches the template ref names, looking up namespaced
+// templates in namespace.
+func GetWorkflowTemplate(ctx context.Context, c client.Reader, namespace string, ref *WorkflowTemplateRef) (*WorkflowTemplateSpec, error) {
+	if ref.ClusterScope {
+		var t ObservatoryClusterWorkflowTemplate
+		if err := c.Get(ctx, client.ObjectKey{Name: ref.Name}, &t); err != nil {
+			return nil, err
+		}
+		return &t.Spec, nil
+	}
+	var t ObservatoryWorkflowTemplate
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: ref.Name}, &t); err != nil {
+		return nil, err
+	}
+	return &t.Spec, nil
+}
+
+// MergeTemplateParameters layers a run's parameters over its template's:
+// a run parameter replaces the value and default of the template parameter
+// with the same name. The template's enum and description are kept. Run
+// parameters the template does not declare are an error.
+func MergeTemplateParameters(template, overrides []ParameterSpec) ([]ParameterSpec, error) {
+	merged := make([]ParameterSpec, len(template))
+	index := map[string]int{}
+	for i, p := range template {
+		merged[i] = *p.DeepCopy()
+		index[p.Name] = i
+	}
+	for _, o := range overrides {
+		i, ok := index[o.Name]
+		if !ok {
+			return nil, fmt.Errorf("parameter '%s' is not declared by the template", o.Name)
+		}
+		if o.Value != nil {
+			merged[i].Value = o.Value
+		}
+		if o.Default != nil {
+			merged[i].Default = o.Default
+		}
+	}
+	return merged, nil
+}
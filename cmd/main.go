@@ -1,20 +1,34 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"strings"
+	"time"
+	// Embedded zone data, so ObservatoryCronRun time zones resolve in
+	// images without /usr/share/zoneinfo.
+	_ "time/tzdata"
 
 	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server" // ADDED ALIAS
 	"sigs.k8s.io/controller-runtime/pkg/webhook"                      // ADDED IMPORT
 
 	"github.com/example/observatory-operator/controllers"
+	"github.com/example/observatory-operator/internal/metrics"
+	"github.com/example/observatory-operator/internal/tracing"
 )
 
 var (
@@ -31,9 +45,16 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var otlpEndpoint string
+	var httpAllowedHosts string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false, "Enable leader election for controller manager.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		"OTLP/HTTP collector base URL for run traces (e.g. http://otel-collector:4318). Empty disables export.")
+	flag.StringVar(&httpAllowedHosts, "http-allowed-hosts", "",
+		"Comma-separated hosts http tasks may call, by name or as *.example.com for any host below example.com. "+
+			"Calls are made from the controller's network. Empty disallows http tasks.")
 
 	opts := zap.Options{Development: true}
 	opts.BindFlags(flag.CommandLine)
@@ -50,24 +71,68 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "observatory-operator.seventh-horizon.io",
-		// REMOVED Client: ... line
+		// http tasks read header Secrets; get them directly rather than
+		// cache every Secret in the cluster.
+		Client: client.Options{Cache: &client.CacheOptions{DisableFor: []client.Object{&corev1.Secret{}}}},
+		// The run controller watches pods; cache the task pods only, not
+		// every pod in the cluster.
+		Cache: cache.Options{ByObject: map[client.Object]cache.ByObject{
+			&corev1.Pod{}: {Label: controllers.TaskPodSelector()},
+		}},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	var tracer trace.TracerProvider
+	if otlpEndpoint != "" {
+		tp, err := tracing.NewProvider(otlpEndpoint)
+		if err != nil {
+			setupLog.Error(err, "unable to set up trace export")
+			os.Exit(1)
+		}
+		// Spans are exported in the background; report what fails there.
+		traceLog := ctrl.Log.WithName("tracing")
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			traceLog.Error(err, "trace export failed")
+		}))
+		// Flush queued spans when the manager stops.
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			<-ctx.Done()
+			flush, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return tp.Shutdown(flush)
+		})); err != nil {
+			setupLog.Error(err, "unable to set up trace export")
+			os.Exit(1)
+		}
+		tracer = tp
+		setupLog.Info("exporting run traces", "endpoint", otlpEndpoint)
+	}
+
 	if err = (&controllers.ObservatoryRunReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-		// REMOVED Recorder: ... line
+		Client:           mgr.GetClient(),
+		Scheme:           mgr.GetScheme(),
+		Tracer:           tracer,
+		HTTPAllowedHosts: splitList(httpAllowedHosts),
+		Recorder:         mgr.GetEventRecorderFor("observatory-operator"),
 		// REMOVED Log: ... line
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ObservatoryRun")
 		os.Exit(1)
 	}
 
-	if err = (&observatoryv1alpha1.ObservatoryRun{}).SetupWebhookWithManager(mgr); err != nil {
+	if err = (&controllers.ObservatoryCronRunReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("observatory-operator"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ObservatoryCronRun")
+		os.Exit(1)
+	}
+
+	if err = (&observatoryv1alpha1.ObservatoryRun{}).SetupWebhookWithManager(mgr, metrics.WebhookErrors.Inc); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "ObservatoryRun")
 		os.Exit(1)
 	}
@@ -89,3 +154,14 @@ func main() {
 }
 
 // REMOVED entire controller_runtime_client() function
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
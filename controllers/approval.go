@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	"github.com/example/observatory-operator/internal/tracing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// approvalExecutor runs an approval task. It starts nothing: the task waits
+// in AwaitingApproval until the run carries its approval annotation, which
+// reconciles the run, or until its timeout passes, which nextWakeup
+// schedules a reconcile for.
+type approvalExecutor struct {
+	r *ObservatoryRunReconciler
+}
+
+func (e approvalExecutor) Launch(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun, task string) error {
+	st := taskStatusFor(run, task)
+	now := metav1.Now()
+	st.State = observatoryv1alpha1.TaskAwaitingApproval
+	st.StartedAt = &now
+	st.FinishedAt = nil
+	st.Message = fmt.Sprintf("Waiting for approval: annotate the run with %s%s=%s or %s",
+		observatoryv1alpha1.ApprovalAnnotationPrefix, task, observatoryv1alpha1.ApprovalApprove, observatoryv1alpha1.ApprovalReject)
+	e.r.event(run, corev1.EventTypeNormal, eventTaskAwaitingApproval, "Task %s is waiting for approval", task)
+	// It may have been decided before it was reached; Reconcile then goes
+	// on to its dependents.
+	spec, _ := scheduledSpecFor(run, task)
+	e.decide(ctx, run, task, st, spec, now.Time)
+	return nil
+}
+
+func (e approvalExecutor) Observe(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun) error {
+	now := time.Now()
+	for _, name := range sortedKeys(run.Status.TaskStatuses) {
+		st := run.Status.TaskStatuses[name]
+		spec, ok := scheduledSpecFor(run, name)
+		if !ok || spec.Type != observatoryv1alpha1.TaskTypeApproval || st.State != observatoryv1alpha1.TaskAwaitingApproval {
+			continue
+		}
+		e.decide(ctx, run, name, st, spec, now)
+	}
+	return nil
+}
+
+// decide finishes an approval task that was approved, rejected or timed out.
+func (e approvalExecutor) decide(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun, task string, st *observatoryv1alpha1.TaskStatus, spec observatoryv1alpha1.TaskSpec, now time.Time) {
+	approver := run.Annotations[observatoryv1alpha1.ApproverAnnotationPrefix+task]
+	by := ""
+	if approver != "" {
+		by = " by " + approver
+	}
+	switch run.Annotations[observatoryv1alpha1.ApprovalAnnotationPrefix+task] {
+	case observatoryv1alpha1.ApprovalApprove:
+		st.State = observatoryv1alpha1.TaskSucceeded
+		st.Reason = ""
+		st.Approver = approver
+		st.Message = "Approved" + by
+	case observatoryv1alpha1.ApprovalReject:
+		st.State = observatoryv1alpha1.TaskFailed
+		st.Reason = observatoryv1alpha1.ReasonRejected
+		st.Approver = approver
+		st.Message = "Rejected" + by
+	default:
+		deadline, ok := approvalDeadline(spec, st)
+		if !ok || now.Before(deadline) {
+			return
+		}
+		if spec.Approval != nil && spec.Approval.OnTimeout == observatoryv1alpha1.ApprovalTimeoutApprove {
+			st.State = observatoryv1alpha1.TaskSucceeded
+			st.Reason = observatoryv1alpha1.ReasonAutoApproved
+			st.Message = fmt.Sprintf("Approved automatically after %s without a decision", spec.Timeout.Duration)
+		} else {
+			st.State = observatoryv1alpha1.TaskFailed
+			st.Reason = observatoryv1alpha1.ReasonTimedOut
+			st.Message = fmt.Sprintf("No decision within %s", spec.Timeout.Duration)
+		}
+	}
+	finished := metav1.NewTime(now)
+	st.FinishedAt = &finished
+	e.r.taskEvent(run, task, st)
+	if tracingEnabled(run) && st.StartedAt != nil {
+		e.r.recordSpans([]tracing.Span{attemptSpan(run, task, st.Attempt, st, st.StartedAt.Time, now)})
+	}
+	log.FromContext(ctx).Info("Approval task decided", "task", task, "state", st.State, "approver", approver)
+}
+
+// approvalDeadline is when an approval task waiting since st.StartedAt
+// times out, if it has a timeout.
+func approvalDeadline(spec observatoryv1alpha1.TaskSpec, st *observatoryv1alpha1.TaskStatus) (time.Time, bool) {
+	if spec.Timeout == nil || st.StartedAt == nil {
+		return time.Time{}, false
+	}
+	return st.StartedAt.Add(spec.Timeout.Duration), true
+}
+
+func (e approvalExecutor) Cancel(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun, task string, st *observatoryv1alpha1.TaskStatus) error {
+	return nil
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	obs "github.com/example/observatory-operator/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// approvalRun returns a run whose deploy task waits for the gate approval
+// task.
+func approvalRun(gate obs.TaskSpec) *obs.ObservatoryRun {
+	gate.Type = obs.TaskTypeApproval
+	return &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns", Finalizers: []string{finalizerName}},
+		Spec: obs.ObservatoryRunSpec{Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{
+			"gate":   gate,
+			"deploy": {Image: "busybox", Dependencies: []string{"gate"}},
+		}}},
+	}
+}
+
+// decide sets the approval annotations as the mutating webhook leaves them.
+func decide(g Gomega, r *ObservatoryRunReconciler, run *obs.ObservatoryRun, decision, approver string) {
+	var cur obs.ObservatoryRun
+	g.Expect(r.Get(context.Background(), client.ObjectKeyFromObject(run), &cur)).To(Succeed())
+	if cur.Annotations == nil {
+		cur.Annotations = map[string]string{}
+	}
+	cur.Annotations[obs.ApprovalAnnotationPrefix+"gate"] = decision
+	cur.Annotations[obs.ApproverAnnotationPrefix+"gate"] = approver
+	g.Expect(r.Update(context.Background(), &cur)).To(Succeed())
+}
+
+func TestApprovalTaskApproved(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	run := approvalRun(obs.TaskSpec{})
+	r := newTestReconciler(t, run)
+
+	res, got := reconcileRun(g, r, run)
+	st := got.Status.TaskStatuses["gate"]
+	g.Expect(st.State).To(Equal(obs.TaskAwaitingApproval))
+	g.Expect(st.JobName).To(BeEmpty())
+	g.Expect(st.Message).To(ContainSubstring("approval.observatory.seventh-horizon.io/gate=approve"))
+	g.Expect(got.Status.Phase).To(Equal(obs.PhaseRunning))
+	// Without a timeout only the annotation moves it on.
+	g.Expect(res.RequeueAfter).To(BeZero())
+	var jobs batchv1.JobList
+	g.Expect(r.List(ctx, &jobs)).To(Succeed())
+	g.Expect(jobs.Items).To(BeEmpty())
+
+	decide(g, r, run, obs.ApprovalApprove, "alice")
+	_, got = reconcileRun(g, r, run)
+	st = got.Status.TaskStatuses["gate"]
+	g.Expect(st.State).To(Equal(obs.TaskSucceeded))
+	g.Expect(st.Approver).To(Equal("alice"))
+	g.Expect(st.Message).To(Equal("Approved by alice"))
+	g.Expect(got.Status.TaskStatuses["deploy"].JobName).To(Equal(attemptJobName(run, "deploy", 0)))
+}
+
+func TestApprovalTaskApprovedInAdvance(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	run := approvalRun(obs.TaskSpec{})
+	run.Annotations = map[string]string{
+		obs.ApprovalAnnotationPrefix + "gate": obs.ApprovalApprove,
+		obs.ApproverAnnotationPrefix + "gate": "alice",
+	}
+	r := newTestReconciler(t, run)
+
+	// The gate finishes as it is launched; deploy starts in the same reconcile.
+	_, got := reconcileRun(g, r, run)
+	st := got.Status.TaskStatuses["gate"]
+	g.Expect(st.State).To(Equal(obs.TaskSucceeded))
+	g.Expect(st.Approver).To(Equal("alice"))
+	g.Expect(got.Status.TaskStatuses["deploy"].JobName).To(Equal(attemptJobName(run, "deploy", 0)))
+	var jobs batchv1.JobList
+	g.Expect(r.List(ctx, &jobs)).To(Succeed())
+	g.Expect(jobs.Items).To(HaveLen(1))
+	g.Expect(got.Status.Phase).To(Equal(obs.PhaseRunning))
+}
+
+func TestApprovalTaskRejectedAndRetried(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	run := approvalRun(obs.TaskSpec{})
+	r := newTestReconciler(t, run)
+	reconcileRun(g, r, run)
+
+	decide(g, r, run, obs.ApprovalReject, "bob")
+	_, got := reconcileRun(g, r, run)
+	st := got.Status.TaskStatuses["gate"]
+	g.Expect(st.State).To(Equal(obs.TaskFailed))
+	g.Expect(st.Reason).To(Equal(obs.ReasonRejected))
+	g.Expect(st.Message).To(Equal("Rejected by bob"))
+	g.Expect(got.Status.Phase).To(Equal(obs.PhaseFailed))
+
+	// A retry asks again rather than reusing the rejection.
+	got.Annotations[obs.RetryAnnotation] = "1"
+	g.Expect(r.Update(ctx, got)).To(Succeed())
+	reconcileRun(g, r, run)
+	_, got = reconcileRun(g, r, run)
+	g.Expect(got.Annotations).NotTo(HaveKey(obs.ApprovalAnnotationPrefix + "gate"))
+	g.Expect(got.Status.TaskStatuses["gate"].State).To(Equal(obs.TaskAwaitingApproval))
+}
+
+func TestApprovalTaskTimeout(t *testing.T) {
+	for name, tc := range map[string]struct {
+		onTimeout     obs.ApprovalTimeoutAction
+		state         obs.TaskState
+		reason        string
+		deployStarted bool
+	}{
+		"fail":    {onTimeout: "", state: obs.TaskFailed, reason: obs.ReasonTimedOut},
+		"approve": {onTimeout: obs.ApprovalTimeoutApprove, state: obs.TaskSucceeded, reason: obs.ReasonAutoApproved, deployStarted: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			run := approvalRun(obs.TaskSpec{
+				Timeout:  &metav1.Duration{Duration: time.Hour},
+				Approval: &obs.ApprovalSpec{OnTimeout: tc.onTimeout},
+			})
+			r := newTestReconciler(t, run)
+
+			res, got := reconcileRun(g, r, run)
+			g.Expect(got.Status.TaskStatuses["gate"].State).To(Equal(obs.TaskAwaitingApproval))
+			g.Expect(res.RequeueAfter).To(BeNumerically("~", time.Hour, time.Minute))
+
+			// An hour later, still undecided.
+			got.Status.TaskStatuses["gate"].StartedAt = &metav1.Time{Time: time.Now().Add(-time.Hour)}
+			g.Expect(r.Status().Update(ctx, got)).To(Succeed())
+			_, got = reconcileRun(g, r, run)
+			st := got.Status.TaskStatuses["gate"]
+			g.Expect(st.State).To(Equal(tc.state))
+			g.Expect(st.Reason).To(Equal(tc.reason))
+			g.Expect(st.Approver).To(BeEmpty())
+			g.Expect(got.Status.TaskStatuses["deploy"].JobName != "").To(Equal(tc.deployStarted))
+		})
+	}
+}
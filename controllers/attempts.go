@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// maxAttemptHistory and maxAttemptMessage bound TaskStatus.Attempts, so a
+	// run with many retried tasks stays well under the etcd object size limit.
+	maxAttemptHistory = 10
+	maxAttemptMessage = 256
+)
+
+// recordAttempts updates a task's attempt history from a Job and its pods.
+// Each pod is one attempt, numbered in creation order, except that the
+// pods of a retryStrategy attempt Job all carry that Job's attempt number.
+func recordAttempts(st *observatoryv1alpha1.TaskStatus, job *batchv1.Job, pods []corev1.Pod, perJob bool) {
+	sort.Slice(pods, func(i, j int) bool {
+		if !pods[i].CreationTimestamp.Equal(&pods[j].CreationTimestamp) {
+			return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
+		}
+		return pods[i].Name < pods[j].Name
+	})
+	for i := range pods {
+		n := int32(i)
+		if perJob {
+			n = jobAttempt(job)
+		}
+		a := findAttempt(st, job.Name, pods[i].Name)
+		if a == nil {
+			if len(st.Attempts) >= maxAttemptHistory && n < st.Attempts[0].Attempt {
+				continue // already dropped from the history
+			}
+			st.Attempts = append(st.Attempts, observatoryv1alpha1.TaskAttempt{Attempt: n, JobName: job.Name, PodName: pods[i].Name})
+			a = &st.Attempts[len(st.Attempts)-1]
+		}
+		st.AttemptCount = max(st.AttemptCount, n+1)
+		if !isTaskTerminal(a.State) {
+			updateAttempt(a, &pods[i])
+		}
+	}
+
+	if jobDeadlineExceeded(job) {
+		// The Job controller deletes the pods it times out, so they may be
+		// gone before their termination was ever observed.
+		at := jobFailedAt(job)
+		found := false
+		for i := range st.Attempts {
+			if a := &st.Attempts[i]; a.JobName == job.Name {
+				found = true
+				if !isTaskTerminal(a.State) {
+					a.State = observatoryv1alpha1.TaskFailed
+					a.Reason = "DeadlineExceeded"
+					a.FinishedAt = at
+				}
+			}
+		}
+		if !found {
+			n := st.AttemptCount
+			if perJob {
+				n = jobAttempt(job)
+			}
+			st.Attempts = append(st.Attempts, observatoryv1alpha1.TaskAttempt{
+				Attempt: n, JobName: job.Name, State: observatoryv1alpha1.TaskFailed,
+				Reason: "DeadlineExceeded", StartedAt: job.Status.StartTime.DeepCopy(), FinishedAt: at,
+			})
+			st.AttemptCount = max(st.AttemptCount, n+1)
+		}
+	}
+	trimAttempts(st)
+}
+
+// finishAttempt records the failure of a retryStrategy attempt on the
+// attempts of its Job that have not ended yet, e.g. one stuck pulling its
+// image.
+func finishAttempt(st *observatoryv1alpha1.TaskStatus, job *batchv1.Job, f attemptFailure) {
+	now := metav1.Now()
+	found := false
+	for i := range st.Attempts {
+		a := &st.Attempts[i]
+		if a.JobName != job.Name {
+			continue
+		}
+		found = true
+		if isTaskTerminal(a.State) {
+			continue
+		}
+		a.State = observatoryv1alpha1.TaskFailed
+		a.Reason, a.Message = f.reason, truncateMessage(f.message)
+		a.ExitCode = f.exitCode
+		if a.FinishedAt == nil {
+			a.FinishedAt = &now
+		}
+	}
+	if !found {
+		st.Attempts = append(st.Attempts, observatoryv1alpha1.TaskAttempt{
+			Attempt: jobAttempt(job), JobName: job.Name, State: observatoryv1alpha1.TaskFailed,
+			Reason: f.reason, ExitCode: f.exitCode, Message: truncateMessage(f.message),
+			StartedAt: job.Status.StartTime.DeepCopy(), FinishedAt: &now,
+		})
+		st.AttemptCount = max(st.AttemptCount, jobAttempt(job)+1)
+		trimAttempts(st)
+	}
+}
+
+// updateAttempt fills in an attempt from the current state of its pod.
+func updateAttempt(a *observatoryv1alpha1.TaskAttempt, pod *corev1.Pod) {
+	if a.StartedAt == nil && pod.Status.StartTime != nil {
+		a.StartedAt = pod.Status.StartTime.DeepCopy()
+	}
+	switch pod.Status.Phase {
+	case corev1.PodRunning:
+		a.State = observatoryv1alpha1.TaskRunning
+	case corev1.PodSucceeded:
+		a.State = observatoryv1alpha1.TaskSucceeded
+	case corev1.PodFailed:
+		a.State = observatoryv1alpha1.TaskFailed
+	default:
+		a.State = observatoryv1alpha1.TaskPending
+	}
+	if pod.Status.Reason != "" {
+		// Set by the kubelet for pod-level failures such as Evicted.
+		a.Reason, a.Message = pod.Status.Reason, pod.Status.Message
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name != taskContainerName {
+			continue
+		}
+		if w := cs.State.Waiting; w != nil && w.Reason != "ContainerCreating" && w.Reason != "PodInitializing" {
+			a.Reason, a.Message = w.Reason, w.Message
+		}
+		if t := cs.State.Terminated; t != nil {
+			code := t.ExitCode
+			a.ExitCode = &code
+			a.State = observatoryv1alpha1.TaskSucceeded
+			if code != 0 {
+				a.State = observatoryv1alpha1.TaskFailed
+			}
+			if pod.Status.Reason == "" {
+				a.Reason, a.Message = t.Reason, t.Message
+				if a.Message == "" && code != 0 {
+					a.Message = fmt.Sprintf("exited with code %d", code)
+				}
+			}
+			if a.StartedAt == nil && !t.StartedAt.IsZero() {
+				a.StartedAt = t.StartedAt.DeepCopy()
+			}
+			if !t.FinishedAt.IsZero() {
+				a.FinishedAt = t.FinishedAt.DeepCopy()
+			}
+		}
+	}
+	if isTaskTerminal(a.State) && a.FinishedAt == nil {
+		now := metav1.Now()
+		a.FinishedAt = &now
+	}
+	a.Message = truncateMessage(a.Message)
+}
+
+// syncTaskTimes derives a task's StartedAt and FinishedAt from its Job and
+// attempts.
+func syncTaskTimes(st *observatoryv1alpha1.TaskStatus, job *batchv1.Job) {
+	if st.StartedAt == nil {
+		for _, a := range st.Attempts {
+			if a.StartedAt != nil {
+				st.StartedAt = a.StartedAt.DeepCopy()
+				break
+			}
+		}
+		if st.StartedAt == nil && job.Status.StartTime != nil {
+			st.StartedAt = job.Status.StartTime.DeepCopy()
+		}
+	}
+	if !isTaskTerminal(st.State) {
+		st.FinishedAt = nil
+		return
+	}
+	if st.FinishedAt != nil {
+		return
+	}
+	switch {
+	case job.Status.CompletionTime != nil:
+		st.FinishedAt = job.Status.CompletionTime.DeepCopy()
+	case len(st.Attempts) > 0 && st.Attempts[len(st.Attempts)-1].FinishedAt != nil:
+		st.FinishedAt = st.Attempts[len(st.Attempts)-1].FinishedAt.DeepCopy()
+	default:
+		now := metav1.Now()
+		st.FinishedAt = &now
+	}
+}
+
+func findAttempt(st *observatoryv1alpha1.TaskStatus, job, pod string) *observatoryv1alpha1.TaskAttempt {
+	for i := range st.Attempts {
+		if a := &st.Attempts[i]; a.JobName == job && a.PodName == pod {
+			return a
+		}
+	}
+	return nil
+}
+
+func trimAttempts(st *observatoryv1alpha1.TaskStatus) {
+	if n := len(st.Attempts); n > maxAttemptHistory {
+		st.Attempts = append([]observatoryv1alpha1.TaskAttempt(nil), st.Attempts[n-maxAttemptHistory:]...)
+	}
+}
+
+func truncateMessage(msg string) string {
+	if len(msg) <= maxAttemptMessage {
+		return msg
+	}
+	return strings.ToValidUTF8(msg[:maxAttemptMessage-3], "") + "..."
+}
+
+// jobFailedAt is when the Job controller marked j failed, or now.
+func jobFailedAt(j *batchv1.Job) *metav1.Time {
+	for _, c := range j.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue && !c.LastTransitionTime.IsZero() {
+			return c.LastTransitionTime.DeepCopy()
+		}
+	}
+	return &metav1.Time{Time: time.Now()}
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	obs "github.com/example/observatory-operator/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func jobPod(name string, created time.Time, phase corev1.PodPhase, state corev1.ContainerState) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)},
+		Status: corev1.PodStatus{
+			Phase:             phase,
+			StartTime:         &metav1.Time{Time: created.Add(time.Second)},
+			ContainerStatuses: []corev1.ContainerStatus{{Name: taskContainerName, State: state}},
+		},
+	}
+}
+
+func TestRecordAttemptsFromPods(t *testing.T) {
+	g := NewWithT(t)
+
+	t0 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "r-a"}}
+	oom := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+		ExitCode: 137, Reason: "OOMKilled", FinishedAt: metav1.NewTime(t0.Add(time.Minute)),
+	}}
+	running := corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.NewTime(t0.Add(2 * time.Minute))}}
+	pods := []corev1.Pod{
+		// Listed out of order; attempts are numbered by creation time.
+		jobPod("r-a-2", t0.Add(2*time.Minute), corev1.PodRunning, running),
+		jobPod("r-a-1", t0, corev1.PodFailed, oom),
+	}
+
+	st := &obs.TaskStatus{}
+	recordAttempts(st, job, pods, false)
+	g.Expect(st.AttemptCount).To(Equal(int32(2)))
+	g.Expect(st.Attempts).To(HaveLen(2))
+	first, second := st.Attempts[0], st.Attempts[1]
+	g.Expect(first.PodName).To(Equal("r-a-1"))
+	g.Expect(first.State).To(Equal(obs.TaskFailed))
+	g.Expect(first.Reason).To(Equal("OOMKilled"))
+	g.Expect(*first.ExitCode).To(Equal(int32(137)))
+	g.Expect(first.StartedAt.Time).To(Equal(t0.Add(time.Second)))
+	g.Expect(first.FinishedAt.Time).To(Equal(t0.Add(time.Minute)))
+	g.Expect(second.Attempt).To(Equal(int32(1)))
+	g.Expect(second.State).To(Equal(obs.TaskRunning))
+	g.Expect(second.FinishedAt).To(BeNil())
+
+	// The Job times out and its running pod is deleted before it is seen
+	// terminating.
+	job.Status.StartTime = &metav1.Time{Time: t0}
+	job.Status.Conditions = []batchv1.JobCondition{{
+		Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "DeadlineExceeded",
+		LastTransitionTime: metav1.NewTime(t0.Add(5 * time.Minute)),
+	}}
+	recordAttempts(st, job, pods[1:], false)
+	g.Expect(st.Attempts).To(HaveLen(2))
+	g.Expect(st.Attempts[0].Reason).To(Equal("OOMKilled"))
+	g.Expect(st.Attempts[1].State).To(Equal(obs.TaskFailed))
+	g.Expect(st.Attempts[1].Reason).To(Equal("DeadlineExceeded"))
+	g.Expect(st.Attempts[1].FinishedAt.Time).To(Equal(t0.Add(5 * time.Minute)))
+
+	st.State = obs.TaskFailed
+	syncTaskTimes(st, job)
+	g.Expect(st.StartedAt.Time).To(Equal(t0.Add(time.Second)))
+	g.Expect(st.FinishedAt.Time).To(Equal(t0.Add(5 * time.Minute)))
+}
+
+func TestRecordAttemptsBounded(t *testing.T) {
+	g := NewWithT(t)
+
+	t0 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "r-a"}}
+	failed := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+		ExitCode: 1, Reason: "Error", Message: strings.Repeat("x", 10000),
+	}}
+	var pods []corev1.Pod
+	for i := 0; i < 25; i++ {
+		pods = append(pods, jobPod(fmt.Sprintf("r-a-%02d", i), t0.Add(time.Duration(i)*time.Minute), corev1.PodFailed, failed))
+	}
+
+	st := &obs.TaskStatus{}
+	for i := 0; i < 2; i++ {
+		recordAttempts(st, job, pods, false)
+	}
+	g.Expect(st.AttemptCount).To(Equal(int32(25)))
+	g.Expect(st.Attempts).To(HaveLen(maxAttemptHistory))
+	g.Expect(st.Attempts[0].Attempt).To(Equal(int32(25 - maxAttemptHistory)))
+	g.Expect(st.Attempts[maxAttemptHistory-1].PodName).To(Equal("r-a-24"))
+	g.Expect(len(st.Attempts[0].Message)).To(BeNumerically("<=", maxAttemptMessage))
+}
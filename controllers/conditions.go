@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// updateRunSummary derives the run's conditions, observed generation,
+// start and completion times and task counters from its phase and task
+// statuses. It runs before every status patch. StartTime is when the first
+// task started, or the run left Pending some other way.
+func updateRunSummary(run *observatoryv1alpha1.ObservatoryRun, now time.Time) {
+	st := &run.Status
+	st.ObservedGeneration = run.Generation
+
+	st.SucceededTasks, st.FailedTasks, st.TotalTasks = 0, 0, 0
+	for _, tasks := range []map[string]observatoryv1alpha1.TaskSpec{run.Spec.Workflow.Tasks, run.Spec.Workflow.Finally} {
+		for name := range tasks {
+			st.TotalTasks++
+			if ts := st.TaskStatuses[name]; ts != nil {
+				switch ts.State {
+				case observatoryv1alpha1.TaskSucceeded:
+					st.SucceededTasks++
+				case observatoryv1alpha1.TaskFailed:
+					st.FailedTasks++
+				}
+			}
+		}
+	}
+
+	phase := st.Phase
+	if phase == "" {
+		phase = observatoryv1alpha1.PhasePending
+	}
+	if st.StartTime == nil && (anyTaskStarted(run) || phase != observatoryv1alpha1.PhasePending && phase != observatoryv1alpha1.PhaseQueued) {
+		st.StartTime = &metav1.Time{Time: now}
+	}
+	if !isTerminal(phase) {
+		st.CompletionTime = nil
+	} else if st.CompletionTime == nil {
+		st.CompletionTime = &metav1.Time{Time: now}
+	}
+
+	progress := fmt.Sprintf("%d/%d tasks succeeded", st.SucceededTasks, st.TotalTasks)
+	failedMessage := st.Message
+	if failedMessage == "" {
+		failedMessage = fmt.Sprintf("%d/%d tasks failed", st.FailedTasks, st.TotalTasks)
+	}
+	failedReason := string(phase)
+	if st.Reason != "" {
+		failedReason = st.Reason
+	}
+
+	setCondition(run, observatoryv1alpha1.ConditionReady, phase == observatoryv1alpha1.PhaseSucceeded, string(phase), progress)
+	switch phase {
+	case observatoryv1alpha1.PhasePending, observatoryv1alpha1.PhaseQueued, observatoryv1alpha1.PhaseRunning:
+		setCondition(run, observatoryv1alpha1.ConditionProgressing, true, string(phase), progress)
+	default:
+		setCondition(run, observatoryv1alpha1.ConditionProgressing, false, string(phase), progress)
+	}
+	switch phase {
+	case observatoryv1alpha1.PhaseFailed, observatoryv1alpha1.PhaseCancelled:
+		setCondition(run, observatoryv1alpha1.ConditionFailed, true, failedReason, failedMessage)
+	default:
+		setCondition(run, observatoryv1alpha1.ConditionFailed, false, string(phase), "")
+	}
+	if phase == observatoryv1alpha1.PhaseSuspended {
+		setCondition(run, observatoryv1alpha1.ConditionSuspended, true, "Suspended", "spec.suspend is set")
+	} else {
+		setCondition(run, observatoryv1alpha1.ConditionSuspended, false, string(phase), "")
+	}
+}
+
+func anyTaskStarted(run *observatoryv1alpha1.ObservatoryRun) bool {
+	for _, ts := range run.Status.TaskStatuses {
+		if taskStarted(ts) {
+			return true
+		}
+	}
+	return false
+}
+
+// setCondition sets a condition, leaving its transition time alone unless
+// the status changes.
+func setCondition(run *observatoryv1alpha1.ObservatoryRun, condType string, status bool, reason, message string) {
+	c := metav1.Condition{
+		Type:               condType,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: run.Generation,
+	}
+	if status {
+		c.Status = metav1.ConditionTrue
+	}
+	meta.SetStatusCondition(&run.Status.Conditions, c)
+}
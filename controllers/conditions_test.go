@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	obs "github.com/example/observatory-operator/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestUpdateRunSummary(t *testing.T) {
+	g := NewWithT(t)
+
+	t0 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Generation: 2},
+		Spec: obs.ObservatoryRunSpec{Workflow: obs.Workflow{
+			Tasks:   map[string]obs.TaskSpec{"a": {}, "b": {}, "c": {}},
+			Finally: map[string]obs.TaskSpec{"cleanup": {}},
+		}},
+		Status: obs.ObservatoryRunStatus{Phase: obs.PhaseQueued},
+	}
+
+	updateRunSummary(run, t0)
+	g.Expect(run.Status.ObservedGeneration).To(Equal(int64(2)))
+	g.Expect(run.Status.StartTime).To(BeNil())
+	g.Expect(meta.IsStatusConditionTrue(run.Status.Conditions, obs.ConditionProgressing)).To(BeTrue())
+	g.Expect(meta.IsStatusConditionFalse(run.Status.Conditions, obs.ConditionReady)).To(BeTrue())
+	g.Expect(run.Status.Conditions).To(HaveLen(4))
+
+	run.Status.Phase = obs.PhaseRunning
+	run.Status.TaskStatuses = map[string]*obs.TaskStatus{
+		"a": {State: obs.TaskSucceeded, JobName: "r-a"},
+		"b": {State: obs.TaskFailed, JobName: "r-b"},
+	}
+	updateRunSummary(run, t0.Add(time.Minute))
+	g.Expect(run.Status.StartTime.Time).To(Equal(t0.Add(time.Minute)))
+	g.Expect(run.Status.SucceededTasks).To(Equal(int32(1)))
+	g.Expect(run.Status.FailedTasks).To(Equal(int32(1)))
+	g.Expect(run.Status.TotalTasks).To(Equal(int32(4)))
+	g.Expect(run.Status.CompletionTime).To(BeNil())
+
+	run.Status.Phase = obs.PhaseSuspended
+	updateRunSummary(run, t0.Add(2*time.Minute))
+	g.Expect(meta.IsStatusConditionTrue(run.Status.Conditions, obs.ConditionSuspended)).To(BeTrue())
+	g.Expect(meta.IsStatusConditionFalse(run.Status.Conditions, obs.ConditionProgressing)).To(BeTrue())
+
+	run.Status.Phase = obs.PhaseFailed
+	run.Status.Reason = obs.ReasonTimedOut
+	updateRunSummary(run, t0.Add(3*time.Minute))
+	failed := meta.FindStatusCondition(run.Status.Conditions, obs.ConditionFailed)
+	g.Expect(failed.Status).To(Equal(metav1.ConditionTrue))
+	g.Expect(failed.Reason).To(Equal(obs.ReasonTimedOut))
+	g.Expect(failed.ObservedGeneration).To(Equal(int64(2)))
+	g.Expect(meta.IsStatusConditionFalse(run.Status.Conditions, obs.ConditionSuspended)).To(BeTrue())
+	g.Expect(run.Status.CompletionTime.Time).To(Equal(t0.Add(3 * time.Minute)))
+	g.Expect(run.Status.StartTime.Time).To(Equal(t0.Add(time.Minute)))
+}
+
+func TestRunConditionsThroughReconcile(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns", Finalizers: []string{finalizerName}},
+		Spec: obs.ObservatoryRunSpec{
+			Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{"a": {Image: "busybox"}}},
+		},
+	}
+	r := newTestReconciler(t, run)
+
+	res, got := reconcileRun(g, r, run)
+	// No polling: the Job's watch events bring the run back.
+	g.Expect(res).To(Equal(ctrl.Result{}))
+	g.Expect(meta.IsStatusConditionTrue(got.Status.Conditions, obs.ConditionProgressing)).To(BeTrue())
+	g.Expect(got.Status.StartTime).NotTo(BeNil())
+	g.Expect(got.Status.TotalTasks).To(Equal(int32(1)))
+
+	var job batchv1.Job
+	g.Expect(r.Get(ctx, client.ObjectKey{Name: attemptJobName(run, "a", 0), Namespace: "ns"}, &job)).To(Succeed())
+	job.Status.Succeeded = 1
+	g.Expect(r.Status().Update(ctx, &job)).To(Succeed())
+
+	_, got = reconcileRun(g, r, run)
+	g.Expect(got.Status.Phase).To(Equal(obs.PhaseSucceeded))
+	g.Expect(meta.IsStatusConditionTrue(got.Status.Conditions, obs.ConditionReady)).To(BeTrue())
+	g.Expect(meta.IsStatusConditionFalse(got.Status.Conditions, obs.ConditionProgressing)).To(BeTrue())
+	g.Expect(got.Status.SucceededTasks).To(Equal(int32(1)))
+	g.Expect(got.Status.CompletionTime).NotTo(BeNil())
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Event reasons recorded on ObservatoryRuns.
+const (
+	eventTaskStarted          = "TaskStarted"
+	eventTaskSucceeded        = "TaskSucceeded"
+	eventTaskFailed           = "TaskFailed"
+	eventTaskRetrying         = "TaskRetrying"
+	eventTaskAwaitingApproval = "TaskAwaitingApproval"
+	eventJobCreateFailed      = "JobCreateFailed"
+	eventRunSucceeded         = "RunSucceeded"
+	eventRunFailed            = "RunFailed"
+	eventRunCancelled         = "RunCancelled"
+	eventRunRetrying          = "RunRetrying"
+)
+
+// eventDedupWindow is how long an identical event for the same run is
+// suppressed. Reconciles of a busy run, or one whose Job keeps being
+// rejected, would otherwise record the same event on every pass.
+const eventDedupWindow = 5 * time.Minute
+
+// eventDeduper remembers recently recorded events. The zero value is ready
+// to use.
+type eventDeduper struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// allow reports whether an event with key may be recorded at now, and if
+// so remembers it.
+func (d *eventDeduper) allow(key string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if last, ok := d.seen[key]; ok && now.Sub(last) < eventDedupWindow {
+		return false
+	}
+	if d.seen == nil {
+		d.seen = map[string]time.Time{}
+	}
+	if len(d.seen) >= 1024 {
+		for k, t := range d.seen {
+			if now.Sub(t) >= eventDedupWindow {
+				delete(d.seen, k)
+			}
+		}
+	}
+	d.seen[key] = now
+	return true
+}
+
+// event records an event on run unless the reconciler has no Recorder or
+// an identical one was recorded within eventDedupWindow.
+func (r *ObservatoryRunReconciler) event(run *observatoryv1alpha1.ObservatoryRun, eventType, reason, format string, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if !r.events.allow(string(run.UID)+"/"+run.Name+"/"+reason+"/"+msg, time.Now()) {
+		return
+	}
+	r.Recorder.Event(run, eventType, reason, msg)
+}
+
+// taskEvent records the event for a task that just reached state.
+func (r *ObservatoryRunReconciler) taskEvent(run *observatoryv1alpha1.ObservatoryRun, name string, st *observatoryv1alpha1.TaskStatus) {
+	switch st.State {
+	case observatoryv1alpha1.TaskSucceeded:
+		r.event(run, corev1.EventTypeNormal, eventTaskSucceeded, "Task %s succeeded", name)
+	case observatoryv1alpha1.TaskFailed:
+		r.event(run, corev1.EventTypeWarning, eventTaskFailed, "Task %s failed: %s", name, st.Message)
+	}
+}
+
+// runEvent records the event for a run that just finished.
+func (r *ObservatoryRunReconciler) runEvent(run *observatoryv1alpha1.ObservatoryRun) {
+	switch run.Status.Phase {
+	case observatoryv1alpha1.PhaseSucceeded:
+		r.event(run, corev1.EventTypeNormal, eventRunSucceeded, "Run succeeded: %d/%d tasks succeeded", run.Status.SucceededTasks, run.Status.TotalTasks)
+	case observatoryv1alpha1.PhaseCancelled:
+		r.event(run, corev1.EventTypeWarning, eventRunCancelled, "Run was cancelled")
+	default:
+		msg := fmt.Sprintf("%d/%d tasks failed", run.Status.FailedTasks, run.Status.TotalTasks)
+		if run.Status.Reason != "" {
+			msg = run.Status.Reason + ": " + msg
+		}
+		r.event(run, corev1.EventTypeWarning, eventRunFailed, "Run failed: %s", msg)
+	}
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	obs "github.com/example/observatory-operator/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// drainEvents returns the events recorded so far.
+func drainEvents(rec *record.FakeRecorder) []string {
+	var out []string
+	for {
+		select {
+		case e := <-rec.Events:
+			out = append(out, e)
+		default:
+			return out
+		}
+	}
+}
+
+func TestTaskLifecycleEvents(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns", UID: "run-uid", Finalizers: []string{finalizerName}},
+		Spec: obs.ObservatoryRunSpec{
+			Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{"a": {Image: "busybox"}}},
+		},
+	}
+	r := newTestReconciler(t, run)
+	rec := record.NewFakeRecorder(100)
+	r.Recorder = rec
+
+	reconcileRun(g, r, run)
+	reconcileRun(g, r, run)
+	g.Expect(drainEvents(rec)).To(Equal([]string{"Normal TaskStarted Created Job " + attemptJobName(run, "a", 0) + " for task a"}))
+
+	var job batchv1.Job
+	g.Expect(r.Get(ctx, client.ObjectKey{Name: attemptJobName(run, "a", 0), Namespace: "ns"}, &job)).To(Succeed())
+	job.Status.Succeeded = 1
+	g.Expect(r.Status().Update(ctx, &job)).To(Succeed())
+
+	reconcileRun(g, r, run)
+	reconcileRun(g, r, run)
+	g.Expect(drainEvents(rec)).To(Equal([]string{
+		"Normal TaskSucceeded Task a succeeded",
+		"Normal RunSucceeded Run succeeded: 1/1 tasks succeeded",
+	}))
+}
+
+func TestEventDeduper(t *testing.T) {
+	g := NewWithT(t)
+
+	var d eventDeduper
+	t0 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	g.Expect(d.allow("k", t0)).To(BeTrue())
+	g.Expect(d.allow("k", t0.Add(time.Minute))).To(BeFalse())
+	g.Expect(d.allow("other", t0.Add(time.Minute))).To(BeTrue())
+	g.Expect(d.allow("k", t0.Add(eventDedupWindow))).To(BeTrue())
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Executor runs the tasks of one task type. The scheduling logic sees only
+// what an executor records in a task's status: the task has started once
+// JobName is set or its state has left Pending, and has finished once its
+// state is terminal.
+type Executor interface {
+	// Launch starts a frontier task or fan-out child and sets JobName to
+	// whatever identifies what it started. A task that cannot start yet
+	// stays Pending without a JobName, and one that never can is failed.
+	// Launch may also finish the task outright; the reconciler then
+	// schedules what that unblocks in the same reconcile. The run's project
+	// has admitted the task by then.
+	Launch(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun, task string) error
+	// Observe updates the status of the run's tasks that it launched.
+	Observe(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun) error
+	// Cancel removes what it launched for a task, stopping it if it is
+	// still running. The task's status is left to the caller.
+	Cancel(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun, task string, st *observatoryv1alpha1.TaskStatus) error
+}
+
+// executorFor returns the executor of a task type.
+func (r *ObservatoryRunReconciler) executorFor(taskType string) (Executor, bool) {
+	switch taskType {
+	case "", observatoryv1alpha1.TaskTypeJob, observatoryv1alpha1.TaskTypeShell:
+		return jobExecutor{r}, true
+	case observatoryv1alpha1.TaskTypeHTTP:
+		return httpExecutor{r}, true
+	case observatoryv1alpha1.TaskTypeApproval:
+		return approvalExecutor{r}, true
+	}
+	return nil, false
+}
+
+// executors returns every executor once, in the order their tasks are
+// observed.
+func (r *ObservatoryRunReconciler) executors() []Executor {
+	return []Executor{jobExecutor{r}, httpExecutor{r}, approvalExecutor{r}}
+}
+
+// observeTasks gives every declared task a status and lets each executor
+// update the tasks it launched.
+func (r *ObservatoryRunReconciler) observeTasks(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun) error {
+	// Defensive: ensure the status map always exists (guards against nil map on fresh objects or concurrent updates)
+	if run.Status.TaskStatuses == nil {
+		run.Status.TaskStatuses = map[string]*observatoryv1alpha1.TaskStatus{}
+	}
+	// Initialize all declared tasks to Pending unless overwritten by their executors
+	for _, tasks := range []map[string]observatoryv1alpha1.TaskSpec{run.Spec.Workflow.Tasks, run.Spec.Workflow.Finally} {
+		for name := range tasks {
+			if run.Status.TaskStatuses[name] == nil {
+				run.Status.TaskStatuses[name] = &observatoryv1alpha1.TaskStatus{State: observatoryv1alpha1.TaskPending}
+			}
+		}
+	}
+	for _, ex := range r.executors() {
+		if err := ex.Observe(ctx, run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// launchTask starts a frontier task with the executor of its type, unless
+// the run's project does not allow the image it would run.
+func (r *ObservatoryRunReconciler) launchTask(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun, task string, quota *projectQuota) error {
+	spec, _ := scheduledSpecFor(run, task)
+	ex, ok := r.executorFor(spec.Type)
+	if !ok {
+		// The webhook rejects these; reaching here means it was bypassed.
+		st := taskStatusFor(run, task)
+		st.State = observatoryv1alpha1.TaskFailed
+		st.Message = fmt.Sprintf("unknown task type %q", spec.Type)
+		return nil
+	}
+	// Images may come from parameters; a task whose inputs do not resolve
+	// is failed by its executor.
+	if resolved, err := resolveScheduled(run, task); err == nil {
+		if image, ok := containerImage(resolved); ok && !quota.allowsImage(image) {
+			st := taskStatusFor(run, task)
+			st.State = observatoryv1alpha1.TaskFailed
+			st.Reason = observatoryv1alpha1.ReasonNotAllowed
+			st.Message = quota.notAllowed(fmt.Sprintf("image %q", image))
+			return nil
+		}
+	}
+	return ex.Launch(ctx, run, task)
+}
+
+// containerImage returns the image of the container a task runs in, for
+// tasks run as Jobs.
+func containerImage(spec observatoryv1alpha1.TaskSpec) (string, bool) {
+	switch spec.Type {
+	case "", observatoryv1alpha1.TaskTypeJob, observatoryv1alpha1.TaskTypeShell:
+		if spec.Image == "" {
+			return defaultTaskImage, true
+		}
+		return spec.Image, true
+	}
+	return "", false
+}
+
+// cancelTask removes what a task launched through the executor of its type.
+func (r *ObservatoryRunReconciler) cancelTask(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun, task string, st *observatoryv1alpha1.TaskStatus) error {
+	spec, _ := scheduledSpecFor(run, task)
+	ex, ok := r.executorFor(spec.Type)
+	if !ok {
+		return nil
+	}
+	return ex.Cancel(ctx, run, task, st)
+}
+
+// jobExecutor runs a task as a Job, one per attempt with a retryStrategy.
+type jobExecutor struct {
+	r *ObservatoryRunReconciler
+}
+
+func (e jobExecutor) Launch(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun, task string) error {
+	return e.r.ensureJob(ctx, run, task)
+}
+
+func (e jobExecutor) Observe(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun) error {
+	return e.r.collectJobStatuses(ctx, run)
+}
+
+func (e jobExecutor) Cancel(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun, task string, st *observatoryv1alpha1.TaskStatus) error {
+	if st.JobName == "" {
+		return nil
+	}
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: st.JobName, Namespace: run.Namespace}}
+	return client.IgnoreNotFound(e.r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)))
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	obs "github.com/example/observatory-operator/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestTaskTypeSelectsExecutor(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns", Finalizers: []string{finalizerName}},
+		Spec: obs.ObservatoryRunSpec{
+			Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{
+				"a": {Image: "busybox"},
+				"b": {Image: "busybox", Type: obs.TaskTypeShell},
+				"c": {Image: "busybox", Type: "lambda"},
+			}},
+		},
+	}
+	r := newTestReconciler(t, run)
+
+	_, got := reconcileRun(g, r, run)
+	var jobs batchv1.JobList
+	g.Expect(r.List(ctx, &jobs, client.MatchingLabels{labelRun: "r"})).To(Succeed())
+	g.Expect(jobs.Items).To(HaveLen(2))
+	for _, name := range []string{"a", "b"} {
+		g.Expect(got.Status.TaskStatuses[name].JobName).To(Equal(attemptJobName(run, name, 0)))
+	}
+	// Only reachable past the webhook.
+	g.Expect(got.Status.TaskStatuses["c"].State).To(Equal(obs.TaskFailed))
+	g.Expect(got.Status.TaskStatuses["c"].Message).To(Equal(`unknown task type "lambda"`))
+
+	st := got.Status.TaskStatuses["a"]
+	g.Expect(r.cancelTask(ctx, got, "a", st)).To(Succeed())
+	g.Expect(r.List(ctx, &jobs, client.MatchingLabels{labelRun: "r"})).To(Succeed())
+	g.Expect(jobs.Items).To(HaveLen(1))
+}
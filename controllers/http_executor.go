@@ -0,0 +1,540 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	"github.com/example/observatory-operator/internal/metrics"
+	"github.com/example/observatory-operator/internal/tracing"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// httpDefaultTimeout bounds a call of an http task without a timeout.
+	httpDefaultTimeout = 30 * time.Second
+	// maxHTTPResponse is how much of a response body is read; outputs are
+	// capped like the termination messages job tasks report them in.
+	maxHTTPResponse = 1 << 20
+	maxHTTPOutput   = 4096
+	// httpResultTTL is how long a finished call is kept for a run that
+	// never observes it, e.g. one deleted meanwhile.
+	httpResultTTL = time.Hour
+)
+
+// httpExecutor runs an http task by making its request from the controller.
+// Each attempt is one call, made in the background and tracked in
+// ObservatoryRunReconciler.calls under the name in the task's JobName; a
+// call lost to a controller restart fails its attempt.
+type httpExecutor struct {
+	r *ObservatoryRunReconciler
+}
+
+// httpCall is an http task attempt in flight or finished.
+type httpCall struct {
+	run     types.UID
+	cancel  context.CancelFunc
+	started time.Time
+	done    bool
+	result  httpResult
+}
+
+// httpResult is the outcome of a call: its outputs, or why it failed.
+type httpResult struct {
+	status   int
+	outputs  map[string]string
+	failure  *attemptFailure
+	duration time.Duration
+}
+
+// httpCalls tracks the calls of http tasks by namespace/name. The zero value
+// is ready to use.
+type httpCalls struct {
+	mu    sync.Mutex
+	calls map[string]*httpCall
+}
+
+func (c *httpCalls) get(key string) (httpCall, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	call, ok := c.calls[key]
+	if !ok {
+		return httpCall{}, false
+	}
+	return *call, true
+}
+
+func (c *httpCalls) start(key string, call *httpCall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.calls == nil {
+		c.calls = map[string]*httpCall{}
+	}
+	c.calls[key] = call
+}
+
+func (c *httpCalls) finish(key string, call *httpCall, res httpResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.calls[key] == call {
+		call.done, call.result = true, res
+	}
+}
+
+// stop cancels a call and forgets it.
+func (c *httpCalls) stop(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call, ok := c.calls[key]; ok {
+		call.cancel()
+		delete(c.calls, key)
+	}
+}
+
+// prune forgets the finished calls of run that none of its unfinished tasks
+// still waits on, and those of any run older than httpResultTTL.
+func (c *httpCalls) prune(run *observatoryv1alpha1.ObservatoryRun, now time.Time) {
+	waiting := map[string]bool{}
+	for _, st := range run.Status.TaskStatuses {
+		if st != nil && st.JobName != "" && !isTaskTerminal(st.State) {
+			waiting[httpCallKey(run, st.JobName)] = true
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, call := range c.calls {
+		if call.done && (call.run == run.UID && !waiting[key] || now.Sub(call.started) > httpResultTTL) {
+			delete(c.calls, key)
+		}
+	}
+}
+
+// stopRun cancels and forgets every call of a run.
+func (c *httpCalls) stopRun(uid types.UID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, call := range c.calls {
+		if call.run == uid {
+			call.cancel()
+			delete(c.calls, key)
+		}
+	}
+}
+
+func httpCallKey(run *observatoryv1alpha1.ObservatoryRun, name string) string {
+	return run.Namespace + "/" + name
+}
+
+func (e httpExecutor) Launch(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun, task string) error {
+	st := taskStatusFor(run, task)
+	name := attemptJobName(run, task, st.Attempt)
+	key := httpCallKey(run, name)
+	if _, ok := e.r.calls.get(key); ok {
+		// Started by a reconcile whose status update was lost.
+		st.JobName = name
+		st.State = observatoryv1alpha1.TaskRunning
+		return nil
+	}
+
+	spec, err := resolveScheduled(run, task)
+	if err != nil {
+		st.State = observatoryv1alpha1.TaskFailed
+		st.Message = fmt.Sprintf("cannot resolve task inputs: %v", err)
+		return nil
+	}
+	req, err := e.request(ctx, run, task, spec)
+	if err != nil {
+		if errors.Is(err, errHTTPSecret) {
+			// Like a rejected Job: leave it Pending until the Secret exists.
+			st.State = observatoryv1alpha1.TaskPending
+			st.Message = err.Error()
+			e.r.event(run, corev1.EventTypeWarning, eventJobCreateFailed, "Task %s: %v", task, err)
+			return nil
+		}
+		st.State = observatoryv1alpha1.TaskFailed
+		st.Message = err.Error()
+		if errors.Is(err, errHTTPHost) {
+			st.Reason = observatoryv1alpha1.ReasonNotAllowed
+		}
+		return nil
+	}
+
+	timeout := httpDefaultTimeout
+	if spec.Timeout != nil && spec.Timeout.Duration > 0 {
+		timeout = spec.Timeout.Duration
+	}
+	callCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	call := &httpCall{run: run.UID, cancel: cancel, started: time.Now()}
+	e.r.calls.start(key, call)
+	runKey := types.NamespacedName{Name: run.Name, Namespace: run.Namespace}
+	go func() {
+		defer cancel()
+		res := doHTTP(callCtx, e.r.httpClient(), req.WithContext(callCtx), spec)
+		e.r.calls.finish(key, call, res)
+		// Wake the run up rather than wait for its next requeue.
+		e.r.wakeRun(runKey)
+	}()
+
+	now := metav1.NewTime(call.started)
+	st.JobName = name
+	st.State = observatoryv1alpha1.TaskRunning
+	st.Message = fmt.Sprintf("Calling %s %s", req.Method, redactURL(req.URL))
+	st.NextAttemptAt = nil
+	st.FinishedAt = nil
+	if st.StartedAt == nil {
+		st.StartedAt = &now
+	}
+	st.Attempts = append(st.Attempts, observatoryv1alpha1.TaskAttempt{
+		Attempt: st.Attempt, JobName: name, State: observatoryv1alpha1.TaskRunning, StartedAt: &now,
+	})
+	st.AttemptCount = max(st.AttemptCount, st.Attempt+1)
+	trimAttempts(st)
+	e.r.event(run, corev1.EventTypeNormal, eventTaskStarted, "Calling %s for task %s", redactURL(req.URL), task)
+	log.FromContext(ctx).Info("Started http call", "call", name, "task", task)
+	return nil
+}
+
+// wakeRun queues a reconcile of a run through httpDone. It gives up once
+// the manager stops, as nothing reads httpDone any more.
+func (r *ObservatoryRunReconciler) wakeRun(key types.NamespacedName) {
+	if r.httpDone == nil {
+		return
+	}
+	obj := &observatoryv1alpha1.ObservatoryRun{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+	select {
+	case r.httpDone <- event.GenericEvent{Object: obj}:
+	case <-r.stopped:
+	}
+}
+
+var (
+	// errHTTPSecret marks a header Secret that could not be read.
+	errHTTPSecret = errors.New("cannot read header secret")
+	// errHTTPHost marks a call to a host not in HTTPAllowedHosts.
+	errHTTPHost = errors.New("is not an allowed host for http tasks")
+)
+
+// request builds the request of an http task from its resolved spec.
+func (e httpExecutor) request(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun, task string, spec observatoryv1alpha1.TaskSpec) (*http.Request, error) {
+	h := spec.HTTP
+	if h == nil {
+		// The webhook rejects these; reaching here means it was bypassed.
+		return nil, fmt.Errorf("type http requires http")
+	}
+	method := h.Method
+	if method == "" {
+		method = http.MethodGet
+		if h.Body != "" {
+			method = http.MethodPost
+		}
+	}
+	var body io.Reader
+	if h.Body != "" {
+		body = strings.NewReader(h.Body)
+	}
+	req, err := http.NewRequest(method, h.URL, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http request: %v", err)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("http.url %q is not an absolute http or https URL", redactURL(req.URL))
+	}
+	// Before any Secret is read for it.
+	if !e.r.httpHostAllowed(req.URL.Hostname()) {
+		return nil, fmt.Errorf("http.url host %q %w; see the controller's --http-allowed-hosts", req.URL.Hostname(), errHTTPHost)
+	}
+	for _, hdr := range h.Headers {
+		value := hdr.Value
+		if ref := hdr.SecretKeyRef; ref != nil {
+			var secret corev1.Secret
+			if err := e.r.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: run.Namespace}, &secret); err != nil {
+				if apierrors.IsForbidden(err) {
+					return nil, fmt.Errorf("%w %s for header %s: the controller may not read Secrets in namespace %s; bind ClusterRole %s to it there",
+						errHTTPSecret, ref.Name, hdr.Name, run.Namespace, httpSecretsClusterRole)
+				}
+				return nil, fmt.Errorf("%w %s for header %s: %v", errHTTPSecret, ref.Name, hdr.Name, err)
+			}
+			v, ok := secret.Data[ref.Key]
+			if !ok {
+				return nil, fmt.Errorf("%w %s for header %s: no key %q", errHTTPSecret, ref.Name, hdr.Name, ref.Key)
+			}
+			value = string(v)
+		}
+		req.Header.Add(hdr.Name, value)
+	}
+	if tracingEnabled(run) {
+		req.Header.Set("traceparent", traceparentFor(run, task, taskStatusFor(run, task).Attempt))
+	}
+	return req, nil
+}
+
+// doHTTP makes a call and classifies its outcome the way classifyAttempt
+// does a Job's: timeouts and calls without a response or with a 5xx one are
+// errors, and the status code stands in for an exit code.
+func doHTTP(ctx context.Context, c *http.Client, req *http.Request, spec observatoryv1alpha1.TaskSpec) httpResult {
+	start := time.Now()
+	res := httpResult{}
+	defer func() { res.duration = time.Since(start) }()
+
+	resp, err := c.Do(req)
+	if err != nil {
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			res.failure = &attemptFailure{reason: "DeadlineExceeded", message: "timed out", infra: true}
+		case errors.Is(err, errHTTPHost):
+			// Redirected somewhere it may not go; retrying would end the same.
+			res.failure = &attemptFailure{reason: "RedirectNotAllowed", message: err.Error()}
+		default:
+			res.failure = &attemptFailure{reason: "RequestFailed", message: err.Error(), infra: true}
+		}
+		return res
+	}
+	defer resp.Body.Close()
+	res.status = resp.StatusCode
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPResponse))
+	if err != nil {
+		reason := "RequestFailed"
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			reason = "DeadlineExceeded"
+		}
+		res.failure = &attemptFailure{reason: reason, message: fmt.Sprintf("reading response: %v", err), infra: true}
+		return res
+	}
+
+	if !expectedStatus(spec.HTTP.ExpectedStatus, resp.StatusCode) {
+		code := int32(resp.StatusCode)
+		msg := fmt.Sprintf("HTTP %d", resp.StatusCode)
+		if snippet := strings.TrimSpace(string(body)); snippet != "" {
+			msg += ": " + snippet
+		}
+		res.failure = &attemptFailure{reason: "UnexpectedStatus", message: truncateMessage(msg), exitCode: &code, infra: resp.StatusCode >= 500}
+		return res
+	}
+
+	outputs, err := httpOutputs(body, spec.Outputs)
+	if err != nil {
+		res.failure = &attemptFailure{reason: "InvalidResponse", message: truncateMessage(err.Error())}
+		return res
+	}
+	res.outputs = outputs
+	return res
+}
+
+// expectedStatus reports whether code counts as success: one of want, or
+// any 2xx when want is empty.
+func expectedStatus(want []int32, code int) bool {
+	if len(want) == 0 {
+		return code >= 200 && code < 300
+	}
+	return slices.Contains(want, int32(code))
+}
+
+// httpOutputs reads a task's declared outputs from a response body. A
+// jsonPath selecting a single string yields that string, and anything else
+// its JSON.
+func httpOutputs(body []byte, declared []observatoryv1alpha1.OutputSpec) (map[string]string, error) {
+	out := map[string]string{}
+	var data interface{}
+	parsed := false
+	for _, o := range declared {
+		v := string(body)
+		if o.JSONPath != "" {
+			if !parsed {
+				if err := json.Unmarshal(body, &data); err != nil {
+					return nil, fmt.Errorf("response is not JSON: %v", err)
+				}
+				parsed = true
+			}
+			var err error
+			if v, err = selectJSON(data, o.Name, o.JSONPath); err != nil {
+				return nil, fmt.Errorf("output %s: %v", o.Name, err)
+			}
+		}
+		if len(v) > maxHTTPOutput {
+			return nil, fmt.Errorf("output %s is %d bytes, more than %d", o.Name, len(v), maxHTTPOutput)
+		}
+		out[o.Name] = v
+	}
+	return out, nil
+}
+
+func selectJSON(data interface{}, name, path string) (string, error) {
+	jp := jsonpath.New(name)
+	if err := jp.Parse(path); err != nil {
+		return "", err
+	}
+	results, err := jp.FindResults(data)
+	if err != nil {
+		return "", err
+	}
+	var values []interface{}
+	for _, r := range results {
+		for _, v := range r {
+			values = append(values, v.Interface())
+		}
+	}
+	var selected interface{} = values
+	switch len(values) {
+	case 0:
+		return "", fmt.Errorf("%s matched nothing", path)
+	case 1:
+		if s, ok := values[0].(string); ok {
+			return s, nil
+		}
+		selected = values[0]
+	}
+	b, err := json.Marshal(selected)
+	return string(b), err
+}
+
+// redactURL drops the userinfo and query of u, which may carry credentials.
+func redactURL(u *url.URL) string {
+	c := *u
+	c.User, c.RawQuery, c.Fragment = nil, "", ""
+	return c.String()
+}
+
+func (e httpExecutor) Observe(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun) error {
+	e.r.calls.prune(run, time.Now())
+	for _, name := range sortedKeys(run.Status.TaskStatuses) {
+		st := run.Status.TaskStatuses[name]
+		spec, ok := scheduledSpecFor(run, name)
+		if !ok || spec.Type != observatoryv1alpha1.TaskTypeHTTP || st.JobName == "" || st.State != observatoryv1alpha1.TaskRunning {
+			continue
+		}
+		call, ok := e.r.calls.get(httpCallKey(run, st.JobName))
+		if !ok {
+			// Made by a controller that has since restarted.
+			call = httpCall{done: true, result: httpResult{failure: &attemptFailure{
+				reason: "CallLost", message: "the controller restarted during the call", infra: true,
+			}}}
+		}
+		if !call.done {
+			continue
+		}
+		e.finish(ctx, run, name, st, spec, call.result)
+	}
+	return nil
+}
+
+// finish records the outcome of a task's current call.
+func (e httpExecutor) finish(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun, name string, st *observatoryv1alpha1.TaskStatus, spec observatoryv1alpha1.TaskSpec, res httpResult) {
+	now := metav1.Now()
+	a := findAttempt(st, st.JobName, "")
+	if a == nil {
+		st.Attempts = append(st.Attempts, observatoryv1alpha1.TaskAttempt{Attempt: st.Attempt, JobName: st.JobName})
+		a = &st.Attempts[len(st.Attempts)-1]
+	}
+	a.FinishedAt = &now
+
+	if res.failure == nil {
+		a.State = observatoryv1alpha1.TaskSucceeded
+		st.State = observatoryv1alpha1.TaskSucceeded
+		st.Reason = ""
+		st.Outputs = res.outputs
+		st.Message = fmt.Sprintf("HTTP %d in %s", res.status, res.duration.Round(time.Millisecond))
+		st.FinishedAt = &now
+		trimAttempts(st)
+		metrics.JobCompleted.WithLabelValues(metrics.StatusSuccess).Inc()
+		e.r.taskEvent(run, name, st)
+		e.recordSpan(run, name, a, *st)
+		return
+	}
+
+	f := *res.failure
+	a.State = observatoryv1alpha1.TaskFailed
+	a.Reason, a.Message, a.ExitCode = f.reason, truncateMessage(f.message), f.exitCode
+	trimAttempts(st)
+	rs := spec.RetryStrategy
+	if rs == nil {
+		// retries counts calls after the first, as backoffLimit does pods.
+		rs = &observatoryv1alpha1.RetryStrategy{}
+		if spec.Retries != nil {
+			rs.Limit = *spec.Retries
+		}
+	}
+	failAttempt(ctx, st, rs, f, st.JobName)
+	// The attempt failed even if the task goes on.
+	e.recordSpan(run, name, a, observatoryv1alpha1.TaskStatus{State: observatoryv1alpha1.TaskFailed, Message: a.Message})
+	if st.State == observatoryv1alpha1.TaskFailed {
+		st.FinishedAt = &now
+		e.r.taskEvent(run, name, st)
+	} else {
+		e.r.event(run, corev1.EventTypeWarning, eventTaskRetrying, "Task %s: %s", name, st.Message)
+	}
+}
+
+// recordSpan records the span of the call made for attempt a, under the
+// traceparent the call carried.
+func (e httpExecutor) recordSpan(run *observatoryv1alpha1.ObservatoryRun, task string, a *observatoryv1alpha1.TaskAttempt, st observatoryv1alpha1.TaskStatus) {
+	if !tracingEnabled(run) || a.StartedAt == nil {
+		return
+	}
+	e.r.recordSpans([]tracing.Span{attemptSpan(run, task, a.Attempt, &st, a.StartedAt.Time, a.FinishedAt.Time)})
+}
+
+func (e httpExecutor) Cancel(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun, task string, st *observatoryv1alpha1.TaskStatus) error {
+	if st.JobName != "" {
+		e.r.calls.stop(httpCallKey(run, st.JobName))
+	}
+	return nil
+}
+
+// httpClient returns the client http tasks are called with.
+func (r *ObservatoryRunReconciler) httpClient() *http.Client {
+	c := *http.DefaultClient
+	if r.HTTPClient != nil {
+		c = *r.HTTPClient
+	}
+	// A redirect is a call to another host, so it must be allowed too.
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !r.httpHostAllowed(req.URL.Hostname()) {
+			return fmt.Errorf("redirect to host %q %w", req.URL.Hostname(), errHTTPHost)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return &c
+}
+
+// httpSecretsClusterRole is the ClusterRole that lets the controller read
+// header Secrets in a namespace it is bound in; see config/rbac.
+const httpSecretsClusterRole = "observatory-http-task-secrets"
+
+// httpHostAllowed reports whether http tasks may call host. An entry of
+// HTTPAllowedHosts matches the host it names, and one of the form
+// "*.example.com" matches any host below example.com.
+func (r *ObservatoryRunReconciler) httpHostAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return false
+	}
+	for _, allowed := range r.HTTPAllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*"); ok && strings.HasPrefix(suffix, ".") {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,273 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	obs "github.com/example/observatory-operator/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// httpRun returns a run of a single http task.
+func httpRun(spec obs.TaskSpec) *obs.ObservatoryRun {
+	spec.Type = obs.TaskTypeHTTP
+	return &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns", UID: "r-uid", Finalizers: []string{finalizerName}},
+		Spec:       obs.ObservatoryRunSpec{Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{"call": spec}}},
+	}
+}
+
+// newHTTPReconciler returns a test reconciler whose http tasks may call
+// the test servers.
+func newHTTPReconciler(t *testing.T, objs ...client.Object) *ObservatoryRunReconciler {
+	r := newTestReconciler(t, objs...)
+	r.HTTPAllowedHosts = []string{"127.0.0.1", "example.invalid"}
+	return r
+}
+
+// reconcileHTTP reconciles run until its http task has made n calls and
+// returns it after the reconcile that observed the last one.
+func reconcileHTTP(g Gomega, r *ObservatoryRunReconciler, run *obs.ObservatoryRun, n int) *obs.ObservatoryRun {
+	r.httpDone = make(chan event.GenericEvent, 1)
+	_, got := reconcileRun(g, r, run)
+	for i := 0; i < n; i++ {
+		g.Eventually(r.httpDone).Should(Receive())
+		_, got = reconcileRun(g, r, run)
+	}
+	return got
+}
+
+func TestHTTPTaskCapturesOutput(t *testing.T) {
+	g := NewWithT(t)
+
+	var auth, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth = req.Header.Get("Authorization")
+		b, _ := io.ReadAll(req.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"ticket": {"id": "OPS-7", "labels": ["a", "b"]}}`))
+	}))
+	defer srv.Close()
+
+	run := httpRun(obs.TaskSpec{
+		HTTP: &obs.HTTPSpec{
+			URL:  srv.URL + "/tickets",
+			Body: `{"env": "{{inputs.parameters.env}}"}`,
+			Headers: []obs.HTTPHeader{{Name: "Authorization", SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "api"}, Key: "token",
+			}}},
+		},
+		Outputs: []obs.OutputSpec{{Name: "id", JSONPath: "{.ticket.id}"}, {Name: "labels", JSONPath: "{.ticket.labels}"}},
+	})
+	env := "prod"
+	run.Spec.Parameters = []obs.ParameterSpec{{Name: "env", Value: &env}}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "ns"},
+		Data:       map[string][]byte{"token": []byte("Bearer s3cret")},
+	}
+	r := newHTTPReconciler(t, run, secret)
+
+	got := reconcileHTTP(g, r, run, 1)
+	st := got.Status.TaskStatuses["call"]
+	g.Expect(st.State).To(Equal(obs.TaskSucceeded), st.Message)
+	g.Expect(st.Message).To(HavePrefix("HTTP 201 in "))
+	g.Expect(st.Outputs).To(Equal(map[string]string{"id": "OPS-7", "labels": `["a","b"]`}))
+	g.Expect(got.Status.Phase).To(Equal(obs.PhaseSucceeded))
+	g.Expect(auth).To(Equal("Bearer s3cret"))
+	g.Expect(body).To(Equal(`{"env": "prod"}`))
+}
+
+func TestHTTPTaskRetries(t *testing.T) {
+	g := NewWithT(t)
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	run := httpRun(obs.TaskSpec{
+		HTTP:          &obs.HTTPSpec{URL: srv.URL},
+		RetryStrategy: &obs.RetryStrategy{Limit: 2, RetryOn: obs.RetryOnError},
+	})
+	r := newHTTPReconciler(t, run)
+
+	got := reconcileHTTP(g, r, run, 2)
+	st := got.Status.TaskStatuses["call"]
+	g.Expect(st.State).To(Equal(obs.TaskSucceeded), st.Message)
+	g.Expect(st.Attempt).To(Equal(int32(1)))
+	g.Expect(st.Attempts).To(HaveLen(2))
+	g.Expect(st.Attempts[0].Reason).To(Equal("UnexpectedStatus"))
+	g.Expect(*st.Attempts[0].ExitCode).To(Equal(int32(503)))
+	g.Expect(st.Attempts[0].Message).To(Equal("HTTP 503: warming up"))
+}
+
+func TestHTTPTaskFailures(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/slow":
+			<-block
+		case "/missing":
+			http.NotFound(w, req)
+		default:
+			w.Write([]byte("not json"))
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	for name, tc := range map[string]struct {
+		spec   obs.TaskSpec
+		reason string
+	}{
+		"timeout": {
+			spec:   obs.TaskSpec{HTTP: &obs.HTTPSpec{URL: srv.URL + "/slow"}, Timeout: &metav1.Duration{Duration: 50 * time.Millisecond}},
+			reason: obs.ReasonTimedOut,
+		},
+		// A 4xx is not an error to retry on.
+		"not found": {
+			spec: obs.TaskSpec{HTTP: &obs.HTTPSpec{URL: srv.URL + "/missing"}, RetryStrategy: &obs.RetryStrategy{Limit: 3, RetryOn: obs.RetryOnError}},
+		},
+		"bad response": {
+			spec: obs.TaskSpec{HTTP: &obs.HTTPSpec{URL: srv.URL}, Outputs: []obs.OutputSpec{{Name: "id", JSONPath: "{.id}"}}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			run := httpRun(tc.spec)
+			r := newHTTPReconciler(t, run)
+
+			st := reconcileHTTP(g, r, run, 1).Status.TaskStatuses["call"]
+			g.Expect(st.State).To(Equal(obs.TaskFailed))
+			g.Expect(st.Reason).To(Equal(tc.reason))
+			g.Expect(st.Message).To(HavePrefix("Failed after 1 attempts"))
+		})
+	}
+}
+
+func TestHTTPTaskWaitsForSecret(t *testing.T) {
+	g := NewWithT(t)
+
+	run := httpRun(obs.TaskSpec{HTTP: &obs.HTTPSpec{
+		URL: "http://example.invalid",
+		Headers: []obs.HTTPHeader{{Name: "X-Token", SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "api"}, Key: "token",
+		}}},
+	}})
+	r := newHTTPReconciler(t, run)
+
+	res, got := reconcileRun(g, r, run)
+	st := got.Status.TaskStatuses["call"]
+	g.Expect(st.State).To(Equal(obs.TaskPending))
+	g.Expect(st.JobName).To(BeEmpty())
+	g.Expect(st.Message).To(ContainSubstring("cannot read header secret api"))
+	g.Expect(res.RequeueAfter).To(Equal(queuePollInterval))
+}
+
+func TestHTTPTaskLostCall(t *testing.T) {
+	g := NewWithT(t)
+
+	run := httpRun(obs.TaskSpec{HTTP: &obs.HTTPSpec{URL: "http://example.invalid"}})
+	run.Status.TaskStatuses = map[string]*obs.TaskStatus{
+		"call": {State: obs.TaskRunning, JobName: attemptJobName(run, "call", 0)},
+	}
+	// A new controller knows nothing of the calls of the one before it.
+	r := newHTTPReconciler(t, run)
+
+	g.Expect(r.observeTasks(context.Background(), run)).To(Succeed())
+	st := run.Status.TaskStatuses["call"]
+	g.Expect(st.State).To(Equal(obs.TaskFailed))
+	g.Expect(st.Message).To(ContainSubstring("CallLost"))
+}
+
+func TestWakeRunAfterManagerStops(t *testing.T) {
+	g := NewWithT(t)
+
+	r := newTestReconciler(t)
+	r.httpDone = make(chan event.GenericEvent)
+	r.stopped = make(chan struct{})
+	close(r.stopped)
+
+	// Nothing reads httpDone; the call's goroutine must still return.
+	done := make(chan struct{})
+	go func() {
+		r.wakeRun(types.NamespacedName{Name: "r", Namespace: "ns"})
+		close(done)
+	}()
+	g.Eventually(done).Should(BeClosed())
+}
+
+func TestHTTPTaskHostNotAllowed(t *testing.T) {
+	g := NewWithT(t)
+
+	run := httpRun(obs.TaskSpec{HTTP: &obs.HTTPSpec{
+		URL: "http://kubernetes.default.svc/api",
+		Headers: []obs.HTTPHeader{{Name: "X-Token", SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "api"}, Key: "token",
+		}}},
+	}})
+	r := newHTTPReconciler(t, run)
+
+	// Refused before the Secret, which does not exist, is looked for.
+	_, got := reconcileRun(g, r, run)
+	st := got.Status.TaskStatuses["call"]
+	g.Expect(st.State).To(Equal(obs.TaskFailed))
+	g.Expect(st.Reason).To(Equal(obs.ReasonNotAllowed))
+	g.Expect(st.Message).To(ContainSubstring(`host "kubernetes.default.svc" is not an allowed host`))
+}
+
+func TestHTTPTaskRedirectNotAllowed(t *testing.T) {
+	g := NewWithT(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "http://169.254.169.254/latest/meta-data", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	run := httpRun(obs.TaskSpec{
+		HTTP:          &obs.HTTPSpec{URL: srv.URL},
+		RetryStrategy: &obs.RetryStrategy{Limit: 2, RetryOn: obs.RetryOnError},
+	})
+	r := newHTTPReconciler(t, run)
+
+	st := reconcileHTTP(g, r, run, 1).Status.TaskStatuses["call"]
+	g.Expect(st.State).To(Equal(obs.TaskFailed))
+	g.Expect(st.Attempts[0].Reason).To(Equal("RedirectNotAllowed"))
+}
+
+func TestHTTPHostAllowed(t *testing.T) {
+	g := NewWithT(t)
+
+	r := &ObservatoryRunReconciler{HTTPAllowedHosts: []string{"api.example.com", "*.internal.example", "10.0.0.7"}}
+	for host, want := range map[string]bool{
+		"api.example.com":          true,
+		"API.example.com.":         true,
+		"tickets.internal.example": true,
+		"a.b.internal.example":     true,
+		"10.0.0.7":                 true,
+		"internal.example":         false,
+		"evilinternal.example":     false,
+		"example.com":              false,
+		"10.0.0.8":                 false,
+		"":                         false,
+	} {
+		g.Expect(r.httpHostAllowed(host)).To(Equal(want), host)
+	}
+	g.Expect((&ObservatoryRunReconciler{}).httpHostAllowed("api.example.com")).To(BeFalse())
+}
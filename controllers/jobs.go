@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// labelRunUID ties a Job to its run. Unlike the run name it is always a
+// valid label value, and it tells a run apart from an earlier one of the
+// same name whose Jobs are still being garbage collected.
+const labelRunUID = "obs.seventh/run-uid"
+
+// TaskPodSelector selects the pods of task Jobs, which carry labelRunUID.
+// The manager should cache only these rather than every pod in the
+// cluster; pods of Jobs created before the label was set on pod templates
+// are then not seen, and their tasks are followed by Job status alone.
+func TaskPodSelector() labels.Selector {
+	req, err := labels.NewRequirement(labelRunUID, selection.Exists, nil)
+	if err != nil {
+		panic(err)
+	}
+	return labels.NewSelector().Add(*req)
+}
+
+// annotationTask holds the name of the task a Job belongs to when the name
+// is too long for labelTask, as fan-out children of long task names are.
+const annotationTask = "obs.seventh/task"
+
+// jobNameHashLength is the length of the hash ending every Job name.
+const jobNameHashLength = 8
+
+// attemptJobName names the Job of one attempt of a task: a readable
+// <run>-<task>[-retry-N] prefix followed by a hash of the run, task and
+// attempt. The hash keeps runs whose names share a prefix, such as "build"
+// and "build-x", from colliding, and the prefix is cut so that the name
+// fits the job-name label the Job controller sets on its pods.
+func attemptJobName(run *observatoryv1alpha1.ObservatoryRun, task string, attempt int32) string {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s/%s/%s/%d", run.UID, run.Name, task, attempt)
+
+	prefix := run.Name + "-" + task
+	if attempt > 0 {
+		prefix += fmt.Sprintf("-retry-%d", attempt)
+	}
+	prefix = strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			return c
+		case c >= 'A' && c <= 'Z':
+			return c - 'A' + 'a'
+		}
+		return '-'
+	}, prefix)
+	if max := validation.DNS1123LabelMaxLength - jobNameHashLength - 1; len(prefix) > max {
+		prefix = prefix[:max]
+	}
+	return fmt.Sprintf("%s-%0*x", strings.TrimRight(prefix, "-"), jobNameHashLength, h.Sum32())
+}
+
+// jobLabels returns the labels of a task's Job. The run name is only there
+// for people selecting Jobs by hand, and is left out when it is too long to
+// be a label value.
+func jobLabels(run *observatoryv1alpha1.ObservatoryRun, task string) map[string]string {
+	labels := map[string]string{
+		labelRunUID: string(run.UID),
+		labelTask:   taskLabelValue(task),
+	}
+	if len(validation.IsValidLabelValue(run.Name)) == 0 {
+		labels[labelRun] = run.Name
+	}
+	return labels
+}
+
+// jobAnnotations returns the annotations of a task's Job: annotationTask
+// when labelTask could not hold the task name.
+func jobAnnotations(task string) map[string]string {
+	if taskLabelValue(task) == task {
+		return nil
+	}
+	return map[string]string{annotationTask: task}
+}
+
+// taskLabelValue is the labelTask value of task: the task name, or when it
+// is too long to be a label value, a cut of it followed by a hash of it.
+func taskLabelValue(task string) string {
+	if len(validation.IsValidLabelValue(task)) == 0 {
+		return task
+	}
+	h := fnv.New32a()
+	h.Write([]byte(task))
+	prefix := task
+	if max := validation.LabelValueMaxLength - jobNameHashLength - 1; len(prefix) > max {
+		prefix = prefix[:max]
+	}
+	return fmt.Sprintf("%s-%0*x", strings.TrimRight(prefix, "-_."), jobNameHashLength, h.Sum32())
+}
+
+// jobTask returns the name of the task a Job belongs to.
+func jobTask(j *batchv1.Job) string {
+	if task, ok := j.Annotations[annotationTask]; ok {
+		return task
+	}
+	return j.Labels[labelTask]
+}
+
+// listRunJobs returns the Jobs controlled by run, labelling any created
+// before Jobs carried labelTask and labelRunUID.
+func (r *ObservatoryRunReconciler) listRunJobs(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun) ([]batchv1.Job, error) {
+	var list batchv1.JobList
+	if err := r.List(ctx, &list, client.InNamespace(run.Namespace), client.MatchingLabels{labelRunUID: string(run.UID)}); err != nil {
+		return nil, err
+	}
+	var jobs []batchv1.Job
+	for _, j := range list.Items {
+		if metav1.IsControlledBy(&j, run) {
+			jobs = append(jobs, j)
+		}
+	}
+	adopted, err := r.adoptLegacyJobs(ctx, run)
+	if err != nil {
+		return nil, err
+	}
+	return append(jobs, adopted...), nil
+}
+
+// adoptLegacyJobs adds labelTask and labelRunUID to the run's Jobs that
+// were created with only labelRun, and returns them. Those Jobs are named
+// <run>-<task>; the task is found by matching the run's own task names
+// rather than by trimming the prefix.
+func (r *ObservatoryRunReconciler) adoptLegacyJobs(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun) ([]batchv1.Job, error) {
+	if len(validation.IsValidLabelValue(run.Name)) > 0 {
+		// Such a run could never have had a labelled Job.
+		return nil, nil
+	}
+	var list batchv1.JobList
+	if err := r.List(ctx, &list, client.InNamespace(run.Namespace), client.MatchingLabels{labelRun: run.Name}); err != nil {
+		return nil, err
+	}
+	var adopted []batchv1.Job
+	for i := range list.Items {
+		j := &list.Items[i]
+		if _, ok := j.Labels[labelRunUID]; ok || !metav1.IsControlledBy(j, run) {
+			continue
+		}
+		task := jobTask(j)
+		if task == "" {
+			task = legacyJobTask(run, j.Name)
+		}
+		if task == "" {
+			log.FromContext(ctx).Info("Ignoring Job that matches no task", "job", j.Name)
+			continue
+		}
+		before := j.DeepCopy()
+		j.Labels[labelTask] = taskLabelValue(task)
+		j.Labels[labelRunUID] = string(run.UID)
+		if err := r.Patch(ctx, j, client.MergeFrom(before)); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return nil, err
+			}
+			continue
+		}
+		adopted = append(adopted, *j)
+	}
+	return adopted, nil
+}
+
+// legacyJobTask returns the task, fan-out children included, whose
+// <run>-<task> Job name is jobName, or "" if there is none.
+func legacyJobTask(run *observatoryv1alpha1.ObservatoryRun, jobName string) string {
+	for name := range run.Status.TaskStatuses {
+		if run.Name+"-"+name == jobName {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	obs "github.com/example/observatory-operator/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestAttemptJobName(t *testing.T) {
+	g := NewWithT(t)
+
+	run := func(name string) *obs.ObservatoryRun {
+		return &obs.ObservatoryRun{ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID("uid-" + name)}}
+	}
+	g.Expect(attemptJobName(run("r"), "a", 0)).To(MatchRegexp(`^r-a-[0-9a-f]{8}$`))
+	g.Expect(attemptJobName(run("r"), "a", 2)).To(MatchRegexp(`^r-a-retry-2-[0-9a-f]{8}$`))
+	g.Expect(attemptJobName(run("r"), "Build_All", 0)).To(MatchRegexp(`^r-build-all-[0-9a-f]{8}$`))
+
+	// "build" + "x-a" and "build-x" + "a" used to both be build-x-a.
+	g.Expect(attemptJobName(run("build"), "x-a", 0)).NotTo(Equal(attemptJobName(run("build-x"), "a", 0)))
+
+	long := run(strings.Repeat("r", 200) + ".example")
+	for _, name := range []string{
+		attemptJobName(long, strings.Repeat("t", 63), 0),
+		attemptJobName(long, strings.Repeat("t", 63)+".12", 99),
+		attemptJobName(run(strings.Repeat("r", 53)+"-x"), "a", 0),
+	} {
+		g.Expect(validation.IsDNS1123Label(name)).To(BeEmpty(), name)
+	}
+	g.Expect(attemptJobName(long, "a", 0)).NotTo(Equal(attemptJobName(long, "b", 0)))
+}
+
+func TestAdoptLegacyJobs(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "build", Namespace: "ns", UID: "build-uid"},
+		Spec: obs.ObservatoryRunSpec{Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{
+			"a": {}, "x-a": {},
+		}}},
+	}
+	other := &obs.ObservatoryRun{ObjectMeta: metav1.ObjectMeta{Name: "build-x", Namespace: "ns", UID: "build-x-uid"}}
+	legacy := func(owner *obs.ObservatoryRun, name string) *batchv1.Job {
+		return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: "ns",
+			// Before labelTask and labelRunUID, Jobs carried only the run name.
+			Labels:          map[string]string{labelRun: owner.Name},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(owner, obs.GroupVersion.WithKind("ObservatoryRun"))},
+		}}
+	}
+	running := legacy(run, "build-x-a")
+	running.Status.Active = 1
+	// Another run's Job, labelled with this run's name by mistake, and a Job
+	// left behind by an earlier run named "build".
+	foreign := legacy(other, "build-a")
+	foreign.Labels[labelRun] = "build"
+	stale := legacy(&obs.ObservatoryRun{ObjectMeta: metav1.ObjectMeta{Name: "build", UID: "old-uid"}}, "build-a")
+	stale.Name = "build-a-old"
+	r := newTestReconciler(t, running, foreign, stale)
+
+	g.Expect(r.observeTasks(ctx, run)).To(Succeed())
+	g.Expect(run.Status.TaskStatuses["x-a"].State).To(Equal(obs.TaskRunning))
+	g.Expect(run.Status.TaskStatuses["x-a"].JobName).To(Equal("build-x-a"))
+	g.Expect(run.Status.TaskStatuses["a"].State).To(Equal(obs.TaskPending))
+	g.Expect(run.Status.TaskStatuses["a"].JobName).To(BeEmpty())
+
+	var job batchv1.Job
+	g.Expect(r.Get(ctx, client.ObjectKeyFromObject(running), &job)).To(Succeed())
+	g.Expect(job.Labels).To(HaveKeyWithValue(labelTask, "x-a"))
+	g.Expect(job.Labels).To(HaveKeyWithValue(labelRunUID, "build-uid"))
+	for _, j := range []*batchv1.Job{foreign, stale} {
+		g.Expect(r.Get(ctx, client.ObjectKeyFromObject(j), &job)).To(Succeed())
+		g.Expect(job.Labels).NotTo(HaveKey(labelRunUID))
+	}
+
+	// Once labelled, the Job is found by its labels alone.
+	jobs, err := r.listRunJobs(ctx, run)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(jobs).To(HaveLen(1))
+	g.Expect(jobs[0].Name).To(Equal("build-x-a"))
+}
+
+func TestLongTaskNameLabels(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	// The longest task name the webhook allows; its fan-out children are
+	// longer than a label value can be.
+	task := strings.Repeat("t", 63)
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns", UID: "r-uid"},
+		Spec: obs.ObservatoryRunSpec{Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{
+			task: {WithItems: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"}},
+		}}},
+	}
+	r := newTestReconciler(t, run)
+	for _, child := range []string{childTaskName(task, 0), childTaskName(task, 10)} {
+		g.Expect(r.ensureJob(ctx, run, child)).To(Succeed())
+	}
+
+	jobs, err := r.listRunJobs(ctx, run)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(jobs).To(HaveLen(2))
+	seen := map[string]bool{}
+	for _, j := range jobs {
+		for _, v := range j.Labels {
+			g.Expect(validation.IsValidLabelValue(v)).To(BeEmpty(), v)
+		}
+		g.Expect(seen).NotTo(HaveKey(j.Labels[labelTask]))
+		seen[j.Labels[labelTask]] = true
+	}
+
+	g.Expect(r.observeTasks(ctx, run)).To(Succeed())
+	for _, child := range []string{childTaskName(task, 0), childTaskName(task, 10)} {
+		g.Expect(run.Status.TaskStatuses[child].JobName).To(Equal(attemptJobName(run, child, 0)))
+	}
+	// Names that fit are labelled as they are.
+	g.Expect(taskLabelValue("deploy.1")).To(Equal("deploy.1"))
+	g.Expect(jobAnnotations("deploy.1")).To(BeNil())
+}
+
+func TestTaskPodSelector(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns", UID: "r-uid"},
+		Spec:       obs.ObservatoryRunSpec{Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{"a": {}}}},
+	}
+	r := newTestReconciler(t, run)
+	g.Expect(r.ensureJob(ctx, run, "a")).To(Succeed())
+
+	var job batchv1.Job
+	g.Expect(r.Get(ctx, client.ObjectKey{Name: attemptJobName(run, "a", 0), Namespace: "ns"}, &job)).To(Succeed())
+	g.Expect(TaskPodSelector().Matches(labels.Set(job.Spec.Template.Labels))).To(BeTrue())
+	g.Expect(TaskPodSelector().Matches(labels.Set{"app": "web"})).To(BeFalse())
+}
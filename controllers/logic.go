@@ -1,86 +1,566 @@
 package controllers
 
 import (
+	"cmp"
+	"fmt"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	obs "github.com/example/observatory-operator/api/v1alpha1"
 )
 
-// computeFrontier returns task names whose dependencies have succeeded
-// and that are still pending.
+// computeFrontier returns task names whose dependency conditions are met,
+// whose `when` condition (if any) holds, and that are still pending, in
+// scheduling order. Fan-out tasks contribute their ready children instead.
+// With spec.workflow.parallelism set, only as many are returned as fit
+// beside the Jobs already in flight. A suspended or cancelled run has no
+// frontier. Tasks waiting out a retryStrategy backoff are left out.
 func computeFrontier(run *obs.ObservatoryRun) []string {
-	// ✨ If failurePolicy is "Stop", block launching anything after a failure
-	if run != nil && run.Spec.Workflow.FailurePolicy == "Stop" {
-		for _, st := range run.Status.TaskStatuses {
-			if st != nil && st.State == obs.TaskFailed {
-				return []string{}
+	if run == nil || run.Spec.Workflow.Tasks == nil || run.Spec.Suspend || run.Spec.Cancel {
+		return nil
+	}
+
+	tasks, stopped := currentStage(run)
+
+	var frontier []string
+	for name, spec := range tasks {
+		if spec.IsFanOut() {
+			frontier = append(frontier, readyChildren(run, name, spec, stopped)...)
+			continue
+		}
+		// Skip if task already started or completed
+		if st := run.Status.TaskStatuses[name]; !isPending(run, name) || taskStarted(st) || backingOff(st) || !depsSatisfied(run, spec) {
+			continue
+		}
+		if stopped && !isFailureHandler(spec) {
+			continue
+		}
+		if spec.When != "" {
+			if ok, err := evalWhen(run, spec); err != nil || !ok {
+				continue
 			}
 		}
+		frontier = append(frontier, name)
+	}
+	return limitParallelism(run, frontier)
+}
+
+// limitParallelism sorts ready task names by priority (highest first), then
+// by name with fan-out children in item order, so every reconcile and every
+// rerun schedules in the same order. It then trims the list to the room
+// left under spec.workflow.parallelism.
+func limitParallelism(run *obs.ObservatoryRun, ready []string) []string {
+	slices.SortFunc(ready, func(a, b string) int {
+		if c := cmp.Compare(priorityOf(run, b), priorityOf(run, a)); c != 0 {
+			return c
+		}
+		pa, ia, _ := parseChildTaskName(a)
+		pb, ib, _ := parseChildTaskName(b)
+		if pa == "" {
+			pa, ia = a, -1
+		}
+		if pb == "" {
+			pb, ib = b, -1
+		}
+		if c := strings.Compare(pa, pb); c != 0 {
+			return c
+		}
+		return cmp.Compare(ia, ib)
+	})
+
+	limit := run.Spec.Workflow.Parallelism
+	if limit == nil {
+		return ready
+	}
+	room := int(*limit) - jobsInFlight(run)
+	return ready[:max(0, min(room, len(ready)))]
+}
+
+// jobsInFlight counts the run's task Jobs that have not finished, including
+// ones between retries.
+func jobsInFlight(run *obs.ObservatoryRun) int {
+	n := 0
+	for _, st := range run.Status.TaskStatuses {
+		if st != nil && st.JobName != "" && !isTaskTerminal(st.State) {
+			n++
+		}
+	}
+	return n
+}
+
+// priorityOf returns the priority of a task, or of a fan-out child's parent.
+func priorityOf(run *obs.ObservatoryRun, name string) int32 {
+	if parent, _, ok := parseChildTaskName(name); ok {
+		name = parent
 	}
+	spec, _ := taskSpecFor(run, name)
+	return spec.Priority
+}
 
-	if run == nil || run.Spec.Workflow.Tasks == nil {
+// readyChildren returns the children of a fan-out task that may start now,
+// keeping at most spec.Parallelism of them in flight. Once a fan-out task
+// has started, failurePolicy Stop no longer holds back its remaining items.
+func readyChildren(run *obs.ObservatoryRun, name string, spec obs.TaskSpec, stopped bool) []string {
+	switch st := run.Status.TaskStatuses[name]; {
+	case st != nil && st.State == obs.TaskRunning:
+	case !isPending(run, name) || !depsSatisfied(run, spec) || stopped && !isFailureHandler(spec):
 		return nil
+	case spec.When != "":
+		if ok, err := evalWhen(run, spec); err != nil || !ok {
+			return nil
+		}
+	}
+	items, err := expandItems(run, spec)
+	if err != nil {
+		return nil
+	}
+	limit := len(items)
+	if spec.Parallelism != nil {
+		limit = int(*spec.Parallelism)
+	}
+	inFlight := 0
+	for i := range items {
+		if st := run.Status.TaskStatuses[childTaskName(name, i)]; taskStarted(st) && !isTaskTerminal(st.State) {
+			inFlight++
+		}
+	}
+	var ready []string
+	for i := 0; i < len(items) && inFlight < limit; i++ {
+		child := childTaskName(name, i)
+		if st := run.Status.TaskStatuses[child]; !taskStarted(st) && !backingOff(st) {
+			ready = append(ready, child)
+			inFlight++
+		}
 	}
+	return ready
+}
 
-	var frontier []string
-	for name, spec := range run.Spec.Workflow.Tasks {
-		status, exists := run.Status.TaskStatuses[name]
-		// Skip if task already started or completed
-		if exists && status.State != obs.TaskPending {
+// taskStarted reports whether a task or fan-out child has a Job, including
+// one that is between retries.
+func taskStarted(st *obs.TaskStatus) bool {
+	return st != nil && (st.JobName != "" || (st.State != "" && st.State != obs.TaskPending))
+}
+
+// childTaskName is the status key, and Job name suffix, of the index-th
+// child of a fan-out task. Task names cannot contain '.', so child names
+// never collide with task names.
+func childTaskName(parent string, index int) string {
+	return fmt.Sprintf("%s.%d", parent, index)
+}
+
+// parseChildTaskName reverses childTaskName.
+func parseChildTaskName(name string) (parent string, index int, ok bool) {
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return "", 0, false
+	}
+	index, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return name[:i], index, true
+}
+
+// applyFanOut rolls the children of each fan-out task up into the task's
+// own status, creating their entries once the task is ready. The task is
+// Running once any child has started, Succeeded when every child
+// succeeded, and Failed when all have finished and any failed. A withParam
+// that does not expand to a JSON list fails the task.
+func applyFanOut(run *obs.ObservatoryRun) {
+	if run == nil {
+		return
+	}
+	tasks, stopped := currentStage(run)
+	for _, name := range sortedKeys(tasks) {
+		spec := tasks[name]
+		if !spec.IsFanOut() {
+			continue
+		}
+		st := taskStatusFor(run, name)
+		if isTaskTerminal(st.State) {
+			continue
+		}
+		if st.State != obs.TaskRunning && (!depsSatisfied(run, spec) || stopped && !isFailureHandler(spec)) {
+			continue
+		}
+		items, err := expandItems(run, spec)
+		if err != nil {
+			st.State = obs.TaskFailed
+			st.Message = fmt.Sprintf("cannot expand items: %v", err)
 			continue
 		}
-		// Check dependencies
-		depsOK := true
-		for _, dep := range spec.Dependencies {
-			depStatus, ok := run.Status.TaskStatuses[dep]
-			if !ok || depStatus.State != obs.TaskSucceeded {
-				depsOK = false
-				break
+		succeeded, failed, started := 0, 0, 0
+		for i := range items {
+			cst := taskStatusFor(run, childTaskName(name, i))
+			switch {
+			case cst.State == obs.TaskSucceeded:
+				succeeded++
+			case cst.State == obs.TaskFailed:
+				failed++
+			case taskStarted(cst):
+				started++
+			case cst.State == "":
+				cst.State = obs.TaskPending
+			}
+		}
+		switch done := succeeded+failed == len(items); {
+		case done && failed > 0:
+			st.State = obs.TaskFailed
+		case done:
+			st.State = obs.TaskSucceeded
+		case started+succeeded+failed > 0:
+			st.State = obs.TaskRunning
+		}
+		st.Message = fmt.Sprintf("%d/%d items succeeded", succeeded, len(items))
+		if failed > 0 {
+			st.Message += fmt.Sprintf(", %d failed", failed)
+		}
+	}
+}
+
+// currentStage returns the tasks that may be scheduled now: the workflow
+// tasks until they have all finished, then the finally tasks. stopped is
+// set when failurePolicy Stop blocks everything but failure handlers
+// (tasks with onFailure/always dependencies); it never applies to finally.
+func currentStage(run *obs.ObservatoryRun) (tasks map[string]obs.TaskSpec, stopped bool) {
+	if isTerminal(workflowPhase(run)) {
+		return run.Spec.Workflow.Finally, false
+	}
+	return run.Spec.Workflow.Tasks, stoppedByFailure(run)
+}
+
+// inWorkflow reports whether name is a workflow task (not a finally task)
+// or a child of one.
+func inWorkflow(run *obs.ObservatoryRun, name string) bool {
+	if parent, _, ok := parseChildTaskName(name); ok {
+		name = parent
+	}
+	_, ok := run.Spec.Workflow.Tasks[name]
+	return ok
+}
+
+// taskSpecFor looks a task up among the workflow and finally tasks.
+func taskSpecFor(run *obs.ObservatoryRun, name string) (obs.TaskSpec, bool) {
+	if spec, ok := run.Spec.Workflow.Tasks[name]; ok {
+		return spec, true
+	}
+	spec, ok := run.Spec.Workflow.Finally[name]
+	return spec, ok
+}
+
+// stoppedByFailure reports whether failurePolicy Stop has been triggered.
+func stoppedByFailure(run *obs.ObservatoryRun) bool {
+	if !strings.EqualFold(run.Spec.Workflow.FailurePolicy, "Stop") {
+		return false
+	}
+	for name := range run.Spec.Workflow.Tasks {
+		if st := run.Status.TaskStatuses[name]; st != nil && st.State == obs.TaskFailed {
+			return true
+		}
+	}
+	return false
+}
+
+func isPending(run *obs.ObservatoryRun, name string) bool {
+	st := run.Status.TaskStatuses[name]
+	return st == nil || st.State == "" || st.State == obs.TaskPending
+}
+
+// conditionFor returns the dependency condition spec declares for dep.
+func conditionFor(spec obs.TaskSpec, dep string) obs.DependencyCondition {
+	if c, ok := spec.DependencyConditions[dep]; ok && c != "" {
+		return c
+	}
+	return obs.DependencyOnSuccess
+}
+
+// isFailureHandler reports whether spec waits on any dependency with a
+// condition other than onSuccess.
+func isFailureHandler(spec obs.TaskSpec) bool {
+	for _, dep := range spec.Dependencies {
+		if conditionFor(spec, dep) != obs.DependencyOnSuccess {
+			return true
+		}
+	}
+	return false
+}
+
+// depOutcome evaluates one dependency condition against the dependency's
+// status. settled is false while the dependency has not finished; met is
+// only meaningful once settled.
+func depOutcome(cond obs.DependencyCondition, st *obs.TaskStatus) (met, settled bool) {
+	if st == nil || !isTaskTerminal(st.State) {
+		return false, false
+	}
+	switch cond {
+	case obs.DependencyAlways:
+		return true, true
+	case obs.DependencyOnFailure:
+		return st.State == obs.TaskFailed, true
+	default:
+		return st.State == obs.TaskSucceeded ||
+			(st.State == obs.TaskSkipped && st.Reason != obs.ReasonDependencyNotMet), true
+	}
+}
+
+// depsSatisfied reports whether every dependency of spec has finished with
+// an outcome its condition accepts.
+func depsSatisfied(run *obs.ObservatoryRun, spec obs.TaskSpec) bool {
+	for _, dep := range spec.Dependencies {
+		if met, settled := depOutcome(conditionFor(spec, dep), run.Status.TaskStatuses[dep]); !settled || !met {
+			return false
+		}
+	}
+	return true
+}
+
+// applyDependencyConditions marks pending tasks that can never run, because a
+// dependency finished with an outcome their condition rejects, as Skipped
+// with ReasonDependencyNotMet. It repeats until no more tasks change so the
+// skip propagates down chains of onSuccess dependents, and reports whether
+// it changed any.
+func applyDependencyConditions(run *obs.ObservatoryRun) (settled bool) {
+	if run == nil {
+		return false
+	}
+	tasks, _ := currentStage(run)
+	for changed := true; changed; {
+		changed = false
+		for _, name := range sortedKeys(tasks) {
+			spec := tasks[name]
+			if !isPending(run, name) {
+				continue
+			}
+			for _, dep := range spec.Dependencies {
+				cond := conditionFor(spec, dep)
+				if met, settled := depOutcome(cond, run.Status.TaskStatuses[dep]); settled && !met {
+					st := taskStatusFor(run, name)
+					st.State = obs.TaskSkipped
+					st.Reason = obs.ReasonDependencyNotMet
+					st.Message = fmt.Sprintf("Skipped: dependency '%s' is %s (condition %s)", dep, run.Status.TaskStatuses[dep].State, cond)
+					changed, settled = true, true
+					break
+				}
 			}
 		}
-		if depsOK {
-			frontier = append(frontier, name)
+	}
+	return settled
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// applyWhen settles tasks whose dependencies are satisfied but whose
+// `when` condition is not true: false marks them Skipped, an evaluation
+// error marks them Failed. It runs before computeFrontier so those tasks
+// never reach the ready set and never block the run from finishing. It
+// reports whether it settled any task.
+func applyWhen(run *obs.ObservatoryRun) (settled bool) {
+	if run == nil {
+		return false
+	}
+	tasks, _ := currentStage(run)
+	for _, name := range sortedKeys(tasks) {
+		spec := tasks[name]
+		if spec.When == "" || !isPending(run, name) || !depsSatisfied(run, spec) {
+			continue
+		}
+		ok, err := evalWhen(run, spec)
+		switch {
+		case err != nil:
+			st := taskStatusFor(run, name)
+			st.State = obs.TaskFailed
+			st.Message = fmt.Sprintf("cannot evaluate when: %v", err)
+		case !ok:
+			st := taskStatusFor(run, name)
+			st.State = obs.TaskSkipped
+			st.Message = fmt.Sprintf("Skipped: when %q is false", spec.When)
 		}
+		settled = settled || err != nil || !ok
+	}
+	return settled
+}
+
+// settleTasks applies dependency conditions and `when` conditions until
+// neither settles another task, so a task skipped by its `when` condition
+// settles its dependents in the same pass.
+func settleTasks(run *obs.ObservatoryRun) {
+	for applyDependencyConditions(run) || applyWhen(run) {
 	}
-	slices.Sort(frontier)
-	return frontier
 }
 
 // derivePhase summarizes the overall workflow state from individual tasks.
+// Only declared tasks count; a task without a status entry is pending.
+// Once the workflow tasks finish, the run stays Running until its finally
+// tasks have finished too; a failed finally task fails the run.
 func derivePhase(run *obs.ObservatoryRun) obs.Phase {
+	phase := workflowPhase(run)
+	if isTerminal(phase) && len(run.Spec.Workflow.Finally) > 0 {
+		switch finally := stagePhase(run, run.Spec.Workflow.Finally, false); finally {
+		case obs.PhaseSucceeded:
+		case obs.PhaseFailed, obs.PhaseCancelled:
+			phase = finally
+		default:
+			phase = obs.PhaseRunning
+		}
+	}
+	// A suspended run that has not finished stays Suspended until resumed,
+	// even while tasks started before the suspension are still running.
+	if run != nil && run.Spec.Suspend && !isTerminal(phase) {
+		return obs.PhaseSuspended
+	}
+	return phase
+}
+
+// workflowPhase is the outcome of the workflow tasks alone, ignoring
+// finally. It is what finally tasks see as {{run.phase}}.
+func workflowPhase(run *obs.ObservatoryRun) obs.Phase {
 	if run == nil {
 		return obs.PhasePending
 	}
+	return stagePhase(run, run.Spec.Workflow.Tasks, stoppedByFailure(run))
+}
 
-	total := len(run.Spec.Workflow.Tasks)
+// stagePhase derives a phase from one set of tasks. When stopped, pending
+// tasks other than failure handlers will never run and do not hold the
+// phase open.
+func stagePhase(run *obs.ObservatoryRun, tasks map[string]obs.TaskSpec, stopped bool) obs.Phase {
+	total := len(tasks)
 	if total == 0 {
 		return obs.PhasePending
 	}
 
-	hasRunning := false
-	hasFailed := false
-	done := 0
-
-	for _, status := range run.Status.TaskStatuses {
-		switch status.State {
+	succeeded, skipped, failed, cancelled, running, waiting := 0, 0, 0, 0, 0, 0
+	for name, spec := range tasks {
+		status := run.Status.TaskStatuses[name]
+		state := obs.TaskPending
+		if status != nil && status.State != "" {
+			state = status.State
+		}
+		switch state {
 		case obs.TaskFailed:
-			hasFailed = true
-			done++
+			failed++
 		case obs.TaskSucceeded:
-			done++
-		case obs.TaskRunning:
-			hasRunning = true
+			succeeded++
+		case obs.TaskSkipped:
+			skipped++
+		case obs.TaskCancelled:
+			cancelled++
+		case obs.TaskRunning, obs.TaskAwaitingApproval:
+			running++
+		default:
+			if !stopped || isFailureHandler(spec) {
+				waiting++
+			}
 		}
 	}
 
 	switch {
-	case hasFailed:
+	case cancelled > 0 && running == 0 && waiting == 0:
+		return obs.PhaseCancelled
+	// A failure fails the run once nothing that could still run is left;
+	// until then onFailure/always handlers and independent tasks may proceed.
+	case failed > 0 && running == 0 && waiting == 0:
 		return obs.PhaseFailed
-	case done == total:
+	case failed > 0:
+		return obs.PhaseRunning
+	// Skipped tasks count as done: a run where every task succeeded or was
+	// skipped has succeeded.
+	case succeeded+skipped == total:
 		return obs.PhaseSucceeded
-	case hasRunning || done > 0:
+	// Consider run "Running" once any task starts; "Pending" only if none have.
+	case running > 0 || succeeded > 0 || skipped > 0:
 		return obs.PhaseRunning
 	default:
 		return obs.PhasePending
 	}
-}
\ No newline at end of file
+}
+
+// runDeadline is when the run passes spec.activeDeadline, counting from
+// its last retry if it had one.
+func runDeadline(run *obs.ObservatoryRun) (time.Time, bool) {
+	if run == nil || run.Spec.ActiveDeadline == nil || run.CreationTimestamp.IsZero() {
+		return time.Time{}, false
+	}
+	start := run.CreationTimestamp.Time
+	if run.Status.LastRetryTime != nil {
+		start = run.Status.LastRetryTime.Time
+	}
+	return start.Add(run.Spec.ActiveDeadline.Duration), true
+}
+
+// runDeadlineExceeded reports whether the run has been alive longer than
+// spec.activeDeadline.
+func runDeadlineExceeded(run *obs.ObservatoryRun, now time.Time) bool {
+	deadline, ok := runDeadline(run)
+	return ok && now.After(deadline)
+}
+
+// nextWakeup returns how long until something time-based is due for an
+// unfinished run: its deadline, the end of a retryStrategy backoff, or an
+// approval timeout. Zero means nothing is; watch events on the run, its
+// Jobs and their pods drive everything else.
+func nextWakeup(run *obs.ObservatoryRun, now time.Time) time.Duration {
+	var next time.Time
+	consider := func(t time.Time) {
+		if t.After(now) && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	if deadline, ok := runDeadline(run); ok {
+		// Just past it, since the deadline itself does not count as exceeded.
+		consider(deadline.Add(time.Millisecond))
+	}
+	for name, st := range run.Status.TaskStatuses {
+		if st.NextAttemptAt != nil && st.JobName == "" && st.State == obs.TaskPending {
+			consider(st.NextAttemptAt.Time)
+		}
+		if st.State == obs.TaskAwaitingApproval {
+			spec, _ := scheduledSpecFor(run, name)
+			if deadline, ok := approvalDeadline(spec, st); ok {
+				consider(deadline)
+			}
+		}
+	}
+	if next.IsZero() {
+		return 0
+	}
+	return next.Sub(now)
+}
+
+// tasksToRetry returns the tasks a retry resets, in name order: workflow
+// tasks (and fan-out children) that failed, were cancelled, or were
+// skipped because a dependency's outcome did not fit, plus every finally
+// task that has left Pending.
+func tasksToRetry(run *obs.ObservatoryRun) []string {
+	var out []string
+	for _, name := range sortedKeys(run.Status.TaskStatuses) {
+		st := run.Status.TaskStatuses[name]
+		if inWorkflow(run, name) {
+			switch {
+			case st.State == obs.TaskFailed, st.State == obs.TaskCancelled:
+			case st.State == obs.TaskSkipped && st.Reason == obs.ReasonDependencyNotMet:
+			default:
+				continue
+			}
+		} else {
+			parent := name
+			if p, _, ok := parseChildTaskName(name); ok {
+				parent = p
+			}
+			if _, ok := run.Spec.Workflow.Finally[parent]; !ok || isPending(run, name) {
+				continue
+			}
+		}
+		out = append(out, name)
+	}
+	return out
+}
@@ -2,9 +2,11 @@ package controllers
 
 import (
 	"testing"
+	"time"
 
 	obs "github.com/example/observatory-operator/api/v1alpha1"
 	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestComputeFrontier(t *testing.T) {
@@ -123,3 +125,366 @@ func TestMessagePopulation(t *testing.T) {
 
 	g.Expect(run.Status.TaskStatuses["task-a"].Message).To(Equal("Completed successfully"))
 }
+
+func TestResourceRequirementsTaskOverride(t *testing.T) {
+	g := NewWithT(t)
+
+	base := &obs.ResourcesSpec{
+		Requests: map[string]string{"cpu": "100m", "memory": "128Mi"},
+		Limits:   map[string]string{"memory": "256Mi"},
+	}
+	override := &obs.ResourcesSpec{Requests: map[string]string{"memory": "200Mi"}}
+
+	rr, err := resourceRequirementsFor(base, override)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(rr.Requests.Cpu().String()).To(Equal("100m"))
+	g.Expect(rr.Requests.Memory().String()).To(Equal("200Mi"))
+	g.Expect(rr.Limits.Memory().String()).To(Equal("256Mi"))
+
+	_, err = resourceRequirementsFor(&obs.ResourcesSpec{Limits: map[string]string{"cpu": "x"}}, nil)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestWhenSkipsAndSatisfiesDependents(t *testing.T) {
+	g := NewWithT(t)
+
+	run := &obs.ObservatoryRun{
+		Spec: obs.ObservatoryRunSpec{
+			Workflow: obs.Workflow{
+				Tasks: map[string]obs.TaskSpec{
+					"build":  {Outputs: []obs.OutputSpec{{Name: "changed"}}},
+					"deploy": {Dependencies: []string{"build"}, When: "{{inputs.parameters.env}} == prod && {{tasks.build.outputs.changed}}"},
+					"notify": {Dependencies: []string{"deploy"}},
+				},
+			},
+		},
+		Status: obs.ObservatoryRunStatus{
+			Parameters: map[string]string{"env": "dev"},
+			TaskStatuses: map[string]*obs.TaskStatus{
+				"build": {State: obs.TaskSucceeded, Outputs: map[string]string{"changed": "true"}},
+			},
+		},
+	}
+
+	// computeFrontier alone never admits a task whose condition is false.
+	g.Expect(computeFrontier(run)).To(BeEmpty())
+
+	applyWhen(run)
+	g.Expect(run.Status.TaskStatuses["deploy"].State).To(Equal(obs.TaskSkipped))
+	g.Expect(computeFrontier(run)).To(Equal([]string{"notify"}))
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseRunning))
+
+	run.Status.TaskStatuses["notify"] = &obs.TaskStatus{State: obs.TaskSucceeded}
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseSucceeded))
+
+	run.Status.Parameters["env"] = "prod"
+	delete(run.Status.TaskStatuses, "deploy")
+	delete(run.Status.TaskStatuses, "notify")
+	applyWhen(run)
+	g.Expect(run.Status.TaskStatuses).NotTo(HaveKey("deploy"))
+	g.Expect(computeFrontier(run)).To(Equal([]string{"deploy"}))
+}
+
+func TestSettleTasksWhenChain(t *testing.T) {
+	g := NewWithT(t)
+
+	onFailure := map[string]obs.DependencyCondition{"b": obs.DependencyOnFailure}
+	run := &obs.ObservatoryRun{
+		Spec: obs.ObservatoryRunSpec{
+			Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{
+				"a":       {When: "false"},
+				"b":       {When: "false", Dependencies: []string{"a"}},
+				"cleanup": {Dependencies: []string{"b"}, DependencyConditions: onFailure},
+			}},
+		},
+		Status: obs.ObservatoryRunStatus{TaskStatuses: map[string]*obs.TaskStatus{}},
+	}
+
+	// Both skips reach cleanup without another reconcile.
+	settleTasks(run)
+	g.Expect(run.Status.TaskStatuses["a"].State).To(Equal(obs.TaskSkipped))
+	g.Expect(run.Status.TaskStatuses["b"].State).To(Equal(obs.TaskSkipped))
+	g.Expect(run.Status.TaskStatuses["cleanup"].State).To(Equal(obs.TaskSkipped))
+	g.Expect(run.Status.TaskStatuses["cleanup"].Reason).To(Equal(obs.ReasonDependencyNotMet))
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseSucceeded))
+}
+
+func TestDependencyConditions(t *testing.T) {
+	g := NewWithT(t)
+
+	run := &obs.ObservatoryRun{
+		Spec: obs.ObservatoryRunSpec{
+			Workflow: obs.Workflow{
+				FailurePolicy: "Stop",
+				Tasks: map[string]obs.TaskSpec{
+					"build":   {},
+					"publish": {Dependencies: []string{"build"}},
+					"notify":  {Dependencies: []string{"publish"}},
+					"cleanup": {Dependencies: []string{"build"}, DependencyConditions: map[string]obs.DependencyCondition{"build": obs.DependencyOnFailure}},
+					"report":  {Dependencies: []string{"build"}, DependencyConditions: map[string]obs.DependencyCondition{"build": obs.DependencyAlways}},
+				},
+			},
+		},
+		Status: obs.ObservatoryRunStatus{
+			TaskStatuses: map[string]*obs.TaskStatus{
+				"build": {State: obs.TaskFailed},
+			},
+		},
+	}
+
+	// Failure handlers run despite failurePolicy Stop; onSuccess dependents
+	// are skipped transitively.
+	applyDependencyConditions(run)
+	g.Expect(run.Status.TaskStatuses["publish"].State).To(Equal(obs.TaskSkipped))
+	g.Expect(run.Status.TaskStatuses["publish"].Reason).To(Equal(obs.ReasonDependencyNotMet))
+	g.Expect(run.Status.TaskStatuses["notify"].Reason).To(Equal(obs.ReasonDependencyNotMet))
+	g.Expect(computeFrontier(run)).To(Equal([]string{"cleanup", "report"}))
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseRunning))
+
+	run.Status.TaskStatuses["cleanup"] = &obs.TaskStatus{State: obs.TaskSucceeded}
+	run.Status.TaskStatuses["report"] = &obs.TaskStatus{State: obs.TaskRunning}
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseRunning))
+	run.Status.TaskStatuses["report"].State = obs.TaskSucceeded
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseFailed))
+
+	// On success the onFailure handler is skipped and the run still succeeds.
+	run.Status.TaskStatuses = map[string]*obs.TaskStatus{
+		"build": {State: obs.TaskSucceeded},
+	}
+	applyDependencyConditions(run)
+	g.Expect(run.Status.TaskStatuses["cleanup"].State).To(Equal(obs.TaskSkipped))
+	g.Expect(computeFrontier(run)).To(Equal([]string{"publish", "report"}))
+	for _, name := range []string{"publish", "notify", "report"} {
+		run.Status.TaskStatuses[name] = &obs.TaskStatus{State: obs.TaskSucceeded}
+	}
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseSucceeded))
+}
+
+func TestFinallyRunsAfterWorkflow(t *testing.T) {
+	g := NewWithT(t)
+
+	run := &obs.ObservatoryRun{
+		Spec: obs.ObservatoryRunSpec{
+			Workflow: obs.Workflow{
+				FailurePolicy: "Stop",
+				Tasks: map[string]obs.TaskSpec{
+					"a": {},
+					"b": {Dependencies: []string{"a"}},
+					"c": {},
+				},
+				Finally: map[string]obs.TaskSpec{
+					"unlock": {},
+					"report": {Dependencies: []string{"unlock"}, Command: "echo {{run.phase}}"},
+				},
+			},
+		},
+		Status: obs.ObservatoryRunStatus{
+			TaskStatuses: map[string]*obs.TaskStatus{
+				"a": {State: obs.TaskFailed},
+				"c": {State: obs.TaskRunning},
+			},
+		},
+	}
+
+	// Finally waits for every workflow task, including ones still running.
+	g.Expect(computeFrontier(run)).To(BeEmpty())
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseRunning))
+
+	// failurePolicy Stop leaves b pending forever; finally starts anyway.
+	run.Status.TaskStatuses["c"].State = obs.TaskSucceeded
+	g.Expect(computeFrontier(run)).To(Equal([]string{"unlock"}))
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseRunning))
+
+	run.Status.TaskStatuses["unlock"] = &obs.TaskStatus{State: obs.TaskSucceeded}
+	g.Expect(computeFrontier(run)).To(Equal([]string{"report"}))
+	spec, err := resolveTask(run, run.Spec.Workflow.Finally["report"])
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(spec.Command).To(Equal("echo Failed"))
+
+	run.Status.TaskStatuses["report"] = &obs.TaskStatus{State: obs.TaskSucceeded}
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseFailed))
+
+	// A failed finally task fails an otherwise successful run.
+	run.Spec.Workflow.FailurePolicy = ""
+	run.Status.TaskStatuses = map[string]*obs.TaskStatus{
+		"a":      {State: obs.TaskSucceeded},
+		"b":      {State: obs.TaskSucceeded},
+		"c":      {State: obs.TaskSucceeded},
+		"unlock": {State: obs.TaskSucceeded},
+		"report": {State: obs.TaskFailed},
+	}
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseFailed))
+}
+
+func TestFanOutChildren(t *testing.T) {
+	g := NewWithT(t)
+
+	parallelism := int32(2)
+	run := &obs.ObservatoryRun{
+		Spec: obs.ObservatoryRunSpec{
+			Workflow: obs.Workflow{
+				Tasks: map[string]obs.TaskSpec{
+					"list":  {Outputs: []obs.OutputSpec{{Name: "shards"}}},
+					"shard": {Dependencies: []string{"list"}, WithParam: "{{tasks.list.outputs.shards}}", Parallelism: &parallelism},
+					"merge": {Dependencies: []string{"shard"}},
+				},
+			},
+		},
+		Status: obs.ObservatoryRunStatus{
+			TaskStatuses: map[string]*obs.TaskStatus{
+				"list": {State: obs.TaskSucceeded, Outputs: map[string]string{"shards": `["a","b",{"n":3}]`}},
+			},
+		},
+	}
+
+	applyFanOut(run)
+	g.Expect(run.Status.TaskStatuses).To(HaveKey("shard.2"))
+	g.Expect(computeFrontier(run)).To(Equal([]string{"shard.0", "shard.1"}))
+
+	// Two children in flight fill the cap; one finishing frees a slot.
+	run.Status.TaskStatuses["shard.0"] = &obs.TaskStatus{State: obs.TaskRunning, JobName: "r-shard.0"}
+	run.Status.TaskStatuses["shard.1"] = &obs.TaskStatus{State: obs.TaskPending, JobName: "r-shard.1"}
+	applyFanOut(run)
+	g.Expect(run.Status.TaskStatuses["shard"].State).To(Equal(obs.TaskRunning))
+	g.Expect(computeFrontier(run)).To(BeEmpty())
+	run.Status.TaskStatuses["shard.0"].State = obs.TaskSucceeded
+	g.Expect(computeFrontier(run)).To(Equal([]string{"shard.2"}))
+
+	spec, err := resolveScheduled(run, "shard.2")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(spec.WithParam).To(Equal("{{tasks.list.outputs.shards}}"))
+	spec.Command = "process {{item}}"
+	run.Spec.Workflow.Tasks["shard"] = spec
+	spec, err = resolveScheduled(run, "shard.2")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(spec.Command).To(Equal(`process {"n":3}`))
+
+	// The parent succeeds only when every child has.
+	run.Status.TaskStatuses["shard.1"] = &obs.TaskStatus{State: obs.TaskSucceeded}
+	run.Status.TaskStatuses["shard.2"] = &obs.TaskStatus{State: obs.TaskFailed}
+	applyFanOut(run)
+	g.Expect(run.Status.TaskStatuses["shard"].State).To(Equal(obs.TaskFailed))
+	g.Expect(run.Status.TaskStatuses["shard"].Message).To(Equal("2/3 items succeeded, 1 failed"))
+
+	run.Status.TaskStatuses["shard"].State = obs.TaskRunning
+	run.Status.TaskStatuses["shard.2"].State = obs.TaskSucceeded
+	applyFanOut(run)
+	g.Expect(run.Status.TaskStatuses["shard"].State).To(Equal(obs.TaskSucceeded))
+	g.Expect(computeFrontier(run)).To(Equal([]string{"merge"}))
+
+	// A withParam that is not a JSON list fails the parent.
+	run.Status.TaskStatuses = map[string]*obs.TaskStatus{
+		"list": {State: obs.TaskSucceeded, Outputs: map[string]string{"shards": "a,b"}},
+	}
+	applyFanOut(run)
+	g.Expect(run.Status.TaskStatuses["shard"].State).To(Equal(obs.TaskFailed))
+	g.Expect(run.Status.TaskStatuses["shard"].Message).To(HavePrefix("cannot expand items: withParam is not a JSON list"))
+}
+
+func TestWorkflowParallelism(t *testing.T) {
+	g := NewWithT(t)
+
+	limit := int32(3)
+	run := &obs.ObservatoryRun{
+		Spec: obs.ObservatoryRunSpec{
+			Workflow: obs.Workflow{
+				Parallelism: &limit,
+				Tasks: map[string]obs.TaskSpec{
+					"a":      {},
+					"b":      {},
+					"c":      {Priority: 10},
+					"d":      {},
+					"shards": {WithItems: []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}, Priority: 5},
+				},
+			},
+		},
+	}
+
+	// Unlimited: everything ready, highest priority first, children in item order.
+	run.Spec.Workflow.Parallelism = nil
+	g.Expect(computeFrontier(run)).To(Equal([]string{
+		"c",
+		"shards.0", "shards.1", "shards.2", "shards.3", "shards.4", "shards.5",
+		"shards.6", "shards.7", "shards.8", "shards.9", "shards.10",
+		"a", "b", "d",
+	}))
+
+	run.Spec.Workflow.Parallelism = &limit
+	g.Expect(computeFrontier(run)).To(Equal([]string{"c", "shards.0", "shards.1"}))
+
+	// Jobs in flight count against the limit, whether running or retrying.
+	run.Status.TaskStatuses = map[string]*obs.TaskStatus{
+		"c":        {State: obs.TaskRunning, JobName: "r-c"},
+		"shards.0": {State: obs.TaskPending, JobName: "r-shards.0", Message: "Failed 1/3 times, retrying"},
+		"shards.1": {State: obs.TaskSucceeded, JobName: "r-shards.1"},
+	}
+	g.Expect(computeFrontier(run)).To(Equal([]string{"shards.2"}))
+
+	run.Status.TaskStatuses["c"].State = obs.TaskSucceeded
+	run.Status.TaskStatuses["shards.0"].State = obs.TaskFailed
+	g.Expect(computeFrontier(run)).To(Equal([]string{"shards.2", "shards.3", "shards.4"}))
+
+	// At or over the limit nothing new starts.
+	for _, name := range []string{"a", "b", "d", "shards.2"} {
+		run.Status.TaskStatuses[name] = &obs.TaskStatus{State: obs.TaskRunning, JobName: "r-" + name}
+	}
+	g.Expect(computeFrontier(run)).To(BeEmpty())
+}
+
+func TestSuspendAndResume(t *testing.T) {
+	g := NewWithT(t)
+
+	run := &obs.ObservatoryRun{
+		Spec: obs.ObservatoryRunSpec{
+			Suspend: true,
+			Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{
+				"a": {}, "b": {}, "c": {Dependencies: []string{"a"}},
+			}},
+		},
+		Status: obs.ObservatoryRunStatus{TaskStatuses: map[string]*obs.TaskStatus{
+			"a": {State: obs.TaskRunning, JobName: "r-a"},
+		}},
+	}
+
+	// Nothing new starts, but the running task is left alone.
+	g.Expect(computeFrontier(run)).To(BeEmpty())
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseSuspended))
+
+	run.Status.TaskStatuses["a"].State = obs.TaskSucceeded
+	g.Expect(computeFrontier(run)).To(BeEmpty())
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseSuspended))
+
+	run.Spec.Suspend = false
+	g.Expect(computeFrontier(run)).To(Equal([]string{"b", "c"}))
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseRunning))
+
+	// A run that finished while suspended reports its outcome.
+	run.Spec.Suspend = true
+	run.Status.TaskStatuses["b"] = &obs.TaskStatus{State: obs.TaskSucceeded}
+	run.Status.TaskStatuses["c"] = &obs.TaskStatus{State: obs.TaskSucceeded}
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseSucceeded))
+}
+
+func TestNextWakeup(t *testing.T) {
+	g := NewWithT(t)
+
+	t0 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(t0)},
+		Status: obs.ObservatoryRunStatus{TaskStatuses: map[string]*obs.TaskStatus{
+			"a": {State: obs.TaskRunning, JobName: "r-a"},
+		}},
+	}
+	// Nothing is timed; Job events drive the run.
+	g.Expect(nextWakeup(run, t0.Add(time.Minute))).To(BeZero())
+
+	run.Spec.ActiveDeadline = &metav1.Duration{Duration: time.Hour}
+	g.Expect(nextWakeup(run, t0.Add(time.Minute))).To(Equal(59*time.Minute + time.Millisecond))
+
+	// The earlier of the deadline and a backoff wins.
+	run.Status.TaskStatuses["b"] = &obs.TaskStatus{State: obs.TaskPending, NextAttemptAt: &metav1.Time{Time: t0.Add(5 * time.Minute)}}
+	g.Expect(nextWakeup(run, t0.Add(time.Minute))).To(Equal(4 * time.Minute))
+
+	// Past the deadline and the backoff, nothing is left to wait for.
+	g.Expect(nextWakeup(run, t0.Add(2*time.Hour))).To(BeZero())
+}
@@ -0,0 +1,311 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	"github.com/example/observatory-operator/internal/cron"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	labelCronRun = "obs.seventh/cronrun"
+	// annotationScheduledAt records the schedule slot a run was started for.
+	annotationScheduledAt = "observatory.seventh-horizon.io/scheduled-at"
+
+	defaultSuccessfulRunsHistoryLimit = 3
+	defaultFailedRunsHistoryLimit     = 1
+
+	// maxMissedSlots bounds how many missed slots dueTime walks through, as
+	// CronJob does. Past it, the latest slot is looked up from now instead.
+	maxMissedSlots = 100
+	// eventTooManyMissedRuns is recorded on an ObservatoryCronRun that
+	// missed more than maxMissedSlots slots.
+	eventTooManyMissedRuns = "TooManyMissedRuns"
+)
+
+// Clock is the time source ObservatoryCronRunReconciler schedules against.
+type Clock interface {
+	Now() time.Time
+}
+
+type ObservatoryCronRunReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Clock defaults to the wall clock when nil.
+	Clock Clock
+	// Recorder records events on the cron run. Nil disables them.
+	Recorder record.EventRecorder
+}
+
+func (r *ObservatoryCronRunReconciler) now() time.Time {
+	if r.Clock == nil {
+		return time.Now()
+	}
+	return r.Clock.Now()
+}
+
+func (r *ObservatoryCronRunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	var cr observatoryv1alpha1.ObservatoryCronRun
+	if err := r.Get(ctx, req.NamespacedName, &cr); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	orig := cr.DeepCopy()
+
+	var runs observatoryv1alpha1.ObservatoryRunList
+	if err := r.List(ctx, &runs, client.InNamespace(cr.Namespace), client.MatchingLabels{labelCronRun: cr.Name}); err != nil {
+		return ctrl.Result{}, err
+	}
+	var active, succeeded, failed []*observatoryv1alpha1.ObservatoryRun
+	for i := range runs.Items {
+		run := &runs.Items[i]
+		if !metav1.IsControlledBy(run, &cr) || !run.DeletionTimestamp.IsZero() {
+			continue
+		}
+		switch run.Status.Phase {
+		case observatoryv1alpha1.PhaseSucceeded:
+			succeeded = append(succeeded, run)
+		case observatoryv1alpha1.PhaseFailed, observatoryv1alpha1.PhaseCancelled:
+			failed = append(failed, run)
+		default:
+			active = append(active, run)
+		}
+		// Recover the last schedule time if an earlier status patch was lost.
+		if t, ok := scheduledTime(run); ok && (cr.Status.LastScheduleTime == nil || t.After(cr.Status.LastScheduleTime.Time)) {
+			cr.Status.LastScheduleTime = &metav1.Time{Time: t}
+		}
+	}
+	cr.Status.Active = activeRefs(active)
+
+	if err := r.pruneHistory(ctx, succeeded, historyLimit(cr.Spec.SuccessfulRunsHistoryLimit, defaultSuccessfulRunsHistoryLimit)); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.pruneHistory(ctx, failed, historyLimit(cr.Spec.FailedRunsHistoryLimit, defaultFailedRunsHistoryLimit)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	sched, loc, err := parseSchedule(&cr.Spec)
+	if err != nil {
+		// Nothing to retry until the spec changes, which triggers a new reconcile.
+		cr.Status.Message = err.Error()
+		return ctrl.Result{}, r.Status().Patch(ctx, &cr, client.MergeFrom(orig))
+	}
+	cr.Status.Message = ""
+
+	now := r.now().In(loc)
+	due, next, missed := dueTime(&cr, sched, now)
+	if missed > maxMissedSlots && r.Recorder != nil {
+		r.Recorder.Eventf(&cr, corev1.EventTypeWarning, eventTooManyMissedRuns,
+			"Missed more than %d scheduled runs; acting on the latest, at %s. Set spec.startingDeadlineSeconds to skip old ones.",
+			maxMissedSlots, due.Format(time.RFC3339))
+	}
+	if !due.IsZero() {
+		start := true
+		switch cr.Spec.ConcurrencyPolicy {
+		case observatoryv1alpha1.ForbidConcurrent:
+			if len(active) > 0 {
+				// Leave the slot due: the active run finishing triggers another
+				// reconcile, and dueTime drops the slot once it is older than
+				// the starting deadline.
+				logger.Info("holding scheduled run; previous run still active", "scheduledAt", due)
+				start = false
+			}
+		case observatoryv1alpha1.ReplaceConcurrent:
+			for _, run := range active {
+				if err := r.Delete(ctx, run, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+					return ctrl.Result{}, err
+				}
+			}
+			active = nil
+		}
+		if start {
+			run, err := r.startRun(ctx, &cr, due)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			active = append(active, run)
+			cr.Status.LastScheduleTime = &metav1.Time{Time: due}
+		}
+		cr.Status.Active = activeRefs(active)
+	}
+
+	if err := r.Status().Patch(ctx, &cr, client.MergeFrom(orig)); err != nil {
+		return ctrl.Result{}, err
+	}
+	if next.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+}
+
+// parseSchedule parses the schedule and loads its time zone.
+func parseSchedule(spec *observatoryv1alpha1.ObservatoryCronRunSpec) (*cron.Schedule, *time.Location, error) {
+	sched, err := cron.Parse(spec.Schedule)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid schedule %q: %w", spec.Schedule, err)
+	}
+	loc := time.UTC
+	if spec.TimeZone != nil {
+		if loc, err = time.LoadLocation(*spec.TimeZone); err != nil {
+			return nil, nil, fmt.Errorf("invalid time zone %q: %w", *spec.TimeZone, err)
+		}
+	}
+	return sched, loc, nil
+}
+
+// dueTime returns the latest schedule slot at or before now that has not
+// been acted on yet (zero if none), the first slot after now, and how many
+// slots were missed, counting up to maxMissedSlots+1. Slots older than the
+// starting deadline are never due.
+func dueTime(cr *observatoryv1alpha1.ObservatoryCronRun, sched *cron.Schedule, now time.Time) (due, next time.Time, missed int) {
+	earliest := cr.CreationTimestamp.Time
+	if cr.Status.LastScheduleTime != nil {
+		earliest = cr.Status.LastScheduleTime.Time
+	}
+	if d := cr.Spec.StartingDeadlineSeconds; d != nil {
+		if cutoff := now.Add(-time.Duration(*d) * time.Second); cutoff.After(earliest) {
+			// Next is exclusive; keep a slot exactly at the cutoff.
+			earliest = cutoff.Add(-time.Nanosecond)
+		}
+	}
+	earliest = earliest.In(now.Location())
+	for t := sched.Next(earliest); !t.IsZero(); t = sched.Next(t) {
+		if t.After(now) {
+			return due, t, missed
+		}
+		if missed++; missed > maxMissedSlots {
+			// After downtime or with a stale status a frequent schedule has
+			// missed too many slots to walk; find the latest from now.
+			due, next = latestSlot(sched, earliest, now)
+			return due, next, missed
+		}
+		due = t
+	}
+	return due, time.Time{}, missed
+}
+
+// latestSlot returns the last slot after from and at or before now, and the
+// first slot after now. It searches back from now in widening windows, so
+// it does not depend on how long ago from was.
+func latestSlot(sched *cron.Schedule, from, now time.Time) (due, next time.Time) {
+	for window := time.Minute; ; window *= 2 {
+		start := now.Add(-window)
+		if !start.After(from) {
+			start = from
+		}
+		t := sched.Next(start)
+		if t.IsZero() || t.After(now) {
+			if start.Equal(from) {
+				return time.Time{}, t
+			}
+			continue
+		}
+		for ; !t.IsZero() && !t.After(now); t = sched.Next(t) {
+			due = t
+		}
+		return due, t
+	}
+}
+
+func (r *ObservatoryCronRunReconciler) startRun(ctx context.Context, cr *observatoryv1alpha1.ObservatoryCronRun, at time.Time) (*observatoryv1alpha1.ObservatoryRun, error) {
+	tmpl := cr.Spec.RunTemplate.DeepCopy()
+	run := &observatoryv1alpha1.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{
+			// Deterministic per slot, so a retried reconcile cannot start the
+			// same slot twice.
+			Name:        fmt.Sprintf("%s-%d", cr.Name, at.Unix()/60),
+			Namespace:   cr.Namespace,
+			Labels:      tmpl.Labels,
+			Annotations: tmpl.Annotations,
+		},
+		Spec: tmpl.Spec,
+	}
+	if run.Labels == nil {
+		run.Labels = map[string]string{}
+	}
+	run.Labels[labelCronRun] = cr.Name
+	if run.Annotations == nil {
+		run.Annotations = map[string]string{}
+	}
+	run.Annotations[annotationScheduledAt] = at.UTC().Format(time.RFC3339)
+	if err := controllerutil.SetControllerReference(cr, run, r.Scheme); err != nil {
+		return nil, err
+	}
+	if err := r.Create(ctx, run); err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	log.FromContext(ctx).Info("started scheduled run", "run", run.Name, "scheduledAt", at)
+	return run, nil
+}
+
+// pruneHistory deletes all but the limit most recently scheduled runs.
+func (r *ObservatoryCronRunReconciler) pruneHistory(ctx context.Context, runs []*observatoryv1alpha1.ObservatoryRun, limit int) error {
+	if len(runs) <= limit {
+		return nil
+	}
+	sort.Slice(runs, func(i, j int) bool { return runStartedAt(runs[i]).After(runStartedAt(runs[j])) })
+	for _, run := range runs[limit:] {
+		if err := r.Delete(ctx, run, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func historyLimit(limit *int32, def int) int {
+	if limit == nil {
+		return def
+	}
+	return max(int(*limit), 0)
+}
+
+func scheduledTime(run *observatoryv1alpha1.ObservatoryRun) (time.Time, bool) {
+	v, ok := run.Annotations[annotationScheduledAt]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, err == nil
+}
+
+func runStartedAt(run *observatoryv1alpha1.ObservatoryRun) time.Time {
+	if t, ok := scheduledTime(run); ok {
+		return t
+	}
+	return run.CreationTimestamp.Time
+}
+
+func activeRefs(runs []*observatoryv1alpha1.ObservatoryRun) []corev1.ObjectReference {
+	var refs []corev1.ObjectReference
+	for _, run := range runs {
+		refs = append(refs, corev1.ObjectReference{
+			APIVersion: observatoryv1alpha1.GroupVersion.String(),
+			Kind:       "ObservatoryRun",
+			Namespace:  run.Namespace,
+			Name:       run.Name,
+			UID:        run.UID,
+		})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs
+}
+
+func (r *ObservatoryCronRunReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&observatoryv1alpha1.ObservatoryCronRun{}).
+		Owns(&observatoryv1alpha1.ObservatoryRun{}).
+		Complete(r)
+}
@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	obs "github.com/example/observatory-operator/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newTestCronReconciler(t *testing.T, clock Clock, objs ...client.Object) *ObservatoryCronRunReconciler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := obs.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return &ObservatoryCronRunReconciler{
+		Client: fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(objs...).
+			WithStatusSubresource(&obs.ObservatoryCronRun{}, &obs.ObservatoryRun{}).
+			Build(),
+		Scheme: scheme,
+		Clock:  clock,
+	}
+}
+
+var cronEpoch = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func testCronRun(schedule string) *obs.ObservatoryCronRun {
+	return &obs.ObservatoryCronRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "nightly", Namespace: "ns", UID: "cron-uid",
+			CreationTimestamp: metav1.NewTime(cronEpoch),
+		},
+		Spec: obs.ObservatoryCronRunSpec{
+			Schedule: schedule,
+			RunTemplate: obs.ObservatoryRunTemplate{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"team": "data"}},
+				Spec: obs.ObservatoryRunSpec{
+					Project:  "etl",
+					Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{"a": {Image: "busybox"}}},
+				},
+			},
+		},
+	}
+}
+
+// cronChild is a run the cron run started for the slot at.
+func cronChild(t *testing.T, cr *obs.ObservatoryCronRun, at time.Time, phase obs.Phase) *obs.ObservatoryRun {
+	t.Helper()
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: cr.Name + "-" + at.Format("1504"), Namespace: cr.Namespace,
+			Labels:      map[string]string{labelCronRun: cr.Name},
+			Annotations: map[string]string{annotationScheduledAt: at.Format(time.RFC3339)},
+		},
+		Status: obs.ObservatoryRunStatus{Phase: phase},
+	}
+	scheme := runtime.NewScheme()
+	if err := obs.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := controllerutil.SetControllerReference(cr, run, scheme); err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
+
+func reconcileCron(g Gomega, r *ObservatoryCronRunReconciler, cr *obs.ObservatoryCronRun) (ctrl.Result, *obs.ObservatoryCronRun, []obs.ObservatoryRun) {
+	ctx := context.Background()
+	res, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cr)})
+	g.Expect(err).NotTo(HaveOccurred())
+	var got obs.ObservatoryCronRun
+	g.Expect(r.Get(ctx, client.ObjectKeyFromObject(cr), &got)).To(Succeed())
+	var runs obs.ObservatoryRunList
+	g.Expect(r.List(ctx, &runs, client.InNamespace(cr.Namespace))).To(Succeed())
+	return res, &got, runs.Items
+}
+
+func TestCronRunStartsDueRun(t *testing.T) {
+	g := NewWithT(t)
+
+	cr := testCronRun("*/5 * * * *")
+	clock := &fakeClock{now: cronEpoch.Add(7 * time.Minute)}
+	r := newTestCronReconciler(t, clock, cr)
+
+	res, got, runs := reconcileCron(g, r, cr)
+	// Only the latest missed slot (10:05) runs, not 10:00 too.
+	g.Expect(runs).To(HaveLen(1))
+	run := runs[0]
+	g.Expect(run.Labels).To(HaveKeyWithValue("team", "data"))
+	g.Expect(run.Labels).To(HaveKeyWithValue(labelCronRun, "nightly"))
+	g.Expect(run.Annotations).To(HaveKeyWithValue(annotationScheduledAt, "2024-03-01T10:05:00Z"))
+	g.Expect(metav1.IsControlledBy(&run, cr)).To(BeTrue())
+	g.Expect(run.Spec.Project).To(Equal("etl"))
+	g.Expect(got.Status.LastScheduleTime.Time).To(BeTemporally("==", cronEpoch.Add(5*time.Minute)))
+	g.Expect(got.Status.Active).To(HaveLen(1))
+	g.Expect(got.Status.Active[0].Name).To(Equal(run.Name))
+	g.Expect(res.RequeueAfter).To(Equal(3 * time.Minute))
+
+	// Reconciling again before the next slot starts nothing new.
+	clock.now = cronEpoch.Add(9 * time.Minute)
+	res, _, runs = reconcileCron(g, r, cr)
+	g.Expect(runs).To(HaveLen(1))
+	g.Expect(res.RequeueAfter).To(Equal(time.Minute))
+}
+
+func TestCronRunConcurrencyPolicy(t *testing.T) {
+	g := NewWithT(t)
+	last := cronEpoch.Add(5 * time.Minute)
+
+	forbid := testCronRun("*/5 * * * *")
+	forbid.Spec.ConcurrencyPolicy = obs.ForbidConcurrent
+	forbid.Status.LastScheduleTime = &metav1.Time{Time: last}
+	running := cronChild(t, forbid, last, obs.PhaseRunning)
+	r := newTestCronReconciler(t, &fakeClock{now: cronEpoch.Add(11 * time.Minute)}, forbid, running)
+
+	_, got, runs := reconcileCron(g, r, forbid)
+	g.Expect(runs).To(HaveLen(1))
+	g.Expect(runs[0].Name).To(Equal(running.Name))
+	// The 10:10 slot stays due until the active run finishes.
+	g.Expect(got.Status.LastScheduleTime.Time).To(BeTemporally("==", last))
+
+	running.Status.Phase = obs.PhaseSucceeded
+	g.Expect(r.Status().Update(context.Background(), running)).To(Succeed())
+	r.Clock = &fakeClock{now: cronEpoch.Add(12 * time.Minute)}
+	_, got, runs = reconcileCron(g, r, forbid)
+	g.Expect(runs).To(HaveLen(2))
+	g.Expect(got.Status.LastScheduleTime.Time).To(BeTemporally("==", cronEpoch.Add(10*time.Minute)))
+	g.Expect(got.Status.Active).To(HaveLen(1))
+	g.Expect(got.Status.Active[0].Name).NotTo(Equal(running.Name))
+
+	replace := testCronRun("*/5 * * * *")
+	replace.Spec.ConcurrencyPolicy = obs.ReplaceConcurrent
+	replace.Status.LastScheduleTime = &metav1.Time{Time: last}
+	running = cronChild(t, replace, last, obs.PhaseRunning)
+	r = newTestCronReconciler(t, &fakeClock{now: cronEpoch.Add(11 * time.Minute)}, replace, running)
+
+	_, got, runs = reconcileCron(g, r, replace)
+	g.Expect(runs).To(HaveLen(1))
+	g.Expect(runs[0].Name).NotTo(Equal(running.Name))
+	g.Expect(got.Status.Active).To(HaveLen(1))
+	g.Expect(got.Status.Active[0].Name).To(Equal(runs[0].Name))
+}
+
+func TestCronRunStartingDeadline(t *testing.T) {
+	g := NewWithT(t)
+
+	deadline := int64(60)
+	cr := testCronRun("*/5 * * * *")
+	cr.Spec.StartingDeadlineSeconds = &deadline
+	r := newTestCronReconciler(t, &fakeClock{now: cronEpoch.Add(7 * time.Minute)}, cr)
+
+	// 10:05 was missed by two minutes, more than the deadline allows.
+	res, got, runs := reconcileCron(g, r, cr)
+	g.Expect(runs).To(BeEmpty())
+	g.Expect(got.Status.LastScheduleTime).To(BeNil())
+	g.Expect(res.RequeueAfter).To(Equal(3 * time.Minute))
+
+	r.Clock = &fakeClock{now: cronEpoch.Add(10*time.Minute + 30*time.Second)}
+	_, _, runs = reconcileCron(g, r, cr)
+	g.Expect(runs).To(HaveLen(1))
+}
+
+func TestCronRunForbidPastStartingDeadline(t *testing.T) {
+	g := NewWithT(t)
+	last := cronEpoch.Add(5 * time.Minute)
+
+	deadline := int64(60)
+	cr := testCronRun("*/5 * * * *")
+	cr.Spec.ConcurrencyPolicy = obs.ForbidConcurrent
+	cr.Spec.StartingDeadlineSeconds = &deadline
+	cr.Status.LastScheduleTime = &metav1.Time{Time: last}
+	running := cronChild(t, cr, last, obs.PhaseRunning)
+	r := newTestCronReconciler(t, &fakeClock{now: cronEpoch.Add(10*time.Minute + 30*time.Second)}, cr, running)
+	reconcileCron(g, r, cr)
+
+	// The active run outlives the deadline of the held 10:10 slot.
+	running.Status.Phase = obs.PhaseSucceeded
+	g.Expect(r.Status().Update(context.Background(), running)).To(Succeed())
+	r.Clock = &fakeClock{now: cronEpoch.Add(12 * time.Minute)}
+	res, got, runs := reconcileCron(g, r, cr)
+	g.Expect(runs).To(HaveLen(1))
+	g.Expect(got.Status.Active).To(BeEmpty())
+	g.Expect(got.Status.LastScheduleTime.Time).To(BeTemporally("==", last))
+	g.Expect(res.RequeueAfter).To(Equal(3 * time.Minute))
+}
+
+func TestCronRunTooManyMissedSlots(t *testing.T) {
+	g := NewWithT(t)
+
+	// A minutely schedule thirty days after its last run.
+	cr := testCronRun("* * * * *")
+	now := cronEpoch.Add(30*24*time.Hour + 30*time.Second)
+	r := newTestCronReconciler(t, &fakeClock{now: now}, cr)
+	rec := record.NewFakeRecorder(10)
+	r.Recorder = rec
+
+	res, got, runs := reconcileCron(g, r, cr)
+	g.Expect(runs).To(HaveLen(1))
+	latest := cronEpoch.Add(30 * 24 * time.Hour)
+	g.Expect(runs[0].Annotations).To(HaveKeyWithValue(annotationScheduledAt, latest.Format(time.RFC3339)))
+	g.Expect(got.Status.LastScheduleTime.Time).To(BeTemporally("==", latest))
+	g.Expect(res.RequeueAfter).To(Equal(30 * time.Second))
+	g.Expect(rec.Events).To(Receive(ContainSubstring(eventTooManyMissedRuns)))
+
+	// Caught up: the next slot is walked to as usual, without a warning.
+	r.Clock = &fakeClock{now: now.Add(time.Minute)}
+	_, _, runs = reconcileCron(g, r, cr)
+	g.Expect(runs).To(HaveLen(2))
+	g.Expect(rec.Events).NotTo(Receive())
+}
+
+func TestCronRunHistoryLimits(t *testing.T) {
+	g := NewWithT(t)
+
+	limit := int32(1)
+	cr := testCronRun("0 * * * *")
+	cr.Spec.SuccessfulRunsHistoryLimit = &limit
+	cr.Status.LastScheduleTime = &metav1.Time{Time: cronEpoch}
+	objs := []client.Object{cr}
+	for i := 3; i >= 0; i-- {
+		objs = append(objs, cronChild(t, cr, cronEpoch.Add(-time.Duration(i)*time.Hour), obs.PhaseSucceeded))
+	}
+	objs = append(objs,
+		cronChild(t, cr, cronEpoch.Add(-90*time.Minute), obs.PhaseFailed),
+		cronChild(t, cr, cronEpoch.Add(-30*time.Minute), obs.PhaseFailed))
+	r := newTestCronReconciler(t, &fakeClock{now: cronEpoch.Add(time.Minute)}, objs...)
+
+	_, got, runs := reconcileCron(g, r, cr)
+	var names []string
+	for _, run := range runs {
+		names = append(names, run.Name)
+	}
+	// The newest succeeded run and the default one failed run are kept.
+	g.Expect(names).To(ConsistOf("nightly-1000", "nightly-0930"))
+	g.Expect(got.Status.Active).To(BeEmpty())
+}
+
+func TestCronRunTimeZoneAndInvalidSchedule(t *testing.T) {
+	g := NewWithT(t)
+
+	tz := "Europe/Berlin"
+	cr := testCronRun("0 11 * * *")
+	cr.Spec.TimeZone = &tz
+	cr.CreationTimestamp = metav1.NewTime(cronEpoch.Add(-time.Hour))
+	// 10:30 UTC is 11:30 in Berlin, so the 11:00 Berlin slot is due.
+	r := newTestCronReconciler(t, &fakeClock{now: cronEpoch.Add(30 * time.Minute)}, cr)
+	_, got, runs := reconcileCron(g, r, cr)
+	g.Expect(runs).To(HaveLen(1))
+	g.Expect(runs[0].Annotations).To(HaveKeyWithValue(annotationScheduledAt, "2024-03-01T10:00:00Z"))
+	g.Expect(got.Status.Message).To(BeEmpty())
+
+	bad := testCronRun("0 25 * * *")
+	r = newTestCronReconciler(t, &fakeClock{now: cronEpoch.Add(time.Hour)}, bad)
+	res, got, runs := reconcileCron(g, r, bad)
+	g.Expect(runs).To(BeEmpty())
+	g.Expect(got.Status.Message).To(ContainSubstring("invalid schedule"))
+	g.Expect(res).To(Equal(ctrl.Result{}))
+}
@@ -2,36 +2,90 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"math"
+	"net/http"
+	"strconv"
 	"time"
 
 	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	"github.com/example/observatory-operator/internal/metrics"
+	"github.com/example/observatory-operator/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
+	// labelRun is informational; Jobs are found by labelRunUID.
 	labelRun = "obs.seventh/run"
+	// labelRetryGeneration records the run's retry generation when a Job
+	// was created, so Jobs from before a retry can be told apart.
+	labelRetryGeneration = "obs.seventh/retry-generation"
 	finalizerName = "observatory.seventh-horizon.io/finalizer"
+	// defaultTaskImage runs tasks that name no image.
+	defaultTaskImage = "busybox:1.36"
 )
 
 type ObservatoryRunReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
+	// Tracer records spans for runs with spec.observability.otel.enabled.
+	// Nil disables export; TRACEPARENT is still injected into task pods.
+	Tracer trace.TracerProvider
+	// Recorder records task and run lifecycle events on the run. Nil
+	// disables events.
+	Recorder record.EventRecorder
+	// HTTPClient makes the calls of http tasks. Nil uses
+	// http.DefaultClient.
+	HTTPClient *http.Client
+	// HTTPAllowedHosts are the hosts http tasks may call, by name or as
+	// "*.example.com" for any host below example.com. Calls are made from
+	// the controller's network, so empty allows none.
+	HTTPAllowedHosts []string
+
+	events eventDeduper
+	traced eventDeduper
+	calls  httpCalls
+	// httpDone wakes a run up when one of its http calls finishes.
+	httpDone chan event.GenericEvent
+	// stopped is closed when the manager stops.
+	stopped chan struct{}
 }
 
-func (r *ObservatoryRunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *ObservatoryRunReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
 	logger := log.FromContext(ctx)
 
+	start := time.Now()
+	defer func() {
+		metrics.ReconcileDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.ReconcileTotal.WithLabelValues(metrics.ResultError).Inc()
+			metrics.ReconcileErrors.Inc()
+			return
+		}
+		metrics.ReconcileTotal.WithLabelValues(metrics.ResultSuccess).Inc()
+	}()
+
 	var run observatoryv1alpha1.ObservatoryRun
 	if err := r.Get(ctx, req.NamespacedName, &run); err != nil {
 		if apierrors.IsNotFound(err) { return ctrl.Result{}, nil }
@@ -49,63 +103,212 @@ func (r *ObservatoryRunReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		if err := r.Update(ctx, &run); err != nil { return ctrl.Result{}, err }
 	}
 
+	if _, ok := run.Annotations[observatoryv1alpha1.RetryAnnotation]; ok {
+		return r.retryRun(ctx, &run)
+	}
+
+	if run.Status.Reason == observatoryv1alpha1.ReasonInvalidTemplate {
+		// Never started; see below.
+		return ctrl.Result{}, nil
+	}
+
 	// Create a deep copy to patch from (must be BEFORE any status mutations)
 	orig := run.DeepCopy()
 
-	if err := r.collectJobStatuses(ctx, &run); err != nil {
+	if err := r.applyWorkflowTemplate(ctx, &run); err != nil {
+		var uerr *UserError
+		if !errors.As(err, &uerr) {
+			return ctrl.Result{}, err
+		}
+		// The run cannot start; fail it rather than retrying until someone
+		// creates the template.
+		run.Status.Phase = observatoryv1alpha1.PhaseFailed
+		run.Status.Reason = observatoryv1alpha1.ReasonInvalidTemplate
+		run.Status.Message = uerr.Error()
+		updateRunSummary(&run, time.Now())
+		return ctrl.Result{}, r.Status().Patch(ctx, &run, client.MergeFrom(orig))
+	}
+
+	if err := r.observeTasks(ctx, &run); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	frontier := r.computeFrontier(&run)
-	for _, t := range frontier {
-		if err := r.ensureJob(ctx, &run, t); err != nil {
+	if err := recordParameters(&run); err != nil {
+		// The webhook rejects these; reaching here means it was bypassed.
+		failUnfinishedTasks(&run, observatoryv1alpha1.ReasonInvalidParameters, err.Error())
+	}
+
+	if runDeadlineExceeded(&run, time.Now()) {
+		if err := r.enforceRunDeadline(ctx, &run); err != nil {
 			return ctrl.Result{}, err
 		}
 	}
 
-	// Update the phase or other fields in status
-	run.Status.Phase = r.derivePhase(&run)
-
-	// Apply a merge patch to avoid resourceVersion conflicts
-	if err := r.Status().Patch(ctx, &run, client.MergeFrom(orig)); err != nil {
-		if apierrors.IsConflict(err) {
-			// Retry later; object was modified concurrently
-			return ctrl.Result{RequeueAfter: 500 * time.Millisecond}, nil
+	if run.Spec.Cancel && !isTerminal(run.Status.Phase) {
+		if err := r.cancelRun(ctx, &run); err != nil {
+			return ctrl.Result{}, err
 		}
-		logger.Error(err, "status patch failed")
+	}
+
+	quota, err := r.projectQuotaFor(ctx, &run)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
+	queued, blocked := false, false
+	if waitingToStart(&run) && !isTerminal(derivePhase(&run)) {
+		if !quota.allowsNamespace(run.Namespace) {
+			failUnfinishedTasks(&run, observatoryv1alpha1.ReasonNotAllowed,
+				quota.notAllowed(fmt.Sprintf("namespace %q", run.Namespace)))
+		} else {
+			queued = quota.mustQueue(&run)
+		}
+	}
+
+	if queued {
+		run.Status.Phase = observatoryv1alpha1.PhaseQueued
+		run.Status.Reason = observatoryv1alpha1.ReasonQueued
+	} else {
+		if run.Status.Reason == observatoryv1alpha1.ReasonQueued {
+			run.Status.Reason = ""
+		}
+		// A task can finish as it is launched, e.g. when the project rejects
+		// its image or it was approved in advance. Nothing will wake the run
+		// for it, so schedule again until no launch finishes a task.
+		for settled := true; settled; {
+			settled = false
+			settleTasks(&run)
+			applyFanOut(&run)
+			frontier := computeFrontier(&run)
+			if room := quota.taskRoom(&run); room >= 0 && room < len(frontier) {
+				frontier = frontier[:room]
+				blocked = true
+			}
+			for _, t := range frontier {
+				if err := r.launchTask(ctx, &run, t, quota); err != nil {
+					return ctrl.Result{}, err
+				}
+				st := run.Status.TaskStatuses[t]
+				switch {
+				case st == nil || st.JobName == "" && st.State == observatoryv1alpha1.TaskPending:
+					// Rejected by admission, or an earlier Job of the same name is
+					// still being deleted.
+					blocked = true
+				case isTaskTerminal(st.State):
+					settled = true
+				}
+			}
+		}
+		// Update the phase or other fields in status
+		run.Status.Phase = derivePhase(&run)
+	}
+	finished := isTerminal(run.Status.Phase) && !isTerminal(orig.Status.Phase)
+	if finished {
+		metrics.WorkflowDuration.WithLabelValues(string(run.Status.Phase)).Observe(time.Since(run.CreationTimestamp.Time).Seconds())
+	}
+
+	updateRunSummary(&run, time.Now())
 
-	if run.Status.Phase == observatoryv1alpha1.PhaseSucceeded || run.Status.Phase == observatoryv1alpha1.PhaseFailed {
+	// Apply a merge patch to avoid resourceVersion conflicts. Most reconciles
+	// of a busy run change nothing; skip the request then.
+	if !equality.Semantic.DeepEqual(orig.Status, run.Status) {
+		if err := r.Status().Patch(ctx, &run, client.MergeFrom(orig)); err != nil {
+			if apierrors.IsConflict(err) {
+				// Retry later; object was modified concurrently
+				return ctrl.Result{RequeueAfter: 500 * time.Millisecond}, nil
+			}
+			logger.Error(err, "status patch failed")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if finished {
+		r.runEvent(&run)
+		if tracingEnabled(&run) {
+			r.recordSpans([]tracing.Span{runSpan(&run, time.Now())})
+		}
+	}
+
+	if isTerminal(run.Status.Phase) {
 		return ctrl.Result{}, nil
 	}
+	if queued {
+		return ctrl.Result{RequeueAfter: queuePollInterval}, nil
+	}
+	// Job and pod events wake the run up otherwise; poll only for capacity
+	// that frees up elsewhere.
+	after := nextWakeup(&run, time.Now())
+	if blocked && (after == 0 || after > queuePollInterval) {
+		after = queuePollInterval
+	}
+	return ctrl.Result{RequeueAfter: after}, nil
+}
 
-	return ctrl.Result{Requeue: true}, nil
+func isTerminal(phase observatoryv1alpha1.Phase) bool {
+	return phase == observatoryv1alpha1.PhaseSucceeded || phase == observatoryv1alpha1.PhaseFailed || phase == observatoryv1alpha1.PhaseCancelled
 }
 
 func (r *ObservatoryRunReconciler) collectJobStatuses(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun) error {
-	// Defensive: ensure the status map always exists (guards against nil map on fresh objects or concurrent updates)
-	if run.Status.TaskStatuses == nil {
-		run.Status.TaskStatuses = map[string]*observatoryv1alpha1.TaskStatus{}
-	}
-	var jobs batchv1.JobList
-	if err := r.List(ctx, &jobs, client.InNamespace(run.Namespace), client.MatchingLabels{labelRun: run.Name}); err != nil {
+	jobs, err := r.listRunJobs(ctx, run)
+	if err != nil {
 		return err
 	}
-	// Initialize all declared tasks to Pending unless overwritten by observed Jobs
-	for name := range run.Spec.Workflow.Tasks {
-		if run.Status.TaskStatuses[name] == nil {
-			run.Status.TaskStatuses[name] = &observatoryv1alpha1.TaskStatus{State: observatoryv1alpha1.TaskPending}
+	var spans []tracing.Span
+	for _, j := range jobs {
+		if !j.DeletionTimestamp.IsZero() {
+			// Being torn down (e.g. by enforceRunDeadline); its task already has a final state.
+			continue
 		}
-	}
-	for _, j := range jobs.Items {
-		name := strings.TrimPrefix(j.Name, run.Name+"-")
+		name := jobTask(&j)
 		st := run.Status.TaskStatuses[name]
 		if st == nil {
 			st = &observatoryv1alpha1.TaskStatus{}
 			run.Status.TaskStatuses[name] = st
 		}
+		if st.State == observatoryv1alpha1.TaskCancelled {
+			// Its Job was deleted by cancelRun; the cache may not show that yet.
+			continue
+		}
+		if jobRetryGeneration(&j) < run.Status.RetryGeneration && isPending(run, name) && st.JobName == "" {
+			// Likewise for a Job deleted by retryRun whose task was reset.
+			continue
+		}
+		spec, _ := scheduledSpecFor(run, name)
+		rs := spec.RetryStrategy
+		if rs != nil && (jobAttempt(&j) < st.Attempt || st.State == observatoryv1alpha1.TaskFailed) {
+			// An earlier attempt, or the last one, already recorded.
+			continue
+		}
+		var pods corev1.PodList
+		if err := r.List(ctx, &pods, client.InNamespace(j.Namespace), client.MatchingLabels{labelJobName: j.Name}); err != nil {
+			return err
+		}
+		recordAttempts(st, &j, pods.Items, rs != nil)
+		if rs != nil {
+			done, err := r.observeAttempt(ctx, st, &j, pods.Items, rs)
+			if err != nil {
+				return err
+			}
+			if done {
+				syncTaskTimes(st, &j)
+				if st.State == observatoryv1alpha1.TaskFailed {
+					r.taskEvent(run, name, st)
+					if tracingEnabled(run) {
+						spans = append(spans, taskSpan(run, name, &j, st, time.Now()))
+					}
+				} else {
+					r.event(run, corev1.EventTypeWarning, eventTaskRetrying, "Task %s: %s", name, st.Message)
+					if tracingEnabled(run) {
+						// The attempt failed even though the task goes on.
+						attempt := *st
+						attempt.State = observatoryv1alpha1.TaskFailed
+						spans = append(spans, taskSpan(run, name, &j, &attempt, time.Now()))
+					}
+				}
+				continue
+			}
+		}
 		st.JobName = j.Name
+		prevState, prevMessage := st.State, st.Message
 
 		// Derive human-friendly status message helpers
 		started := ""
@@ -114,7 +317,23 @@ func (r *ObservatoryRunReconciler) collectJobStatuses(ctx context.Context, run *
 		}
 
 		switch {
+		case jobDeadlineExceeded(&j):
+			st.State = observatoryv1alpha1.TaskFailed
+			st.Reason = observatoryv1alpha1.ReasonTimedOut
+			if j.Spec.ActiveDeadlineSeconds != nil {
+				st.Message = fmt.Sprintf("Timed out after %ds", *j.Spec.ActiveDeadlineSeconds)
+			} else {
+				st.Message = "Timed out"
+			}
+
 		case j.Status.Succeeded > 0:
+			if declared := spec.Outputs; len(declared) > 0 && st.State != observatoryv1alpha1.TaskSucceeded {
+				outputs, err := r.readOutputs(ctx, &j, declared)
+				if err != nil {
+					return err
+				}
+				st.Outputs = outputs
+			}
 			st.State = observatoryv1alpha1.TaskSucceeded
 			if j.Status.CompletionTime != nil && j.Status.StartTime != nil {
 				dur := j.Status.CompletionTime.Sub(j.Status.StartTime.Time).Round(time.Second)
@@ -128,6 +347,11 @@ func (r *ObservatoryRunReconciler) collectJobStatuses(ctx context.Context, run *
 				// Retry still allowed: present as Pending so computeFrontier can pick it back up
 				st.State = observatoryv1alpha1.TaskPending
 				st.Message = fmt.Sprintf("Failed %d/%d times, retrying", j.Status.Failed, *j.Spec.BackoffLimit)
+				if st.Message != prevMessage {
+					// The failure count moved since the last observation: one more retry.
+					metrics.WorkflowRetries.Inc()
+					r.event(run, corev1.EventTypeWarning, eventTaskRetrying, "Task %s: %s", name, st.Message)
+				}
 			} else {
 				st.State = observatoryv1alpha1.TaskFailed
 				if j.Spec.BackoffLimit != nil {
@@ -153,91 +377,55 @@ func (r *ObservatoryRunReconciler) collectJobStatuses(ctx context.Context, run *
 				st.Message = "Waiting for dependencies"
 			}
 		}
-	}
-	return nil
-}
 
-func (r *ObservatoryRunReconciler) computeFrontier(run *observatoryv1alpha1.ObservatoryRun) []string {
-	// If FailurePolicy is Stop and any task has failed, block scheduling new tasks
-	if strings.EqualFold(run.Spec.Workflow.FailurePolicy, "Stop") {
-		for _, st := range run.Status.TaskStatuses {
-			if st != nil && st.State == observatoryv1alpha1.TaskFailed {
-				return []string{}
+		syncTaskTimes(st, &j)
+
+		if st.State != prevState {
+			r.taskEvent(run, name, st)
+			switch st.State {
+			case observatoryv1alpha1.TaskSucceeded:
+				metrics.JobCompleted.WithLabelValues(metrics.StatusSuccess).Inc()
+			case observatoryv1alpha1.TaskFailed:
+				metrics.JobCompleted.WithLabelValues(metrics.StatusFailure).Inc()
+				if jobDeadlineExceeded(&j) {
+					metrics.JobTimeouts.Inc()
+				}
 			}
-		}
-	}
-
-	ready := []string{}
-	for name, spec := range run.Spec.Workflow.Tasks {
-		st := run.Status.TaskStatuses[name]
-		// Skip if already running or completed
-		if st != nil && (st.State == observatoryv1alpha1.TaskRunning || st.State == observatoryv1alpha1.TaskSucceeded) {
-			continue
-		}
-		// All dependencies must have succeeded
-		depsOK := true
-		for _, d := range spec.Dependencies {
-			dst := run.Status.TaskStatuses[d]
-			if dst == nil || dst.State != observatoryv1alpha1.TaskSucceeded {
-				depsOK = false
-				break
+			if isTaskTerminal(st.State) && tracingEnabled(run) {
+				spans = append(spans, taskSpan(run, name, &j, st, time.Now()))
 			}
 		}
-		if depsOK {
-			ready = append(ready, name)
-		}
 	}
-	return ready
+	r.recordSpans(spans)
+	return nil
 }
 
-func (r *ObservatoryRunReconciler) derivePhase(run *observatoryv1alpha1.ObservatoryRun) observatoryv1alpha1.Phase {
-	// Aggregate task states
-	succeeded := 0
-	failed := 0
-	running := 0
-	pending := 0
-	total := len(run.Spec.Workflow.Tasks)
-
-	for name := range run.Spec.Workflow.Tasks {
-		st := run.Status.TaskStatuses[name]
-		if st == nil {
-			pending++
-			continue
-		}
-		switch st.State {
-		case observatoryv1alpha1.TaskSucceeded:
-			succeeded++
-		case observatoryv1alpha1.TaskFailed:
-			failed++
-		case observatoryv1alpha1.TaskRunning:
-			running++
-		default:
-			pending++
-		}
-	}
-
-	// Any failure flips the whole run to Failed (fail-fast semantics for the run overall)
-	if failed > 0 {
-		return observatoryv1alpha1.PhaseFailed
-	}
-
-	// All tasks succeeded
-	if total > 0 && succeeded == total {
-		return observatoryv1alpha1.PhaseSucceeded
+func isTaskTerminal(state observatoryv1alpha1.TaskState) bool {
+	switch state {
+	case observatoryv1alpha1.TaskSucceeded, observatoryv1alpha1.TaskFailed, observatoryv1alpha1.TaskSkipped, observatoryv1alpha1.TaskCancelled:
+		return true
 	}
+	return false
+}
 
-	// Consider run "Running" once any task starts; "Pending" only if none have.
-	if running > 0 || succeeded > 0 {
-		return observatoryv1alpha1.PhaseRunning
+// jobDeadlineExceeded reports whether the Job controller failed j because it
+// ran past spec.activeDeadlineSeconds.
+func jobDeadlineExceeded(j *batchv1.Job) bool {
+	for _, c := range j.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue && c.Reason == "DeadlineExceeded" {
+			return true
+		}
 	}
-
-	// Otherwise nothing has started yet
-	return observatoryv1alpha1.PhasePending
+	return false
 }
 
 func (r *ObservatoryRunReconciler) ensureJob(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun, task string) error {
 	logger := log.FromContext(ctx)
-	jobName := fmt.Sprintf("%s-%s", run.Name, task)
+	var attempt int32
+	if st := run.Status.TaskStatuses[task]; st != nil {
+		attempt = st.Attempt
+	}
+	jobName := attemptJobName(run, task, attempt)
 	var existing batchv1.Job
 	if err := r.Get(ctx, types.NamespacedName{Name: jobName, Namespace: run.Namespace}, &existing); err == nil {
 		return nil
@@ -245,24 +433,47 @@ func (r *ObservatoryRunReconciler) ensureJob(ctx context.Context, run *observato
 		return err
 	}
 
-	spec := run.Spec.Workflow.Tasks[task]
-	image := spec.Image
-	if image == "" { image = "busybox:1.36" }
+	spec, err := resolveScheduled(run, task)
+	if err != nil {
+		st := taskStatusFor(run, task)
+		st.State = observatoryv1alpha1.TaskFailed
+		st.Message = fmt.Sprintf("cannot resolve task inputs: %v", err)
+		return nil
+	}
+	image, _ := containerImage(spec)
+
+	resources, err := resourceRequirementsFor(run.Spec.Resources, spec.Resources)
+	if err != nil {
+		// Malformed quantities never become valid on retry; fail the task.
+		st := taskStatusFor(run, task)
+		st.State = observatoryv1alpha1.TaskFailed
+		st.Message = fmt.Sprintf("invalid resources: %v", err)
+		return nil
+	}
 
+	labels := jobLabels(run, task)
+	labels[labelAttempt] = strconv.Itoa(int(attempt))
+	labels[labelRetryGeneration] = strconv.FormatInt(run.Status.RetryGeneration, 10)
 	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: jobName, Namespace: run.Namespace,
-			Labels: map[string]string{labelRun: run.Name},
+			Labels: labels,
+			Annotations: jobAnnotations(task),
 		},
 		Spec: batchv1.JobSpec{
-			BackoffLimit: spec.Retries,
+			BackoffLimit: backoffLimitFor(spec),
+			ActiveDeadlineSeconds: activeDeadlineSeconds(spec.Timeout),
 			Template: corev1.PodTemplateSpec{
+				// Pods are cached by labelRunUID; see TaskPodSelector.
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
 				Spec: corev1.PodSpec{
 					RestartPolicy: corev1.RestartPolicyNever,
 					Containers: []corev1.Container{{
-						Name:  "task",
+						Name:  taskContainerName,
 						Image: image,
 						Command: commandFor(spec),
+						Resources: resources,
+						Env: envFor(run, task, attempt, spec),
 					}},
 				},
 			},
@@ -270,28 +481,340 @@ func (r *ObservatoryRunReconciler) ensureJob(ctx context.Context, run *observato
 	}
 
 	if err := controllerutil.SetControllerReference(run, job, r.Scheme); err != nil { return err }
-	if err := r.Create(ctx, job); err != nil { return err }
+	if err := r.Create(ctx, job); err != nil {
+		if apierrors.IsInvalid(err) {
+			metrics.JobCreateErrors.Inc()
+			// A malformed Job stays malformed; fail the task with the reason
+			// the API server gave rather than wait for it to be admitted.
+			st := taskStatusFor(run, task)
+			st.State = observatoryv1alpha1.TaskFailed
+			st.Message = fmt.Sprintf("Job %s is invalid: %v", jobName, err)
+			r.event(run, corev1.EventTypeWarning, eventJobCreateFailed, "%s", st.Message)
+			logger.Info("Job invalid", "job", jobName, "task", task, "reason", err.Error())
+			return nil
+		}
+		if apierrors.IsForbidden(err) {
+			metrics.JobCreateErrors.Inc()
+			// LimitRange / ResourceQuota admission rejected the Job: surface it on
+			// the task and leave it Pending so a later reconcile can try again.
+			uerr := &UserError{
+				Operation: "create job",
+				Cause:     err,
+				Message:   fmt.Sprintf("Job %s was rejected by the API server", jobName),
+				Hints: []string{
+					"check LimitRange and ResourceQuota objects in namespace " + run.Namespace,
+					"adjust spec.resources or the task's resources",
+				},
+			}
+			st := taskStatusFor(run, task)
+			st.State = observatoryv1alpha1.TaskPending
+			st.Message = uerr.Error()
+			r.event(run, corev1.EventTypeWarning, eventJobCreateFailed, "%s", uerr.Error())
+			logger.Info("Job rejected", "job", jobName, "task", task, "reason", err.Error())
+			return nil
+		}
+		metrics.JobCreateErrors.Inc()
+		r.event(run, corev1.EventTypeWarning, eventJobCreateFailed, "Creating Job %s failed: %v", jobName, err)
+		return err
+	}
+	st := taskStatusFor(run, task)
+	st.JobName = jobName
+	st.Message = ""
+	st.NextAttemptAt = nil
+	r.event(run, corev1.EventTypeNormal, eventTaskStarted, "Created Job %s for task %s", jobName, task)
 	logger.Info("Created Job", "job", jobName, "task", task)
 	return nil
 }
 
+// backoffLimitFor leaves retries to the Job controller unless the task has
+// a retryStrategy, in which case every attempt gets a Job of its own.
+func backoffLimitFor(spec observatoryv1alpha1.TaskSpec) *int32 {
+	if spec.RetryStrategy != nil {
+		var none int32
+		return &none
+	}
+	return spec.Retries
+}
+
+// taskStatusFor returns the status entry for task, creating it if needed.
+func taskStatusFor(run *observatoryv1alpha1.ObservatoryRun, task string) *observatoryv1alpha1.TaskStatus {
+	if run.Status.TaskStatuses == nil {
+		run.Status.TaskStatuses = map[string]*observatoryv1alpha1.TaskStatus{}
+	}
+	st := run.Status.TaskStatuses[task]
+	if st == nil {
+		st = &observatoryv1alpha1.TaskStatus{}
+		run.Status.TaskStatuses[task] = st
+	}
+	return st
+}
+
+// activeDeadlineSeconds rounds a task timeout up to whole seconds.
+func activeDeadlineSeconds(d *metav1.Duration) *int64 {
+	if d == nil || d.Duration <= 0 {
+		return nil
+	}
+	secs := int64(math.Ceil(d.Duration.Seconds()))
+	return &secs
+}
+
+// failUnfinishedTasks marks every workflow task and fan-out child that has
+// not finished as Failed with the given reason, and records the reason on
+// the run. Finally tasks are left to run.
+func failUnfinishedTasks(run *observatoryv1alpha1.ObservatoryRun, reason, message string) {
+	for name := range run.Spec.Workflow.Tasks {
+		taskStatusFor(run, name)
+	}
+	for name, st := range run.Status.TaskStatuses {
+		if !inWorkflow(run, name) || isTaskTerminal(st.State) {
+			continue
+		}
+		st.State = observatoryv1alpha1.TaskFailed
+		st.Reason = reason
+		st.Message = message
+		run.Status.Reason = reason
+	}
+}
+
+// enforceRunDeadline fails every unfinished task of a run that passed
+// spec.activeDeadline and cancels the ones still running.
+func (r *ObservatoryRunReconciler) enforceRunDeadline(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun) error {
+	logger := log.FromContext(ctx)
+	for _, name := range sortedKeys(run.Status.TaskStatuses) {
+		st := run.Status.TaskStatuses[name]
+		if !inWorkflow(run, name) || isTaskTerminal(st.State) || st.JobName == "" {
+			continue
+		}
+		if err := r.cancelTask(ctx, run, name, st); err != nil {
+			return err
+		}
+		metrics.JobTimeouts.Inc()
+		logger.Info("Cancelled task past run deadline", "job", st.JobName, "task", name)
+	}
+	failUnfinishedTasks(run, observatoryv1alpha1.ReasonTimedOut,
+		fmt.Sprintf("Run exceeded activeDeadline of %s", run.Spec.ActiveDeadline.Duration))
+	return nil
+}
+
+// retryRun acts on RetryAnnotation. A Failed or Cancelled run has the
+// tasks picked by tasksToRetry reset to Pending and their Jobs deleted, and
+// spec.cancel cleared; the next reconcile resumes scheduling from there.
+// Other runs are left alone. The annotation is removed either way.
+func (r *ObservatoryRunReconciler) retryRun(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	retry := run.Status.Phase == observatoryv1alpha1.PhaseFailed || run.Status.Phase == observatoryv1alpha1.PhaseCancelled
+	before := run.DeepCopy()
+	delete(run.Annotations, observatoryv1alpha1.RetryAnnotation)
+	if retry {
+		run.Spec.Cancel = false
+		// Decisions on approval tasks that did not pass do not carry over.
+		for name, st := range run.Status.TaskStatuses {
+			if st.State != observatoryv1alpha1.TaskSucceeded {
+				delete(run.Annotations, observatoryv1alpha1.ApprovalAnnotationPrefix+name)
+			}
+		}
+	}
+	if err := r.Patch(ctx, run, client.MergeFrom(before)); err != nil {
+		return ctrl.Result{}, err
+	}
+	if !retry {
+		logger.Info("Ignoring retry of a run that has not failed", "phase", run.Status.Phase)
+		// Removing the annotation triggers the next reconcile.
+		return ctrl.Result{}, nil
+	}
+
+	orig := run.DeepCopy()
+	if run.Status.Workflow != nil {
+		// Template runs: find tasks in the snapshot, as applyWorkflowTemplate would.
+		run.Spec.Workflow = *run.Status.Workflow.DeepCopy()
+	}
+	// Jobs from before labelRunUID are found by it below too.
+	if _, err := r.adoptLegacyJobs(ctx, run); err != nil {
+		return ctrl.Result{}, err
+	}
+	for _, name := range tasksToRetry(run) {
+		st := run.Status.TaskStatuses[name]
+		if err := r.cancelTask(ctx, run, name, st); err != nil {
+			return ctrl.Result{}, err
+		}
+		if st.Attempt > 0 {
+			// Earlier attempt Jobs too, so none is taken for the current one.
+			if err := r.DeleteAllOf(ctx, &batchv1.Job{}, client.InNamespace(run.Namespace),
+				client.MatchingLabels{labelRunUID: string(run.UID), labelTask: taskLabelValue(name)},
+				client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		run.Status.TaskStatuses[name] = &observatoryv1alpha1.TaskStatus{State: observatoryv1alpha1.TaskPending}
+	}
+	run.Status.RetryGeneration++
+	run.Status.LastRetryTime = &metav1.Time{Time: time.Now()}
+	run.Status.Reason, run.Status.Message = "", ""
+	run.Status.Phase = derivePhase(run)
+	updateRunSummary(run, time.Now())
+	if err := r.Status().Patch(ctx, run, client.MergeFrom(orig)); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.event(run, corev1.EventTypeNormal, eventRunRetrying, "Retrying run (retry %d)", run.Status.RetryGeneration)
+	logger.Info("Retrying run from failed tasks", "retryGeneration", run.Status.RetryGeneration)
+	// The watch event for the annotation removal may arrive before the
+	// cache has this status, so come back once more rather than rely on it.
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// jobRetryGeneration reads labelRetryGeneration; Jobs without it predate
+// any retry.
+func jobRetryGeneration(j *batchv1.Job) int64 {
+	g, _ := strconv.ParseInt(j.Labels[labelRetryGeneration], 10, 64)
+	return g
+}
+
+// cancelRun cancels a cancelled run's running tasks and marks every
+// unfinished task Cancelled, finally tasks included.
+func (r *ObservatoryRunReconciler) cancelRun(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun) error {
+	logger := log.FromContext(ctx)
+	for _, tasks := range []map[string]observatoryv1alpha1.TaskSpec{run.Spec.Workflow.Tasks, run.Spec.Workflow.Finally} {
+		for name := range tasks {
+			taskStatusFor(run, name)
+		}
+	}
+	for _, name := range sortedKeys(run.Status.TaskStatuses) {
+		st := run.Status.TaskStatuses[name]
+		if isTaskTerminal(st.State) {
+			continue
+		}
+		if st.JobName != "" {
+			if err := r.cancelTask(ctx, run, name, st); err != nil {
+				return err
+			}
+			logger.Info("Cancelled task of cancelled run", "job", st.JobName, "task", name)
+		}
+		st.State = observatoryv1alpha1.TaskCancelled
+		st.Reason = observatoryv1alpha1.ReasonCancelled
+		st.Message = "Run was cancelled"
+	}
+	run.Status.Reason = observatoryv1alpha1.ReasonCancelled
+	return nil
+}
+
+// resourceRequirementsFor merges the run-level and task-level resources and
+// converts them into container ResourceRequirements.
+func resourceRequirementsFor(base, override *observatoryv1alpha1.ResourcesSpec) (corev1.ResourceRequirements, error) {
+	var out corev1.ResourceRequirements
+	merged := observatoryv1alpha1.MergeResources(base, override)
+	if merged == nil {
+		return out, nil
+	}
+	var err error
+	if out.Requests, err = resourceListFor(merged.Requests); err != nil {
+		return out, fmt.Errorf("requests: %w", err)
+	}
+	if out.Limits, err = resourceListFor(merged.Limits); err != nil {
+		return out, fmt.Errorf("limits: %w", err)
+	}
+	return out, nil
+}
+
+func resourceListFor(m map[string]string) (corev1.ResourceList, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	list := corev1.ResourceList{}
+	for k, v := range m {
+		q, err := resource.ParseQuantity(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s=%q: %w", k, v, err)
+		}
+		list[corev1.ResourceName(k)] = q
+	}
+	return list, nil
+}
+
+// envFor returns the environment injected into an attempt's container: the
+// task's own (already resolved) env plus controller-provided variables.
+func envFor(run *observatoryv1alpha1.ObservatoryRun, task string, attempt int32, spec observatoryv1alpha1.TaskSpec) []corev1.EnvVar {
+	env := append([]corev1.EnvVar{}, spec.Env...)
+	if tracingEnabled(run) {
+		env = append(env, corev1.EnvVar{Name: "TRACEPARENT", Value: traceparentFor(run, task, attempt)})
+	}
+	return env
+}
+
+// commandFor builds the container command. When the task has file-backed
+// outputs, the command is wrapped so they are copied into the termination
+// message after it exits, preserving its exit code. A Command script runs in
+// a subshell, so an `exit` or a `set -e` failure in it still reaches the
+// collection.
 func commandFor(spec observatoryv1alpha1.TaskSpec) []string {
-	if spec.Command != "" {
-		return []string{"/bin/sh", "-lc", spec.Command}
+	collect := outputCollectionScript(spec.Outputs)
+	if spec.Command == "" && len(spec.Args) > 0 {
+		if collect == "" { return spec.Args }
+		script := "\"$@\"\n__rc=$?\n" + collect + "\nexit $__rc"
+		return append([]string{"/bin/sh", "-c", script, "sh"}, spec.Args...)
+	}
+	script := spec.Command
+	if script == "" { script = "echo running && sleep 2 && echo done" }
+	if collect != "" {
+		script = "(\n" + script + "\n)\n__rc=$?\n" + collect + "\nexit $__rc"
 	}
-	if len(spec.Args) > 0 { return spec.Args }
-	return []string{"/bin/sh", "-lc", "echo running && sleep 2 && echo done"}
+	return []string{"/bin/sh", "-lc", script}
 }
 
 func (r *ObservatoryRunReconciler) handleDeletion(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun) (ctrl.Result, error) {
+	r.calls.stopRun(run.UID)
 	controllerutil.RemoveFinalizer(run, finalizerName)
 	if err := r.Update(ctx, run); err != nil { return ctrl.Result{}, err }
 	return ctrl.Result{}, nil
 }
 
 func (r *ObservatoryRunReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &observatoryv1alpha1.ObservatoryRun{}, projectIndexKey, indexRunProject); err != nil {
+		return err
+	}
+	r.httpDone = make(chan event.GenericEvent)
+	r.stopped = make(chan struct{})
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		close(r.stopped)
+		return nil
+	})); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&observatoryv1alpha1.ObservatoryRun{}).
+		// Status-only updates are the reconciler's own writes.
+		For(&observatoryv1alpha1.ObservatoryRun{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			predicate.AnnotationChangedPredicate{},
+			deletingPredicate,
+		))).
 		Owns(&batchv1.Job{}).
+		// Pods report what Jobs do not, e.g. an image that cannot be pulled.
+		// Only task pods are cached; see TaskPodSelector.
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.runForPod)).
+		WatchesRawSource(&source.Channel{Source: r.httpDone}, &handler.EnqueueRequestForObject{}).
 		Complete(r)
 }
+
+// deletingPredicate passes updates that mark an object for deletion.
+var deletingPredicate = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return e.ObjectOld.GetDeletionTimestamp().IsZero() && !e.ObjectNew.GetDeletionTimestamp().IsZero()
+	},
+}
+
+// runForPod maps a task pod to the run owning its Job.
+func (r *ObservatoryRunReconciler) runForPod(ctx context.Context, pod client.Object) []reconcile.Request {
+	jobName := pod.GetLabels()[labelJobName]
+	if jobName == "" {
+		return nil
+	}
+	var job batchv1.Job
+	if err := r.Get(ctx, types.NamespacedName{Name: jobName, Namespace: pod.GetNamespace()}, &job); err != nil {
+		return nil
+	}
+	owner := metav1.GetControllerOf(&job)
+	if owner == nil || owner.Kind != "ObservatoryRun" || owner.APIVersion != observatoryv1alpha1.GroupVersion.String() {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: owner.Name, Namespace: job.Namespace}}}
+}
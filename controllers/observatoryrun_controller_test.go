@@ -0,0 +1,575 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	obs "github.com/example/observatory-operator/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// newTestReconciler returns a reconciler backed by a fake client seeded with objs.
+func newTestReconciler(t *testing.T, objs ...client.Object) *ObservatoryRunReconciler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := obs.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return &ObservatoryRunReconciler{
+		Client: fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(objs...).
+			WithStatusSubresource(&obs.ObservatoryRun{}).
+			WithIndex(&obs.ObservatoryRun{}, projectIndexKey, indexRunProject).
+			Build(),
+		Scheme: scheme,
+	}
+}
+
+// taskJob returns the first-attempt Job of task as ensureJob creates it.
+func taskJob(run *obs.ObservatoryRun, task string) *batchv1.Job {
+	return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name: attemptJobName(run, task, 0), Namespace: run.Namespace,
+		Labels:          jobLabels(run, task),
+		Annotations:     jobAnnotations(task),
+		OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(run, obs.GroupVersion.WithKind("ObservatoryRun"))},
+	}}
+}
+
+func TestCollectTimedOutJob(t *testing.T) {
+	g := NewWithT(t)
+
+	retries := int32(3)
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns"},
+		Spec: obs.ObservatoryRunSpec{Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{
+			"a": {Retries: &retries},
+		}}},
+	}
+	deadline := int64(30)
+	job := taskJob(run, "a")
+	job.Spec.ActiveDeadlineSeconds = &deadline
+	job.Status = batchv1.JobStatus{
+		Failed: 1,
+		Conditions: []batchv1.JobCondition{{
+			Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "DeadlineExceeded",
+		}},
+	}
+
+	r := newTestReconciler(t, job)
+	g.Expect(r.observeTasks(context.Background(), run)).To(Succeed())
+
+	st := run.Status.TaskStatuses["a"]
+	// Failed (1) < BackoffLimit (3) would normally read as "retrying"; the
+	// deadline condition must win.
+	g.Expect(st.State).To(Equal(obs.TaskFailed))
+	g.Expect(st.Reason).To(Equal(obs.ReasonTimedOut))
+	g.Expect(st.Message).To(Equal("Timed out after 30s"))
+}
+
+func TestEnforceRunDeadline(t *testing.T) {
+	g := NewWithT(t)
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "r-b", Namespace: "ns", Labels: map[string]string{labelRun: "r"}}}
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "r", Namespace: "ns",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-2 * time.Hour)),
+		},
+		Spec: obs.ObservatoryRunSpec{
+			ActiveDeadline: &metav1.Duration{Duration: time.Hour},
+			Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{
+				"a": {}, "b": {Dependencies: []string{"a"}}, "c": {Dependencies: []string{"b"}},
+			}},
+		},
+		Status: obs.ObservatoryRunStatus{TaskStatuses: map[string]*obs.TaskStatus{
+			"a": {State: obs.TaskSucceeded},
+			"b": {State: obs.TaskRunning, JobName: "r-b"},
+		}},
+	}
+	g.Expect(runDeadlineExceeded(run, time.Now())).To(BeTrue())
+
+	r := newTestReconciler(t, job)
+	g.Expect(r.enforceRunDeadline(context.Background(), run)).To(Succeed())
+
+	err := r.Get(context.Background(), client.ObjectKeyFromObject(job), &batchv1.Job{})
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	g.Expect(run.Status.TaskStatuses["a"].State).To(Equal(obs.TaskSucceeded))
+	for _, name := range []string{"b", "c"} {
+		g.Expect(run.Status.TaskStatuses[name].State).To(Equal(obs.TaskFailed))
+		g.Expect(run.Status.TaskStatuses[name].Reason).To(Equal(obs.ReasonTimedOut))
+	}
+	g.Expect(run.Status.Reason).To(Equal(obs.ReasonTimedOut))
+	g.Expect(computeFrontier(run)).To(BeEmpty())
+	g.Expect(derivePhase(run)).To(Equal(obs.PhaseFailed))
+}
+
+func TestCancelRun(t *testing.T) {
+	g := NewWithT(t)
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "r-b", Namespace: "ns", Labels: map[string]string{labelRun: "r"}}}
+	job.Status.Active = 1
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns", Finalizers: []string{finalizerName}},
+		Spec: obs.ObservatoryRunSpec{
+			Cancel: true,
+			Workflow: obs.Workflow{
+				Tasks: map[string]obs.TaskSpec{
+					"a": {Image: "busybox"}, "b": {Image: "busybox"}, "c": {Image: "busybox", Dependencies: []string{"b"}},
+				},
+				Finally: map[string]obs.TaskSpec{"cleanup": {Image: "busybox"}},
+			},
+		},
+		Status: obs.ObservatoryRunStatus{
+			Phase: obs.PhaseRunning,
+			TaskStatuses: map[string]*obs.TaskStatus{
+				"a": {State: obs.TaskSucceeded},
+				"b": {State: obs.TaskRunning, JobName: "r-b"},
+			},
+		},
+	}
+	r := newTestReconciler(t, job, run)
+
+	res, got := reconcileRun(g, r, run)
+	err := r.Get(context.Background(), client.ObjectKeyFromObject(job), &batchv1.Job{})
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	g.Expect(got.Status.TaskStatuses["a"].State).To(Equal(obs.TaskSucceeded))
+	// Finally tasks do not run for a cancelled run.
+	for _, name := range []string{"b", "c", "cleanup"} {
+		g.Expect(got.Status.TaskStatuses[name].State).To(Equal(obs.TaskCancelled))
+		g.Expect(got.Status.TaskStatuses[name].Reason).To(Equal(obs.ReasonCancelled))
+	}
+	g.Expect(got.Status.Phase).To(Equal(obs.PhaseCancelled))
+	g.Expect(got.Status.Reason).To(Equal(obs.ReasonCancelled))
+	g.Expect(res).To(Equal(ctrl.Result{}))
+
+	var jobs batchv1.JobList
+	g.Expect(r.List(context.Background(), &jobs)).To(Succeed())
+	g.Expect(jobs.Items).To(BeEmpty())
+}
+
+func TestRetryRun(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "r", Namespace: "ns", Finalizers: []string{finalizerName},
+			Annotations: map[string]string{obs.RetryAnnotation: "1"},
+		},
+		Spec: obs.ObservatoryRunSpec{
+			Workflow: obs.Workflow{
+				Tasks: map[string]obs.TaskSpec{
+					"a": {Image: "busybox", Outputs: []obs.OutputSpec{{Name: "x"}}},
+					"b": {Image: "busybox", Dependencies: []string{"a"}},
+					"c": {Image: "busybox", Dependencies: []string{"b"}},
+					"d": {Image: "busybox", When: "false"},
+				},
+				Finally: map[string]obs.TaskSpec{"cleanup": {Image: "busybox"}},
+			},
+		},
+		Status: obs.ObservatoryRunStatus{
+			Phase: obs.PhaseFailed,
+			TaskStatuses: map[string]*obs.TaskStatus{
+				"a":       {State: obs.TaskSucceeded, JobName: "r-a", Outputs: map[string]string{"x": "1"}},
+				"b":       {State: obs.TaskFailed, Message: "Failed after 1 attempts"},
+				"c":       {State: obs.TaskSkipped, Reason: obs.ReasonDependencyNotMet},
+				"d":       {State: obs.TaskSkipped},
+				"cleanup": {State: obs.TaskSucceeded, JobName: "r-cleanup"},
+			},
+		},
+	}
+	failedJob := func() *batchv1.Job {
+		j := taskJob(run, "b")
+		j.Status.Failed = 1
+		return j
+	}
+	run.Status.TaskStatuses["b"].JobName = failedJob().Name
+	r := newTestReconciler(t, failedJob(), run)
+
+	res, got := reconcileRun(g, r, run)
+	g.Expect(res.Requeue).To(BeTrue())
+	g.Expect(got.Annotations).NotTo(HaveKey(obs.RetryAnnotation))
+	g.Expect(got.Status.RetryGeneration).To(Equal(int64(1)))
+	g.Expect(got.Status.LastRetryTime).NotTo(BeNil())
+	g.Expect(got.Status.Phase).To(Equal(obs.PhaseRunning))
+	g.Expect(got.Status.TaskStatuses["a"]).To(Equal(&obs.TaskStatus{State: obs.TaskSucceeded, JobName: "r-a", Outputs: map[string]string{"x": "1"}}))
+	g.Expect(got.Status.TaskStatuses["d"].State).To(Equal(obs.TaskSkipped))
+	for _, name := range []string{"b", "c", "cleanup"} {
+		g.Expect(got.Status.TaskStatuses[name]).To(Equal(&obs.TaskStatus{State: obs.TaskPending}))
+	}
+	err := r.Get(ctx, client.ObjectKeyFromObject(failedJob()), &batchv1.Job{})
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+	// A stale cache may still show the deleted Job; it must not fail b again.
+	g.Expect(r.Create(ctx, failedJob())).To(Succeed())
+	_, got = reconcileRun(g, r, run)
+	g.Expect(got.Status.TaskStatuses["b"].State).To(Equal(obs.TaskPending))
+	g.Expect(got.Status.TaskStatuses["b"].JobName).To(BeEmpty())
+
+	// Once it is gone, b is relaunched under the new retry generation.
+	g.Expect(r.Delete(ctx, failedJob())).To(Succeed())
+	_, got = reconcileRun(g, r, run)
+	g.Expect(got.Status.TaskStatuses["b"].JobName).To(Equal(failedJob().Name))
+	var job batchv1.Job
+	g.Expect(r.Get(ctx, client.ObjectKeyFromObject(failedJob()), &job)).To(Succeed())
+	g.Expect(job.Labels).To(HaveKeyWithValue(labelRetryGeneration, "1"))
+
+	// Retrying a run that has not failed only drops the annotation.
+	got.Annotations = map[string]string{obs.RetryAnnotation: "2"}
+	g.Expect(r.Update(ctx, got)).To(Succeed())
+	_, got = reconcileRun(g, r, run)
+	g.Expect(got.Annotations).NotTo(HaveKey(obs.RetryAnnotation))
+	g.Expect(got.Status.RetryGeneration).To(Equal(int64(1)))
+}
+
+func TestActiveDeadlineSeconds(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(activeDeadlineSeconds(nil)).To(BeNil())
+	g.Expect(*activeDeadlineSeconds(&metav1.Duration{Duration: 1500 * time.Millisecond})).To(Equal(int64(2)))
+}
+
+func TestOutputsFlowDownstream(t *testing.T) {
+	g := NewWithT(t)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "r-a-xyz", Namespace: "ns"},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodSucceeded,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name: taskContainerName,
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+					Message: "digest=sha256:abc\nundeclared=x\n",
+				}},
+			}},
+		},
+	}
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns"},
+		Spec: obs.ObservatoryRunSpec{Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{
+			"a": {Outputs: []obs.OutputSpec{{Name: "digest"}}},
+			"b": {
+				Dependencies: []string{"a"},
+				Command:      "push {{tasks.a.outputs.digest}}",
+				Env:          []corev1.EnvVar{{Name: "DIGEST", Value: "{{tasks.a.outputs.digest}}"}},
+			},
+		}}},
+	}
+	job := taskJob(run, "a")
+	job.Status.Succeeded = 1
+	pod.Labels = map[string]string{labelJobName: job.Name}
+
+	r := newTestReconciler(t, job, pod)
+	g.Expect(r.observeTasks(context.Background(), run)).To(Succeed())
+	g.Expect(run.Status.TaskStatuses["a"].Outputs).To(Equal(map[string]string{"digest": "sha256:abc"}))
+
+	resolved, err := resolveTask(run, run.Spec.Workflow.Tasks["b"])
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(resolved.Command).To(Equal("push sha256:abc"))
+	g.Expect(resolved.Env[0].Value).To(Equal("sha256:abc"))
+	g.Expect(run.Spec.Workflow.Tasks["b"].Command).To(ContainSubstring("{{"), "spec must not be mutated")
+
+	delete(run.Status.TaskStatuses["a"].Outputs, "digest")
+	_, err = resolveTask(run, run.Spec.Workflow.Tasks["b"])
+	g.Expect(err).To(MatchError(ContainSubstring("did not produce output 'digest'")))
+}
+
+func TestCommandForFileOutputs(t *testing.T) {
+	g := NewWithT(t)
+
+	cmd := commandFor(obs.TaskSpec{
+		Command: "make",
+		Outputs: []obs.OutputSpec{{Name: "digest", Path: "/tmp/digest"}, {Name: "inline"}},
+	})
+	g.Expect(cmd[:2]).To(Equal([]string{"/bin/sh", "-lc"}))
+	g.Expect(cmd[2]).To(HavePrefix("(\nmake\n)\n__rc=$?\n"))
+	g.Expect(cmd[2]).To(ContainSubstring(`printf '%s=%s\n' 'digest' "$(cat '/tmp/digest' 2>/dev/null)" >> /dev/termination-log`))
+	g.Expect(cmd[2]).NotTo(ContainSubstring("inline"))
+	g.Expect(cmd[2]).To(HaveSuffix("exit $__rc"))
+
+	args := commandFor(obs.TaskSpec{Args: []string{"tool", "--flag"}, Outputs: []obs.OutputSpec{{Name: "o", Path: "/o"}}})
+	g.Expect(args[len(args)-3:]).To(Equal([]string{"sh", "tool", "--flag"}))
+	g.Expect(commandFor(obs.TaskSpec{Args: []string{"tool"}})).To(Equal([]string{"tool"}))
+}
+
+func TestCommandForCollectsOutputsAfterEarlyExit(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh on PATH")
+	}
+	cases := map[string]struct {
+		script string
+		code   int
+	}{
+		"exit 0":         {script: "printf abc > \"$OUT\"\nexit 0\necho unreachable", code: 0},
+		"set -e failure": {script: "set -e\nprintf abc > \"$OUT\"\nfalse\necho unreachable", code: 1},
+		"exit code":      {script: "printf abc > \"$OUT\"\nexit 3", code: 3},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			dir := t.TempDir()
+			out, message := filepath.Join(dir, "out"), filepath.Join(dir, "message")
+
+			cmd := commandFor(obs.TaskSpec{Command: tc.script, Outputs: []obs.OutputSpec{{Name: "o", Path: out}}})
+			script := strings.ReplaceAll(cmd[2], corev1.TerminationMessagePathDefault, message)
+			sh := exec.Command("sh", "-c", script)
+			sh.Env = append(os.Environ(), "OUT="+out)
+			err := sh.Run()
+			code := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				code = exitErr.ExitCode()
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+			g.Expect(code).To(Equal(tc.code))
+			msg, err := os.ReadFile(message)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(parseOutputs(string(msg), []obs.OutputSpec{{Name: "o"}})).To(Equal(map[string]string{"o": "abc"}))
+		})
+	}
+}
+
+func TestParametersRecordedAndSubstituted(t *testing.T) {
+	g := NewWithT(t)
+	str := func(s string) *string { return &s }
+
+	run := &obs.ObservatoryRun{Spec: obs.ObservatoryRunSpec{
+		Parameters: []obs.ParameterSpec{{Name: "tag", Default: str("1.36")}, {Name: "env", Value: str("prod")}},
+		Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{
+			"a": {Image: "busybox:{{inputs.parameters.tag}}", Args: []string{"--env={{inputs.parameters.env}}"}},
+		}},
+	}}
+	g.Expect(recordParameters(run)).To(Succeed())
+	g.Expect(run.Status.Parameters).To(Equal(map[string]string{"tag": "1.36", "env": "prod"}))
+
+	// Recorded values win over later spec edits.
+	run.Spec.Parameters[1].Value = str("dev")
+	g.Expect(recordParameters(run)).To(Succeed())
+	resolved, err := resolveTask(run, run.Spec.Workflow.Tasks["a"])
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(resolved.Image).To(Equal("busybox:1.36"))
+	g.Expect(resolved.Args).To(Equal([]string{"--env=prod"}))
+}
+
+func TestEnsureJobForFanOutChild(t *testing.T) {
+	g := NewWithT(t)
+
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns"},
+		Spec: obs.ObservatoryRunSpec{Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{
+			"deploy": {WithItems: []string{"eu", "us"}, Command: "deploy --region {{item}}"},
+		}}},
+	}
+	r := newTestReconciler(t, run)
+	g.Expect(r.ensureJob(context.Background(), run, "deploy.1")).To(Succeed())
+
+	var job batchv1.Job
+	g.Expect(r.Get(context.Background(), client.ObjectKey{Name: attemptJobName(run, "deploy.1", 0), Namespace: "ns"}, &job)).To(Succeed())
+	g.Expect(job.Name).To(MatchRegexp(`^r-deploy-1-[0-9a-f]{8}$`))
+	g.Expect(job.Labels).To(HaveKeyWithValue(labelTask, "deploy.1"))
+	g.Expect(job.Spec.Template.Spec.Containers[0].Command).To(ContainElement("deploy --region us"))
+	g.Expect(run.Status.TaskStatuses["deploy.1"].JobName).To(Equal(job.Name))
+}
+
+func TestEnsureJobRejected(t *testing.T) {
+	for name, tc := range map[string]struct {
+		err   error
+		state obs.TaskState
+		msg   string
+	}{
+		"quota": {
+			err:   apierrors.NewForbidden(batchv1.Resource("jobs"), "j", errors.New("exceeded quota: compute")),
+			state: obs.TaskPending,
+			msg:   "check LimitRange and ResourceQuota",
+		},
+		"invalid": {
+			err: apierrors.NewInvalid(batchv1.SchemeGroupVersion.WithKind("Job").GroupKind(), "j", field.ErrorList{
+				field.Invalid(field.NewPath("metadata", "labels"), "x", "must be no more than 63 characters"),
+			}),
+			state: obs.TaskFailed,
+			msg:   "must be no more than 63 characters",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			run := &obs.ObservatoryRun{
+				ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns"},
+				Spec:       obs.ObservatoryRunSpec{Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{"a": {}}}},
+			}
+			r := newTestReconciler(t, run)
+			r.Client = interceptor.NewClient(r.Client.(client.WithWatch), interceptor.Funcs{
+				Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+					return tc.err
+				},
+			})
+
+			g.Expect(r.ensureJob(context.Background(), run, "a")).To(Succeed())
+			st := run.Status.TaskStatuses["a"]
+			g.Expect(st.State).To(Equal(tc.state))
+			g.Expect(st.JobName).To(BeEmpty())
+			g.Expect(st.Message).To(ContainSubstring(tc.msg))
+		})
+	}
+}
+
+// TestReconcileSchedulesPastSettledTasks covers reconciles that finish
+// tasks without creating a Job. No Job event follows, so the same reconcile
+// has to go on to whatever those tasks unblock.
+func TestReconcileSchedulesPastSettledTasks(t *testing.T) {
+	onFailure := func(dep string) map[string]obs.DependencyCondition {
+		return map[string]obs.DependencyCondition{dep: obs.DependencyOnFailure}
+	}
+	project := &obs.ObservatoryProject{
+		ObjectMeta: metav1.ObjectMeta{Name: "etl"},
+		Spec:       obs.ObservatoryProjectSpec{AllowedImages: []string{"ghcr.io/acme/*"}},
+	}
+	for name, tc := range map[string]struct {
+		workflow obs.Workflow
+		states   map[string]obs.TaskState
+		jobs     []string
+		phase    obs.Phase
+	}{
+		"when skip before an onFailure handler": {
+			workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{
+				"a":       {When: "false"},
+				"cleanup": {Dependencies: []string{"a"}, DependencyConditions: onFailure("a")},
+			}},
+			states: map[string]obs.TaskState{"a": obs.TaskSkipped, "cleanup": obs.TaskSkipped},
+			phase:  obs.PhaseSucceeded,
+		},
+		"chain of when skips": {
+			workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{
+				"a":       {When: "false"},
+				"b":       {When: "false", Dependencies: []string{"a"}},
+				"c":       {When: "false", Dependencies: []string{"b"}},
+				"cleanup": {Dependencies: []string{"c"}, DependencyConditions: onFailure("c")},
+			}},
+			states: map[string]obs.TaskState{"a": obs.TaskSkipped, "b": obs.TaskSkipped, "c": obs.TaskSkipped, "cleanup": obs.TaskSkipped},
+			phase:  obs.PhaseSucceeded,
+		},
+		"image rejected before finally": {
+			workflow: obs.Workflow{
+				Tasks:   map[string]obs.TaskSpec{"a": {Image: "docker.io/library/busybox"}},
+				Finally: map[string]obs.TaskSpec{"f": {Image: "ghcr.io/acme/etl:1"}},
+			},
+			states: map[string]obs.TaskState{"a": obs.TaskFailed, "f": obs.TaskPending},
+			jobs:   []string{"f"},
+			phase:  obs.PhaseRunning,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			run := &obs.ObservatoryRun{
+				ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns"},
+				Spec:       obs.ObservatoryRunSpec{Project: "etl", Workflow: tc.workflow},
+			}
+			r := newTestReconciler(t, project, run)
+
+			_, got := reconcileRun(g, r, run)
+			for task, state := range tc.states {
+				g.Expect(got.Status.TaskStatuses[task].State).To(Equal(state), "task %s", task)
+			}
+			var jobs batchv1.JobList
+			g.Expect(r.List(context.Background(), &jobs)).To(Succeed())
+			g.Expect(jobs.Items).To(HaveLen(len(tc.jobs)))
+			for _, task := range tc.jobs {
+				g.Expect(got.Status.TaskStatuses[task].JobName).To(Equal(attemptJobName(run, task, 0)))
+			}
+			g.Expect(got.Status.Phase).To(Equal(tc.phase))
+		})
+	}
+}
+
+func TestRunForPod(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	run := &obs.ObservatoryRun{ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns"}}
+	r := newTestReconciler(t, run)
+	g.Expect(r.ensureJob(ctx, run, "a")).To(Succeed())
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "r-a-xyz", Namespace: "ns", Labels: map[string]string{labelJobName: attemptJobName(run, "a", 0)}}}
+	g.Expect(r.runForPod(ctx, pod)).To(Equal([]reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(run)}}))
+
+	// Pods of Jobs the controller does not own are ignored.
+	pod.Labels[labelJobName] = "other"
+	g.Expect(r.runForPod(ctx, pod)).To(BeEmpty())
+}
+
+func TestWorkflowTemplateSnapshot(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	str := func(s string) *string { return &s }
+
+	tmpl := &obs.ObservatoryWorkflowTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "etl", Namespace: "ns"},
+		Spec: obs.WorkflowTemplateSpec{
+			Parameters: []obs.ParameterSpec{{Name: "env", Default: str("dev")}},
+			Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{
+				"a": {Command: "deploy {{inputs.parameters.env}}"},
+			}},
+		},
+	}
+	run := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns"},
+		Spec: obs.ObservatoryRunSpec{
+			WorkflowTemplateRef: &obs.WorkflowTemplateRef{Name: "etl"},
+			Parameters:          []obs.ParameterSpec{{Name: "env", Value: str("prod")}},
+		},
+	}
+	missing := &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "missing", Namespace: "ns"},
+		Spec:       obs.ObservatoryRunSpec{WorkflowTemplateRef: &obs.WorkflowTemplateRef{Name: "nope", ClusterScope: true}},
+	}
+	r := newTestReconciler(t, tmpl, run, missing)
+	key := client.ObjectKeyFromObject(run)
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	g.Expect(err).NotTo(HaveOccurred())
+	var job batchv1.Job
+	g.Expect(r.Get(ctx, client.ObjectKey{Name: attemptJobName(run, "a", 0), Namespace: "ns"}, &job)).To(Succeed())
+	g.Expect(job.Spec.Template.Spec.Containers[0].Command).To(ContainElement("deploy prod"))
+
+	var got obs.ObservatoryRun
+	g.Expect(r.Get(ctx, key, &got)).To(Succeed())
+	g.Expect(got.Status.Workflow.Tasks).To(HaveKey("a"))
+	g.Expect(got.Status.Parameters).To(Equal(map[string]string{"env": "prod"}))
+	g.Expect(got.Spec.Workflow.Tasks).To(BeEmpty(), "the resolved workflow lives in status only")
+
+	// Template edits do not reach a started run.
+	tmpl.Spec.Workflow.Tasks["b"] = obs.TaskSpec{}
+	g.Expect(r.Update(ctx, tmpl)).To(Succeed())
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(r.Get(ctx, key, &got)).To(Succeed())
+	g.Expect(got.Status.TaskStatuses).NotTo(HaveKey("b"))
+
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(missing)})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(r.Get(ctx, client.ObjectKeyFromObject(missing), &got)).To(Succeed())
+	g.Expect(got.Status.Phase).To(Equal(obs.PhaseFailed))
+	g.Expect(got.Status.Reason).To(Equal(obs.ReasonInvalidTemplate))
+	g.Expect(got.Status.Message).To(ContainSubstring(`ObservatoryClusterWorkflowTemplate "nope" not found`))
+}
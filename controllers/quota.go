@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// projectIndexKey indexes ObservatoryRuns by the project governing them.
+	projectIndexKey = "spec.project"
+	// queuePollInterval is how often a Queued run rechecks its project's quota.
+	queuePollInterval = 10 * time.Second
+)
+
+func indexRunProject(o client.Object) []string {
+	return []string{runProject(o.(*observatoryv1alpha1.ObservatoryRun))}
+}
+
+// runProject names the ObservatoryProject governing run.
+func runProject(run *observatoryv1alpha1.ObservatoryRun) string {
+	if run.Spec.Project == "" {
+		return observatoryv1alpha1.DefaultProject
+	}
+	return run.Spec.Project
+}
+
+// projectQuota is the ObservatoryProject governing a run, together with the
+// project's other runs that its limits are counted against. A nil
+// *projectQuota imposes no limits.
+type projectQuota struct {
+	project *observatoryv1alpha1.ObservatoryProject
+	others  []observatoryv1alpha1.ObservatoryRun
+	// missing is set when the run names a project that does not exist. Such
+	// a run is allowed nothing.
+	missing bool
+}
+
+// projectQuotaFor loads the ObservatoryProject named by the run's
+// spec.project, or the default project when it names none, and the
+// project's other runs. It returns nil for a run without a project when
+// there is no default project.
+func (r *ObservatoryRunReconciler) projectQuotaFor(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun) (*projectQuota, error) {
+	name := runProject(run)
+	// The webhook rejects projects that do not exist; one may still have
+	// been deleted since, or the webhook bypassed.
+	missing := &projectQuota{project: &observatoryv1alpha1.ObservatoryProject{}, missing: true}
+	missing.project.Name = name
+	if len(validation.IsDNS1123Subdomain(name)) > 0 {
+		return missing, nil
+	}
+	var project observatoryv1alpha1.ObservatoryProject
+	if err := r.Get(ctx, client.ObjectKey{Name: name}, &project); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		if run.Spec.Project == "" {
+			return nil, nil
+		}
+		return missing, nil
+	}
+	var runs observatoryv1alpha1.ObservatoryRunList
+	if err := r.List(ctx, &runs, client.MatchingFields{projectIndexKey: name}); err != nil {
+		return nil, err
+	}
+	q := &projectQuota{project: &project}
+	for _, other := range runs.Items {
+		if other.Namespace == run.Namespace && other.Name == run.Name {
+			continue
+		}
+		q.others = append(q.others, other)
+	}
+	return q, nil
+}
+
+// waitingToStart reports whether a run has not been admitted yet.
+func waitingToStart(run *observatoryv1alpha1.ObservatoryRun) bool {
+	return run.Status.Phase == "" || run.Status.Phase == observatoryv1alpha1.PhaseQueued
+}
+
+// mustQueue reports whether admitting run would exceed maxConcurrentRuns.
+// Waiting runs are admitted oldest first: a run only gets a slot if every
+// run queued before it fits too.
+func (q *projectQuota) mustQueue(run *observatoryv1alpha1.ObservatoryRun) bool {
+	if q == nil || q.project.Spec.MaxConcurrentRuns == nil {
+		return false
+	}
+	used := 0
+	for i := range q.others {
+		other := &q.others[i]
+		switch {
+		case !other.DeletionTimestamp.IsZero() || isTerminal(other.Status.Phase):
+		case !waitingToStart(other), queuedBefore(other, run):
+			used++
+		}
+	}
+	return used >= int(*q.project.Spec.MaxConcurrentRuns)
+}
+
+// queuedBefore orders waiting runs by creation time, then namespace and name.
+func queuedBefore(a, b *observatoryv1alpha1.ObservatoryRun) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+// taskRoom returns how many more task Jobs run may start under
+// maxConcurrentTasks, or -1 when there is no limit.
+func (q *projectQuota) taskRoom(run *observatoryv1alpha1.ObservatoryRun) int {
+	if q == nil || q.project.Spec.MaxConcurrentTasks == nil {
+		return -1
+	}
+	room := int(*q.project.Spec.MaxConcurrentTasks) - jobsInFlight(run)
+	for i := range q.others {
+		room -= jobsInFlight(&q.others[i])
+	}
+	return max(room, 0)
+}
+
+func (q *projectQuota) allowsNamespace(ns string) bool {
+	return q == nil || !q.missing && q.project.Spec.AllowsNamespace(ns)
+}
+
+func (q *projectQuota) allowsImage(image string) bool {
+	return q == nil || !q.missing && q.project.Spec.AllowsImage(image)
+}
+
+// notAllowed explains why the project does not allow what, e.g. an image.
+func (q *projectQuota) notAllowed(what string) string {
+	if q.missing {
+		return fmt.Sprintf("project %q does not exist", q.project.Name)
+	}
+	return fmt.Sprintf("%s is not allowed by project %q", what, q.project.Name)
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	obs "github.com/example/observatory-operator/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func projectRun(name string, age time.Duration, phase obs.Phase) *obs.ObservatoryRun {
+	return &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: "ns",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-age).Truncate(time.Second)),
+		},
+		Spec: obs.ObservatoryRunSpec{
+			Project:  "etl",
+			Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{"a": {Image: "ghcr.io/acme/etl:1"}, "b": {Image: "ghcr.io/acme/etl:1"}}},
+		},
+		Status: obs.ObservatoryRunStatus{Phase: phase},
+	}
+}
+
+func reconcileRun(g Gomega, r *ObservatoryRunReconciler, run *obs.ObservatoryRun) (ctrl.Result, *obs.ObservatoryRun) {
+	res, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(run)})
+	g.Expect(err).NotTo(HaveOccurred())
+	var got obs.ObservatoryRun
+	g.Expect(r.Get(context.Background(), client.ObjectKeyFromObject(run), &got)).To(Succeed())
+	return res, &got
+}
+
+func TestProjectRunQuotaQueuesFIFO(t *testing.T) {
+	g := NewWithT(t)
+
+	limit := int32(1)
+	project := &obs.ObservatoryProject{
+		ObjectMeta: metav1.ObjectMeta{Name: "etl"},
+		Spec:       obs.ObservatoryProjectSpec{MaxConcurrentRuns: &limit},
+	}
+	active := projectRun("active", 3*time.Minute, obs.PhaseRunning)
+	older := projectRun("older", 2*time.Minute, obs.PhaseQueued)
+	newer := projectRun("newer", time.Minute, "")
+	r := newTestReconciler(t, project, active, older, newer)
+
+	res, got := reconcileRun(g, r, newer)
+	g.Expect(got.Status.Phase).To(Equal(obs.PhaseQueued))
+	g.Expect(got.Status.Reason).To(Equal(obs.ReasonQueued))
+	g.Expect(res.RequeueAfter).To(Equal(queuePollInterval))
+
+	// Capacity frees up, but the older queued run is admitted first.
+	active.Status.Phase = obs.PhaseSucceeded
+	g.Expect(r.Status().Update(context.Background(), active)).To(Succeed())
+	_, got = reconcileRun(g, r, newer)
+	g.Expect(got.Status.Phase).To(Equal(obs.PhaseQueued))
+
+	_, got = reconcileRun(g, r, older)
+	g.Expect(got.Status.Phase).NotTo(Equal(obs.PhaseQueued))
+	g.Expect(got.Status.Reason).To(BeEmpty())
+	var jobs batchv1.JobList
+	g.Expect(r.List(context.Background(), &jobs, client.MatchingLabels{labelRun: "older"})).To(Succeed())
+	g.Expect(jobs.Items).To(HaveLen(2))
+}
+
+func TestProjectTaskQuotaAndPolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	limit := int32(3)
+	project := &obs.ObservatoryProject{
+		ObjectMeta: metav1.ObjectMeta{Name: "etl"},
+		Spec: obs.ObservatoryProjectSpec{
+			MaxConcurrentTasks: &limit,
+			AllowedImages:      []string{"ghcr.io/acme/*"},
+			AllowedNamespaces:  []string{"ns"},
+		},
+	}
+	busy := projectRun("busy", time.Minute, obs.PhaseRunning)
+	busy.Status.TaskStatuses = map[string]*obs.TaskStatus{
+		"a": {State: obs.TaskRunning, JobName: "busy-a"},
+		"b": {State: obs.TaskRunning, JobName: "busy-b"},
+	}
+	run := projectRun("r", 0, "")
+	run.Spec.Workflow.Tasks["c"] = obs.TaskSpec{Image: "docker.io/library/busybox", Priority: 1}
+	elsewhere := projectRun("elsewhere", 0, "")
+	elsewhere.Namespace = "other"
+	r := newTestReconciler(t, project, busy, run, elsewhere)
+
+	// One slot is left under the project's task limit. The disallowed image
+	// fails its task instead of starting a Job, and the slot goes to the next
+	// task in the same reconcile.
+	_, got := reconcileRun(g, r, run)
+	g.Expect(got.Status.TaskStatuses["c"].Reason).To(Equal(obs.ReasonNotAllowed))
+	var jobs batchv1.JobList
+	g.Expect(r.List(context.Background(), &jobs, client.MatchingLabels{labelRun: "r"})).To(Succeed())
+	g.Expect(jobs.Items).To(HaveLen(1))
+	g.Expect(got.Status.TaskStatuses["a"].JobName).To(Equal(attemptJobName(run, "a", 0)))
+
+	_, got = reconcileRun(g, r, elsewhere)
+	g.Expect(got.Status.Phase).To(Equal(obs.PhaseFailed))
+	g.Expect(got.Status.Reason).To(Equal(obs.ReasonNotAllowed))
+}
+
+func TestProjectMissingOrDefault(t *testing.T) {
+	g := NewWithT(t)
+
+	// A run naming a project that does not exist is allowed nothing.
+	missing := projectRun("missing", 0, "")
+	missing.Spec.Project = "gone"
+	r := newTestReconciler(t, missing)
+	_, got := reconcileRun(g, r, missing)
+	g.Expect(got.Status.Phase).To(Equal(obs.PhaseFailed))
+	g.Expect(got.Status.Reason).To(Equal(obs.ReasonNotAllowed))
+	g.Expect(got.Status.TaskStatuses["a"].Message).To(Equal(`project "gone" does not exist`))
+	var jobs batchv1.JobList
+	g.Expect(r.List(context.Background(), &jobs)).To(Succeed())
+	g.Expect(jobs.Items).To(BeEmpty())
+
+	// Runs without a project fall under the default project, with runs that
+	// name it.
+	limit := int32(1)
+	def := &obs.ObservatoryProject{
+		ObjectMeta: metav1.ObjectMeta{Name: obs.DefaultProject},
+		Spec:       obs.ObservatoryProjectSpec{MaxConcurrentRuns: &limit, AllowedImages: []string{"ghcr.io/acme/*"}},
+	}
+	active := projectRun("active", time.Minute, obs.PhaseRunning)
+	active.Spec.Project = obs.DefaultProject
+	run := projectRun("r", 0, "")
+	run.Spec.Project = ""
+	run.Spec.Workflow.Tasks["c"] = obs.TaskSpec{Image: "docker.io/library/busybox"}
+	r = newTestReconciler(t, def, active, run)
+	_, got = reconcileRun(g, r, run)
+	g.Expect(got.Status.Phase).To(Equal(obs.PhaseQueued))
+
+	active.Status.Phase = obs.PhaseSucceeded
+	g.Expect(r.Status().Update(context.Background(), active)).To(Succeed())
+	_, got = reconcileRun(g, r, run)
+	g.Expect(got.Status.TaskStatuses["c"].Reason).To(Equal(obs.ReasonNotAllowed))
+	g.Expect(got.Status.TaskStatuses["c"].Message).To(Equal(`image "docker.io/library/busybox" is not allowed by project "default"`))
+	g.Expect(got.Status.TaskStatuses["a"].JobName).NotTo(BeEmpty())
+}
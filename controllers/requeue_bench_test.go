@@ -0,0 +1,255 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	obs "github.com/example/observatory-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+const (
+	benchRuns   = 500
+	benchWindow = 30 * time.Second
+)
+
+// BenchmarkActiveRunsAPIRequests runs the ObservatoryRun controller of this
+// tree against envtest with 500 active runs, whose Jobs never finish since
+// envtest has no Job controller. Once they have all started it reports, per
+// second, the reconciles, the reads and writes the reconciler makes through
+// its client, and the HTTP requests the manager sends the API server. Needs
+// the envtest binaries:
+//
+//	KUBEBUILDER_ASSETS="$(bin/setup-envtest use -p path)" \
+//	  go test ./controllers -run '^$' -bench ActiveRunsAPIRequests -benchtime 1x
+//
+// hack/bench-requeue.sh runs it against an older revision as well, to
+// compare the two reconcilers.
+func BenchmarkActiveRunsAPIRequests(b *testing.B) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		b.Skip("KUBEBUILDER_ASSETS is not set; see `make envtest`")
+	}
+	for i := 0; i < b.N; i++ {
+		m := measureActiveRuns(b)
+		secs := benchWindow.Seconds()
+		b.ReportMetric(float64(m.requests)/secs, "requests/s")
+		b.ReportMetric(float64(m.reads)/secs, "reads/s")
+		b.ReportMetric(float64(m.writes)/secs, "writes/s")
+		b.ReportMetric(m.reconciles/secs, "reconciles/s")
+	}
+}
+
+// activeRunsLoad is what the manager did during the benchmark window.
+type activeRunsLoad struct {
+	requests, reads, writes int64
+	reconciles              float64
+}
+
+func measureActiveRuns(b *testing.B) activeRunsLoad {
+	b.Helper()
+	env := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
+		ErrorIfCRDPathMissing: true,
+	}
+	cfg, err := env.Start()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = env.Stop() }()
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		b.Fatal(err)
+	}
+	if err := obs.AddToScheme(scheme); err != nil {
+		b.Fatal(err)
+	}
+
+	// Only the manager's requests are counted, not the benchmark's own.
+	var requests atomic.Int64
+	counted := rest.CopyConfig(cfg)
+	counted.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return countingTransport{rt: rt, n: &requests}
+	}
+	mgr, err := ctrl.NewManager(counted, ctrl.Options{Scheme: scheme, Metrics: metricsserver.Options{BindAddress: "0"}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	cc := &countingClient{Client: mgr.GetClient()}
+	r := &ObservatoryRunReconciler{Client: cc, Scheme: scheme}
+	if err := r.SetupWithManager(mgr); err != nil {
+		b.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = mgr.Start(ctx) }()
+
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := c.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "bench"}}); err != nil {
+		b.Fatal(err)
+	}
+	if err := c.Create(ctx, &obs.ObservatoryProject{ObjectMeta: metav1.ObjectMeta{Name: "bench"}}); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < benchRuns; i++ {
+		run := &obs.ObservatoryRun{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("run-%d", i), Namespace: "bench"},
+			Spec: obs.ObservatoryRunSpec{
+				Project:  "bench",
+				Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{"a": {Image: "busybox"}}},
+			},
+		}
+		if err := c.Create(ctx, run); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := WaitForCondition(5*time.Minute, func() bool {
+		var jobs batchv1.JobList
+		return c.List(ctx, &jobs, client.InNamespace("bench")) == nil && len(jobs.Items) == benchRuns
+	}); err != nil {
+		b.Fatal("runs did not all start their Jobs: ", err)
+	}
+	// Let the status writes for the Job creations settle.
+	time.Sleep(5 * time.Second)
+
+	b.ResetTimer()
+	before := activeRunsLoad{requests.Load(), cc.reads.Load(), cc.writes.Load(), reconcileTotal(b)}
+	time.Sleep(benchWindow)
+	b.StopTimer()
+	return activeRunsLoad{
+		requests:   requests.Load() - before.requests,
+		reads:      cc.reads.Load() - before.reads,
+		writes:     cc.writes.Load() - before.writes,
+		reconciles: reconcileTotal(b) - before.reconciles,
+	}
+}
+
+// reconcileTotal sums controller-runtime's reconcile counter for the run
+// controller over all results.
+func reconcileTotal(b *testing.B) float64 {
+	b.Helper()
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		b.Fatal(err)
+	}
+	var total float64
+	for _, f := range families {
+		if f.GetName() != "controller_runtime_reconcile_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "controller" && l.GetValue() == "observatoryrun" {
+					total += m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return total
+}
+
+type countingTransport struct {
+	rt http.RoundTripper
+	n  *atomic.Int64
+}
+
+func (t countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.n.Add(1)
+	return t.rt.RoundTrip(req)
+}
+
+// countingClient counts the calls a reconciler makes through its client.
+// Reads are mostly served from the manager's cache; writes all reach the
+// API server.
+type countingClient struct {
+	client.Client
+	reads, writes atomic.Int64
+}
+
+func (c *countingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	c.reads.Add(1)
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *countingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	c.reads.Add(1)
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *countingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	c.writes.Add(1)
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *countingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	c.writes.Add(1)
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *countingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.writes.Add(1)
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *countingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	c.writes.Add(1)
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *countingClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	c.writes.Add(1)
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *countingClient) Status() client.SubResourceWriter {
+	return countingSubResource{SubResourceWriter: c.Client.Status(), writes: &c.writes}
+}
+
+func (c *countingClient) SubResource(name string) client.SubResourceClient {
+	return countingSubResource{SubResourceWriter: c.Client.SubResource(name), reader: c.Client.SubResource(name), writes: &c.writes}
+}
+
+type countingSubResource struct {
+	client.SubResourceWriter
+	reader client.SubResourceReader
+	writes *atomic.Int64
+}
+
+func (s countingSubResource) Get(ctx context.Context, obj, subResource client.Object, opts ...client.SubResourceGetOption) error {
+	return s.reader.Get(ctx, obj, subResource, opts...)
+}
+
+func (s countingSubResource) Create(ctx context.Context, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	s.writes.Add(1)
+	return s.SubResourceWriter.Create(ctx, obj, subResource, opts...)
+}
+
+func (s countingSubResource) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	s.writes.Add(1)
+	return s.SubResourceWriter.Update(ctx, obj, opts...)
+}
+
+func (s countingSubResource) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	s.writes.Add(1)
+	return s.SubResourceWriter.Patch(ctx, obj, patch, opts...)
+}
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	"github.com/example/observatory-operator/internal/metrics"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// labelTask and labelAttempt identify the task, and for tasks with a
+	// retryStrategy the attempt, a Job belongs to.
+	labelTask    = "obs.seventh/task"
+	labelAttempt = "obs.seventh/attempt"
+)
+
+// imagePullFailures are container waiting reasons after which a pod will
+// not start without outside help.
+var imagePullFailures = []string{"ImagePullBackOff", "InvalidImageName", "ErrImageNeverPull"}
+
+func jobAttempt(j *batchv1.Job) int32 {
+	n, _ := strconv.ParseInt(j.Labels[labelAttempt], 10, 32)
+	return int32(n)
+}
+
+// scheduledSpecFor returns the spec a task or fan-out child runs with.
+func scheduledSpecFor(run *observatoryv1alpha1.ObservatoryRun, name string) (observatoryv1alpha1.TaskSpec, bool) {
+	if parent, _, ok := parseChildTaskName(name); ok {
+		name = parent
+	}
+	return taskSpecFor(run, name)
+}
+
+// backingOff reports whether a task is waiting out the backoff before its
+// next attempt.
+func backingOff(st *observatoryv1alpha1.TaskStatus) bool {
+	return st != nil && st.NextAttemptAt != nil && time.Now().Before(st.NextAttemptAt.Time)
+}
+
+// attemptFailure describes why an attempt failed.
+type attemptFailure struct {
+	reason   string
+	message  string
+	exitCode *int32
+	// infra is set when the task's container did not exit on its own.
+	infra bool
+}
+
+// classifyAttempt reports whether an attempt Job has failed and why. An
+// attempt whose image cannot be pulled counts as failed even though its
+// Job is still active, since it would otherwise wait forever.
+func classifyAttempt(job *batchv1.Job, pods []corev1.Pod) (attemptFailure, bool) {
+	var evicted, exited *attemptFailure
+	for _, p := range pods {
+		if p.Status.Reason == "Evicted" {
+			evicted = &attemptFailure{reason: "Evicted", message: p.Status.Message, infra: true}
+		}
+		for _, cs := range p.Status.ContainerStatuses {
+			if cs.Name != taskContainerName {
+				continue
+			}
+			if w := cs.State.Waiting; w != nil && slices.Contains(imagePullFailures, w.Reason) {
+				return attemptFailure{reason: w.Reason, message: w.Message, infra: true}, true
+			}
+			if t := cs.State.Terminated; t != nil && t.ExitCode != 0 {
+				code := t.ExitCode
+				exited = &attemptFailure{reason: t.Reason, message: fmt.Sprintf("exited with code %d", code), exitCode: &code}
+				if exited.reason == "" {
+					exited.reason = "Error"
+				}
+			}
+		}
+	}
+	switch {
+	case jobDeadlineExceeded(job):
+		return attemptFailure{reason: "DeadlineExceeded", message: "timed out"}, true
+	case job.Status.Failed == 0:
+		return attemptFailure{}, false
+	case evicted != nil:
+		return *evicted, true
+	case exited != nil:
+		return *exited, true
+	}
+	return attemptFailure{reason: "PodLost", message: "pod failed without a container exit status", infra: true}, true
+}
+
+// shouldRetry applies a retryStrategy's retryOn policy to a failure.
+func shouldRetry(rs *observatoryv1alpha1.RetryStrategy, f attemptFailure) bool {
+	switch rs.RetryOn {
+	case observatoryv1alpha1.RetryOnError:
+		return f.infra
+	case observatoryv1alpha1.RetryOnExitCode:
+		return f.exitCode != nil && slices.Contains(rs.ExitCodes, *f.exitCode)
+	}
+	return true
+}
+
+// backoffFor is the delay before the given retry, counted from 0.
+func backoffFor(rs *observatoryv1alpha1.RetryStrategy, retry int32) time.Duration {
+	b := rs.Backoff
+	if b == nil || b.Duration == nil {
+		return 0
+	}
+	factor := time.Duration(2)
+	if b.Factor != nil {
+		factor = time.Duration(*b.Factor)
+	}
+	d := b.Duration.Duration
+	for i := int32(0); i < retry && factor > 1; i++ {
+		if d > math.MaxInt64/factor {
+			d = math.MaxInt64
+			break
+		}
+		d *= factor
+	}
+	if b.MaxDuration != nil && d > b.MaxDuration.Duration {
+		d = b.MaxDuration.Duration
+	}
+	return d
+}
+
+// observeAttempt handles the current attempt Job of a task with a
+// retryStrategy once it has failed: it records the attempt, then either
+// schedules the next one after its backoff or fails the task. done reports
+// whether the Job was handled here rather than by collectJobStatuses.
+func (r *ObservatoryRunReconciler) observeAttempt(ctx context.Context, st *observatoryv1alpha1.TaskStatus, job *batchv1.Job, pods []corev1.Pod, rs *observatoryv1alpha1.RetryStrategy) (done bool, err error) {
+	f, failed := classifyAttempt(job, pods)
+	if !failed {
+		return false, nil
+	}
+	if job.Status.Active > 0 {
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+	}
+	finishAttempt(st, job, f)
+	failAttempt(ctx, st, rs, f, job.Name)
+	return true, nil
+}
+
+// failAttempt moves a task whose current attempt, named name, failed with
+// f on to its next attempt after the backoff, or fails it when rs allows
+// no more.
+func failAttempt(ctx context.Context, st *observatoryv1alpha1.TaskStatus, rs *observatoryv1alpha1.RetryStrategy, f attemptFailure, name string) {
+	st.Reason = ""
+	if f.reason == "DeadlineExceeded" {
+		st.Reason = observatoryv1alpha1.ReasonTimedOut
+	}
+
+	if st.Attempt < rs.Limit && shouldRetry(rs, f) {
+		delay := backoffFor(rs, st.Attempt)
+		st.State = observatoryv1alpha1.TaskPending
+		st.JobName = ""
+		st.Attempt++
+		st.NextAttemptAt = &metav1.Time{Time: time.Now().Add(delay)}
+		st.Message = fmt.Sprintf("Attempt %d %s (%s); retry %d/%d in %s", st.Attempt, f.message, f.reason, st.Attempt, rs.Limit, delay)
+		metrics.WorkflowRetries.Inc()
+		log.FromContext(ctx).Info("Task attempt failed, retrying", "job", name, "reason", f.reason, "backoff", delay)
+		return
+	}
+	st.State = observatoryv1alpha1.TaskFailed
+	st.JobName = name
+	st.NextAttemptAt = nil
+	st.Message = fmt.Sprintf("Failed after %d attempts: %s (%s)", st.Attempt+1, f.message, f.reason)
+	metrics.JobCompleted.WithLabelValues(metrics.StatusFailure).Inc()
+	if f.reason == "DeadlineExceeded" {
+		metrics.JobTimeouts.Inc()
+	}
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	obs "github.com/example/observatory-operator/api/v1alpha1"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// attemptPod is the pod of an attempt Job whose task container is in state.
+func attemptPod(job string, state corev1.ContainerState) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: job + "-pod", Namespace: "ns", Labels: map[string]string{labelJobName: job}},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{Name: taskContainerName, State: state}},
+		},
+	}
+}
+
+func exited(code int32) corev1.ContainerState {
+	return corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: code, Reason: "Error"}}
+}
+
+func retryStrategyRun(rs *obs.RetryStrategy) *obs.ObservatoryRun {
+	return &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns", Finalizers: []string{finalizerName}},
+		Spec: obs.ObservatoryRunSpec{
+			Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{"a": {Image: "busybox", RetryStrategy: rs}}},
+		},
+	}
+}
+
+func failJob(g Gomega, r *ObservatoryRunReconciler, name string, state corev1.ContainerState) {
+	ctx := context.Background()
+	var job batchv1.Job
+	g.Expect(r.Get(ctx, client.ObjectKey{Name: name, Namespace: "ns"}, &job)).To(Succeed())
+	job.Status.Failed = 1
+	g.Expect(r.Status().Update(ctx, &job)).To(Succeed())
+	g.Expect(r.Create(ctx, attemptPod(name, state))).To(Succeed())
+}
+
+func TestRetryStrategyAttempts(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	run := retryStrategyRun(&obs.RetryStrategy{Limit: 2, RetryOn: obs.RetryOnExitCode, ExitCodes: []int32{75}})
+	r := newTestReconciler(t, run)
+
+	first, second := attemptJobName(run, "a", 0), attemptJobName(run, "a", 1)
+	_, got := reconcileRun(g, r, run)
+	var job batchv1.Job
+	g.Expect(r.Get(ctx, client.ObjectKey{Name: first, Namespace: "ns"}, &job)).To(Succeed())
+	g.Expect(*job.Spec.BackoffLimit).To(BeZero())
+	g.Expect(job.Labels).To(HaveKeyWithValue(labelTask, "a"))
+	g.Expect(job.Labels).To(HaveKeyWithValue(labelAttempt, "0"))
+
+	// A retryable exit code starts a fresh attempt Job.
+	failJob(g, r, first, exited(75))
+	_, got = reconcileRun(g, r, run)
+	st := got.Status.TaskStatuses["a"]
+	g.Expect(st.Attempt).To(Equal(int32(1)))
+	g.Expect(st.Attempts).To(HaveLen(1))
+	g.Expect(st.Attempts[0]).To(MatchFields(IgnoreExtras, Fields{
+		"Attempt": BeZero(), "JobName": Equal(first), "PodName": Equal(first + "-pod"),
+		"State": Equal(obs.TaskFailed), "Reason": Equal("Error"), "ExitCode": PointTo(Equal(int32(75))),
+		"Message": Equal("exited with code 75"), "FinishedAt": Not(BeNil()),
+	}))
+	g.Expect(got.Status.Phase).NotTo(Equal(obs.PhaseFailed))
+	g.Expect(r.Get(ctx, client.ObjectKey{Name: second, Namespace: "ns"}, &job)).To(Succeed())
+	g.Expect(job.Labels).To(HaveKeyWithValue(labelAttempt, "1"))
+
+	// Any other exit code fails the task, even with attempts left.
+	failJob(g, r, second, exited(1))
+	_, got = reconcileRun(g, r, run)
+	st = got.Status.TaskStatuses["a"]
+	g.Expect(st.State).To(Equal(obs.TaskFailed))
+	g.Expect(st.JobName).To(Equal(second))
+	g.Expect(st.Attempts).To(HaveLen(2))
+	g.Expect(st.Message).To(Equal("Failed after 2 attempts: exited with code 1 (Error)"))
+	g.Expect(got.Status.Phase).To(Equal(obs.PhaseFailed))
+
+	// Reconciling again does not record the final attempt twice.
+	_, got = reconcileRun(g, r, run)
+	g.Expect(got.Status.TaskStatuses["a"].Attempts).To(HaveLen(2))
+}
+
+func TestRetryStrategyBackoff(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	run := retryStrategyRun(&obs.RetryStrategy{
+		Limit:   3,
+		RetryOn: obs.RetryOnError,
+		Backoff: &obs.Backoff{Duration: &metav1.Duration{Duration: time.Hour}},
+	})
+	r := newTestReconciler(t, run)
+	reconcileRun(g, r, run)
+
+	// An image that cannot be pulled is an error worth retrying; the stuck
+	// Job is deleted and the next attempt waits out the backoff.
+	g.Expect(r.Create(ctx, attemptPod(attemptJobName(run, "a", 0), corev1.ContainerState{
+		Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "back-off pulling image"},
+	}))).To(Succeed())
+	var job batchv1.Job
+	g.Expect(r.Get(ctx, client.ObjectKey{Name: attemptJobName(run, "a", 0), Namespace: "ns"}, &job)).To(Succeed())
+	job.Status.Active = 1
+	g.Expect(r.Status().Update(ctx, &job)).To(Succeed())
+
+	res, got := reconcileRun(g, r, run)
+	g.Expect(res.RequeueAfter).To(BeNumerically("~", time.Hour, time.Minute))
+	st := got.Status.TaskStatuses["a"]
+	g.Expect(st.State).To(Equal(obs.TaskPending))
+	g.Expect(st.Attempt).To(Equal(int32(1)))
+	g.Expect(st.Attempts[0].Reason).To(Equal("ImagePullBackOff"))
+	g.Expect(st.NextAttemptAt.Time).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+	var jobs batchv1.JobList
+	g.Expect(r.List(ctx, &jobs, client.MatchingLabels{labelRun: "r"})).To(Succeed())
+	g.Expect(jobs.Items).To(BeEmpty())
+}
+
+func TestClassifyAttempt(t *testing.T) {
+	g := NewWithT(t)
+
+	failed := &batchv1.Job{Status: batchv1.JobStatus{Failed: 1}}
+	f, ok := classifyAttempt(failed, []corev1.Pod{*attemptPod("j", exited(137))})
+	g.Expect(ok).To(BeTrue())
+	g.Expect(*f.exitCode).To(Equal(int32(137)))
+	g.Expect(f.infra).To(BeFalse())
+
+	evicted := attemptPod("j", exited(137))
+	evicted.Status.Reason = "Evicted"
+	f, ok = classifyAttempt(failed, []corev1.Pod{*evicted})
+	g.Expect(ok).To(BeTrue())
+	g.Expect(f.reason).To(Equal("Evicted"))
+	g.Expect(f.infra).To(BeTrue())
+
+	f, ok = classifyAttempt(failed, nil)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(f.infra).To(BeTrue())
+
+	deadline := &batchv1.Job{Status: batchv1.JobStatus{Failed: 1, Conditions: []batchv1.JobCondition{{
+		Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "DeadlineExceeded",
+	}}}}
+	f, _ = classifyAttempt(deadline, []corev1.Pod{*attemptPod("j", exited(143))})
+	g.Expect(f.reason).To(Equal("DeadlineExceeded"))
+	g.Expect(f.infra).To(BeFalse())
+
+	running := &batchv1.Job{Status: batchv1.JobStatus{Active: 1}}
+	_, ok = classifyAttempt(running, []corev1.Pod{*attemptPod("j", corev1.ContainerState{Running: &corev1.ContainerStateRunning{}})})
+	g.Expect(ok).To(BeFalse())
+}
+
+func TestBackoffFor(t *testing.T) {
+	g := NewWithT(t)
+
+	three := int32(3)
+	rs := &obs.RetryStrategy{Backoff: &obs.Backoff{
+		Duration:    &metav1.Duration{Duration: 10 * time.Second},
+		Factor:      &three,
+		MaxDuration: &metav1.Duration{Duration: time.Minute},
+	}}
+	g.Expect(backoffFor(rs, 0)).To(Equal(10 * time.Second))
+	g.Expect(backoffFor(rs, 1)).To(Equal(30 * time.Second))
+	g.Expect(backoffFor(rs, 2)).To(Equal(time.Minute))
+	g.Expect(backoffFor(rs, 100)).To(Equal(time.Minute))
+
+	rs.Backoff.Factor, rs.Backoff.MaxDuration = nil, nil
+	g.Expect(backoffFor(rs, 2)).To(Equal(40 * time.Second))
+	g.Expect(backoffFor(&obs.RetryStrategy{}, 5)).To(BeZero())
+}
@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	"github.com/example/observatory-operator/internal/templating"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	taskContainerName = "task"
+	// labelJobName is set by the Job controller on the pods it creates.
+	labelJobName = "job-name"
+)
+
+// recordParameters resolves the run's parameters into status the first time
+// the run is reconciled. Later spec edits do not change a started run.
+func recordParameters(run *observatoryv1alpha1.ObservatoryRun) error {
+	if run.Status.Parameters != nil || len(run.Spec.Parameters) == 0 {
+		return nil
+	}
+	params, err := observatoryv1alpha1.ResolveParameters(run.Spec.Parameters)
+	if err != nil {
+		return err
+	}
+	run.Status.Parameters = params
+	return nil
+}
+
+// resolveTask returns a copy of the task spec with every {{...}} placeholder
+// in Image, Command, Args and Env values replaced by its value. The webhook
+// has already checked the references, so an error here means an upstream
+// task finished without producing a declared output.
+func resolveTask(run *observatoryv1alpha1.ObservatoryRun, spec observatoryv1alpha1.TaskSpec) (observatoryv1alpha1.TaskSpec, error) {
+	return expandTask(spec, resolverFor(run))
+}
+
+// resolveScheduled resolves the spec behind a name returned by
+// computeFrontier: a task, or a fan-out child whose {{item}} is taken from
+// its parent's items.
+func resolveScheduled(run *observatoryv1alpha1.ObservatoryRun, name string) (observatoryv1alpha1.TaskSpec, error) {
+	parent, index, ok := parseChildTaskName(name)
+	if !ok {
+		spec, _ := taskSpecFor(run, name)
+		return resolveTask(run, spec)
+	}
+	spec, _ := taskSpecFor(run, parent)
+	items, err := expandItems(run, spec)
+	if err != nil {
+		return spec, err
+	}
+	if index >= len(items) {
+		return spec, fmt.Errorf("item %d out of range (%d items)", index, len(items))
+	}
+	resolve := resolverFor(run)
+	return expandTask(spec, func(expr string) (string, error) {
+		if expr == templating.ItemRef {
+			return items[index], nil
+		}
+		return resolve(expr)
+	})
+}
+
+// expandItems returns the items a fan-out task runs over.
+func expandItems(run *observatoryv1alpha1.ObservatoryRun, spec observatoryv1alpha1.TaskSpec) ([]string, error) {
+	if len(spec.WithItems) > 0 {
+		return spec.WithItems, nil
+	}
+	raw, err := templating.Expand(spec.WithParam, resolverFor(run))
+	if err != nil {
+		return nil, err
+	}
+	var elems []json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &elems); err != nil {
+		return nil, fmt.Errorf("withParam is not a JSON list: %w", err)
+	}
+	items := make([]string, len(elems))
+	for i, e := range elems {
+		if err := json.Unmarshal(e, &items[i]); err == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, e); err != nil {
+			return nil, err
+		}
+		items[i] = buf.String()
+	}
+	return items, nil
+}
+
+func expandTask(spec observatoryv1alpha1.TaskSpec, resolve func(expr string) (string, error)) (observatoryv1alpha1.TaskSpec, error) {
+	out := *spec.DeepCopy()
+
+	var err error
+	if out.Image, err = templating.Expand(out.Image, resolve); err != nil {
+		return out, fmt.Errorf("image: %w", err)
+	}
+	if out.Command, err = templating.Expand(out.Command, resolve); err != nil {
+		return out, fmt.Errorf("command: %w", err)
+	}
+	for i := range out.Args {
+		if out.Args[i], err = templating.Expand(out.Args[i], resolve); err != nil {
+			return out, fmt.Errorf("args[%d]: %w", i, err)
+		}
+	}
+	for i := range out.Env {
+		if out.Env[i].Value, err = templating.Expand(out.Env[i].Value, resolve); err != nil {
+			return out, fmt.Errorf("env[%s]: %w", out.Env[i].Name, err)
+		}
+	}
+	if h := out.HTTP; h != nil {
+		if h.URL, err = templating.Expand(h.URL, resolve); err != nil {
+			return out, fmt.Errorf("http.url: %w", err)
+		}
+		if h.Body, err = templating.Expand(h.Body, resolve); err != nil {
+			return out, fmt.Errorf("http.body: %w", err)
+		}
+		for i := range h.Headers {
+			if h.Headers[i].Value, err = templating.Expand(h.Headers[i].Value, resolve); err != nil {
+				return out, fmt.Errorf("http.headers[%s]: %w", h.Headers[i].Name, err)
+			}
+		}
+	}
+	return out, nil
+}
+
+// evalWhen evaluates a task's `when` condition against the run's
+// parameters and upstream outputs.
+func evalWhen(run *observatoryv1alpha1.ObservatoryRun, spec observatoryv1alpha1.TaskSpec) (bool, error) {
+	cond, err := templating.ParseCondition(spec.When)
+	if err != nil {
+		return false, err
+	}
+	return cond.Eval(resolverFor(run))
+}
+
+// resolverFor resolves placeholder expressions from the run's recorded
+// parameters, the outputs of its succeeded tasks and, once the workflow
+// tasks have finished, the workflow phase.
+func resolverFor(run *observatoryv1alpha1.ObservatoryRun) func(expr string) (string, error) {
+	return func(expr string) (string, error) {
+		if expr == templating.RunPhaseRef {
+			phase := workflowPhase(run)
+			if !isTerminal(phase) {
+				return "", fmt.Errorf("{{%s}}: workflow tasks have not finished", expr)
+			}
+			return string(phase), nil
+		}
+		if name, ok, err := templating.ParseParameterRef(expr); ok {
+			if err != nil {
+				return "", err
+			}
+			v, found := run.Status.Parameters[name]
+			if !found {
+				return "", fmt.Errorf("{{%s}}: parameter '%s' has no value", expr, name)
+			}
+			return v, nil
+		}
+		ref, ok, err := templating.ParseTaskOutputRef(expr)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("unsupported reference {{%s}}", expr)
+		}
+		st := run.Status.TaskStatuses[ref.Task]
+		if st == nil || st.State != observatoryv1alpha1.TaskSucceeded {
+			return "", fmt.Errorf("{{%s}}: task '%s' has not succeeded", expr, ref.Task)
+		}
+		v, ok := st.Outputs[ref.Output]
+		if !ok {
+			return "", fmt.Errorf("{{%s}}: task '%s' did not produce output '%s'", expr, ref.Task, ref.Output)
+		}
+		return v, nil
+	}
+}
+
+// outputCollectionScript copies file-backed outputs into the termination
+// message as name=value lines. It returns "" when no output has a Path.
+func outputCollectionScript(outputs []observatoryv1alpha1.OutputSpec) string {
+	var lines []string
+	for _, o := range outputs {
+		if o.Path == "" {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf(`printf '%%s=%%s\n' %s "$(cat %s 2>/dev/null)" >> %s`,
+			shellQuote(o.Name), shellQuote(o.Path), corev1.TerminationMessagePathDefault))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// parseOutputs reads name=value lines from a termination message, keeping
+// only the outputs the task declared.
+func parseOutputs(message string, declared []observatoryv1alpha1.OutputSpec) map[string]string {
+	want := map[string]bool{}
+	for _, o := range declared {
+		want[o.Name] = true
+	}
+	out := map[string]string{}
+	for _, line := range strings.Split(message, "\n") {
+		k, v, ok := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		if !ok || !want[k] {
+			continue
+		}
+		out[k] = strings.TrimRight(v, " \t\r")
+	}
+	return out
+}
+
+// readOutputs fetches the termination message of the succeeded pod of a
+// task Job and parses the task's declared outputs from it.
+func (r *ObservatoryRunReconciler) readOutputs(ctx context.Context, job *batchv1.Job, declared []observatoryv1alpha1.OutputSpec) (map[string]string, error) {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(job.Namespace), client.MatchingLabels{labelJobName: job.Name}); err != nil {
+		return nil, err
+	}
+	for _, p := range pods.Items {
+		if p.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		for _, cs := range p.Status.ContainerStatuses {
+			if cs.Name == taskContainerName && cs.State.Terminated != nil {
+				return parseOutputs(cs.State.Terminated.Message, declared), nil
+			}
+		}
+	}
+	return map[string]string{}, nil
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"time"
+
+	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	"github.com/example/observatory-operator/internal/tracing"
+	"go.opentelemetry.io/otel/codes"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// tracingEnabled reports whether the run asked for OpenTelemetry traces.
+func tracingEnabled(run *observatoryv1alpha1.ObservatoryRun) bool {
+	o := run.Spec.Observability
+	return o != nil && o.OTel != nil && o.OTel.Enabled
+}
+
+// traceparentFor is the W3C trace context handed to an attempt of a task
+// so task code can attach child spans to the attempt's span. Each attempt,
+// and each retry of the run, gets a span of its own.
+func traceparentFor(run *observatoryv1alpha1.ObservatoryRun, task string, attempt int32) string {
+	uid := string(run.UID)
+	return tracing.Traceparent(tracing.TraceIDFor(uid), tracing.SpanIDFor(uid, run.Status.RetryGeneration, task, attempt))
+}
+
+func spanAttributes(run *observatoryv1alpha1.ObservatoryRun) map[string]string {
+	attrs := map[string]string{}
+	for k, v := range run.Spec.Observability.OTel.Attributes {
+		attrs[k] = v
+	}
+	attrs["observatory.run"] = run.Name
+	attrs["observatory.namespace"] = run.Namespace
+	if run.Spec.Project != "" {
+		attrs["observatory.project"] = run.Spec.Project
+	}
+	return attrs
+}
+
+// runSpan is the root span of the run's current retry generation, from its
+// creation or last retry to its completion.
+func runSpan(run *observatoryv1alpha1.ObservatoryRun, now time.Time) tracing.Span {
+	uid := string(run.UID)
+	s := tracing.Span{
+		TraceID:    tracing.TraceIDFor(uid),
+		SpanID:     tracing.SpanIDFor(uid, run.Status.RetryGeneration, "", 0),
+		Name:       run.Name,
+		Start:      run.CreationTimestamp.Time,
+		End:        now,
+		Attributes: spanAttributes(run),
+	}
+	if run.Status.RetryGeneration > 0 && run.Status.LastRetryTime != nil {
+		s.Start = run.Status.LastRetryTime.Time
+	}
+	if run.Status.CompletionTime != nil {
+		s.End = run.Status.CompletionTime.Time
+	}
+	s.Attributes["observatory.phase"] = string(run.Status.Phase)
+	switch run.Status.Phase {
+	case observatoryv1alpha1.PhaseSucceeded:
+		s.Status = codes.Ok
+	case observatoryv1alpha1.PhaseFailed:
+		s.Status = codes.Error
+	}
+	return s
+}
+
+// taskSpan is the span of the task attempt run by job, timed from the Job
+// and parented to the root span of the retry generation it was created in.
+func taskSpan(run *observatoryv1alpha1.ObservatoryRun, task string, job *batchv1.Job, st *observatoryv1alpha1.TaskStatus, now time.Time) tracing.Span {
+	uid := string(run.UID)
+	generation := jobRetryGeneration(job)
+	s := tracing.Span{
+		TraceID:      tracing.TraceIDFor(uid),
+		SpanID:       tracing.SpanIDFor(uid, generation, task, jobAttempt(job)),
+		ParentSpanID: tracing.SpanIDFor(uid, generation, "", 0),
+		Name:         task,
+		Start:        job.CreationTimestamp.Time,
+		End:          now,
+		Attributes:   spanAttributes(run),
+	}
+	if job.Status.StartTime != nil {
+		s.Start = job.Status.StartTime.Time
+	}
+	if job.Status.CompletionTime != nil {
+		s.End = job.Status.CompletionTime.Time
+	} else {
+		for _, c := range job.Status.Conditions {
+			if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+				s.End = c.LastTransitionTime.Time
+			}
+		}
+	}
+	s.Attributes["observatory.job"] = job.Name
+	setTaskOutcome(&s, task, st)
+	return s
+}
+
+// attemptSpan is the span of an attempt of a task that runs no Job, such as
+// an HTTP call or an approval, timed by the controller and parented to the
+// root span of the current retry generation. Its ID is the one
+// traceparentFor handed to the attempt.
+func attemptSpan(run *observatoryv1alpha1.ObservatoryRun, task string, attempt int32, st *observatoryv1alpha1.TaskStatus, start, end time.Time) tracing.Span {
+	uid := string(run.UID)
+	s := tracing.Span{
+		TraceID:      tracing.TraceIDFor(uid),
+		SpanID:       tracing.SpanIDFor(uid, run.Status.RetryGeneration, task, attempt),
+		ParentSpanID: tracing.SpanIDFor(uid, run.Status.RetryGeneration, "", 0),
+		Name:         task,
+		Start:        start,
+		End:          end,
+		Attributes:   spanAttributes(run),
+	}
+	setTaskOutcome(&s, task, st)
+	return s
+}
+
+func setTaskOutcome(s *tracing.Span, task string, st *observatoryv1alpha1.TaskStatus) {
+	s.Attributes["observatory.task"] = task
+	s.Attributes["observatory.task.state"] = string(st.State)
+	switch st.State {
+	case observatoryv1alpha1.TaskSucceeded:
+		s.Status = codes.Ok
+	case observatoryv1alpha1.TaskFailed:
+		s.Status = codes.Error
+		s.StatusMessage = st.Message
+	}
+}
+
+// recordSpans hands spans to the tracer, which exports them in the
+// background. A span recorded recently is not recorded again: a reconcile
+// that reads a stale cache sees the same transition once more.
+func (r *ObservatoryRunReconciler) recordSpans(spans []tracing.Span) {
+	if r.Tracer == nil {
+		return
+	}
+	now := time.Now()
+	for _, s := range spans {
+		if !r.traced.allow(s.TraceID.String()+"/"+s.SpanID.String(), now) {
+			continue
+		}
+		tracing.Record(r.Tracer, s)
+	}
+}
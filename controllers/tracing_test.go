@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	obs "github.com/example/observatory-operator/api/v1alpha1"
+	"github.com/example/observatory-operator/internal/tracing"
+	. "github.com/onsi/gomega"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// collector is a stand-in OTLP/HTTP collector; it returns the spans
+// received so far.
+func collector(t *testing.T, g Gomega) (*httptest.Server, func() []*tracepb.Span) {
+	var mu sync.Mutex
+	var spans []*tracepb.Span
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		g.Expect(err).NotTo(HaveOccurred())
+		var req coltracepb.ExportTraceServiceRequest
+		g.Expect(proto.Unmarshal(body, &req)).To(Succeed())
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []*tracepb.Span {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*tracepb.Span(nil), spans...)
+	}
+}
+
+func tracedRun() *obs.ObservatoryRun {
+	return &obs.ObservatoryRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "r", Namespace: "ns", UID: "run-uid"},
+		Spec: obs.ObservatoryRunSpec{
+			Observability: &obs.ObservabilitySpec{OTel: &obs.OTelSpec{
+				Enabled:    true,
+				Attributes: map[string]string{"team": "infra"},
+			}},
+			Workflow: obs.Workflow{Tasks: map[string]obs.TaskSpec{"a": {}}},
+		},
+	}
+}
+
+func TestTaskSpansExported(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	srv, received := collector(t, g)
+
+	run := tracedRun()
+	started := metav1.NewTime(time.Unix(1000, 0))
+	completed := metav1.NewTime(time.Unix(1005, 0))
+	job := taskJob(run, "a")
+	job.Status = batchv1.JobStatus{Succeeded: 1, StartTime: &started, CompletionTime: &completed}
+
+	r := newTestReconciler(t, job)
+	tp, err := tracing.NewProvider(srv.URL)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer tp.Shutdown(ctx)
+	r.Tracer = tp
+
+	g.Expect(r.observeTasks(ctx, run)).To(Succeed())
+	g.Expect(tp.ForceFlush(ctx)).To(Succeed())
+	spans := received()
+	g.Expect(spans).To(HaveLen(1))
+	traceID, spanID := tracing.TraceIDFor("run-uid"), tracing.SpanIDFor("run-uid", 0, "a", 0)
+	g.Expect(spans[0].Name).To(Equal("a"))
+	g.Expect(spans[0].TraceId).To(Equal(traceID[:]))
+	g.Expect(spans[0].SpanId).To(Equal(spanID[:]))
+	root := tracing.SpanIDFor("run-uid", 0, "", 0)
+	g.Expect(spans[0].ParentSpanId).To(Equal(root[:]))
+	g.Expect(spans[0].StartTimeUnixNano).To(Equal(uint64(1000000000000)))
+	g.Expect(spans[0].EndTimeUnixNano).To(Equal(uint64(1005000000000)))
+	g.Expect(spans[0].Status.GetCode()).To(Equal(tracepb.Status_STATUS_CODE_OK))
+	g.Expect(spans[0].Attributes).To(ContainElement(HaveField("Key", "team")))
+
+	// A second observation of the same completed Job must not re-export.
+	g.Expect(r.observeTasks(ctx, run)).To(Succeed())
+	g.Expect(tp.ForceFlush(ctx)).To(Succeed())
+	g.Expect(received()).To(HaveLen(1))
+
+	env := envFor(run, "a", 0, run.Spec.Workflow.Tasks["a"])
+	g.Expect(env).To(HaveLen(1))
+	g.Expect(env[0].Name).To(Equal("TRACEPARENT"))
+	g.Expect(env[0].Value).To(Equal(tracing.Traceparent(traceID, spanID)))
+}
+
+func TestRunSpanRecordedOnce(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	srv, received := collector(t, g)
+
+	run := tracedRun()
+	run.CreationTimestamp = metav1.NewTime(time.Unix(1000, 0))
+	run.Status.Phase = obs.PhaseSucceeded
+	run.Status.CompletionTime = &metav1.Time{Time: time.Unix(1060, 0)}
+
+	r := newTestReconciler(t)
+	tp, err := tracing.NewProvider(srv.URL)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer tp.Shutdown(ctx)
+	r.Tracer = tp
+
+	// As when a reconcile reads the run from a cache that has not caught up
+	// with its terminal phase yet.
+	r.recordSpans([]tracing.Span{runSpan(run, time.Unix(2000, 0))})
+	r.recordSpans([]tracing.Span{runSpan(run, time.Unix(3000, 0))})
+	g.Expect(tp.ForceFlush(ctx)).To(Succeed())
+	spans := received()
+	g.Expect(spans).To(HaveLen(1))
+	g.Expect(spans[0].EndTimeUnixNano).To(Equal(uint64(1060000000000)))
+}
+
+func TestTraceparentPerAttempt(t *testing.T) {
+	g := NewWithT(t)
+	run := tracedRun()
+	spec := run.Spec.Workflow.Tasks["a"]
+
+	seen := map[string]bool{}
+	for _, generation := range []int64{0, 1} {
+		run.Status.RetryGeneration = generation
+		for _, attempt := range []int32{0, 1} {
+			tp := envFor(run, "a", attempt, spec)[0].Value
+			g.Expect(seen).NotTo(HaveKey(tp), "generation %d attempt %d", generation, attempt)
+			seen[tp] = true
+		}
+	}
+}
+
+func TestNonJobTaskSpansExported(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	srv, received := collector(t, g)
+	tp, err := tracing.NewProvider(srv.URL)
+	g.Expect(err).NotTo(HaveOccurred())
+	defer tp.Shutdown(ctx)
+	otel := &obs.ObservabilitySpec{OTel: &obs.OTelSpec{Enabled: true}}
+	spansOf := func(task string) []*tracepb.Span {
+		g.Expect(tp.ForceFlush(ctx)).To(Succeed())
+		var out []*tracepb.Span
+		for _, s := range received() {
+			if s.Name == task {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+
+	// Each call of an http task is exported under the traceparent it sent.
+	var mu sync.Mutex
+	var sent []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		sent = append(sent, req.Header.Get("traceparent"))
+		first := len(sent) == 1
+		mu.Unlock()
+		if first {
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer api.Close()
+	run := httpRun(obs.TaskSpec{
+		HTTP:          &obs.HTTPSpec{URL: api.URL},
+		RetryStrategy: &obs.RetryStrategy{Limit: 1, RetryOn: obs.RetryOnError},
+	})
+	run.Spec.Observability = otel
+	r := newHTTPReconciler(t, run)
+	r.Tracer = tp
+	got := reconcileHTTP(g, r, run, 2)
+	g.Expect(got.Status.TaskStatuses["call"].State).To(Equal(obs.TaskSucceeded))
+
+	traceID, root := tracing.TraceIDFor("r-uid"), tracing.SpanIDFor("r-uid", 0, "", 0)
+	spans := spansOf("call")
+	g.Expect(spans).To(HaveLen(2))
+	g.Expect(sent).To(HaveLen(2))
+	for i, want := range []tracepb.Status_StatusCode{tracepb.Status_STATUS_CODE_ERROR, tracepb.Status_STATUS_CODE_OK} {
+		spanID := tracing.SpanIDFor("r-uid", 0, "call", int32(i))
+		g.Expect(spans[i].SpanId).To(Equal(spanID[:]))
+		g.Expect(spans[i].ParentSpanId).To(Equal(root[:]))
+		g.Expect(spans[i].Status.GetCode()).To(Equal(want))
+		g.Expect(sent[i]).To(Equal(tracing.Traceparent(traceID, spanID)))
+	}
+
+	// An approval task spans the wait for its decision.
+	run = approvalRun(obs.TaskSpec{})
+	run.UID = "a-uid"
+	run.Spec.Observability = otel
+	r = newTestReconciler(t, run)
+	r.Tracer = tp
+	reconcileRun(g, r, run)
+	g.Expect(spansOf("gate")).To(BeEmpty())
+	decide(g, r, run, obs.ApprovalApprove, "alice")
+	_, got = reconcileRun(g, r, run)
+	st := got.Status.TaskStatuses["gate"]
+	g.Expect(st.State).To(Equal(obs.TaskSucceeded))
+	spans = spansOf("gate")
+	g.Expect(spans).To(HaveLen(1))
+	spanID, root := tracing.SpanIDFor("a-uid", 0, "gate", 0), tracing.SpanIDFor("a-uid", 0, "", 0)
+	g.Expect(spans[0].SpanId).To(Equal(spanID[:]))
+	g.Expect(spans[0].ParentSpanId).To(Equal(root[:]))
+	g.Expect(spans[0].StartTimeUnixNano).To(Equal(uint64(st.StartedAt.UnixNano())))
+	// The status keeps whole seconds.
+	g.Expect(time.Unix(0, int64(spans[0].EndTimeUnixNano)).Truncate(time.Second)).To(BeTemporally("==", st.FinishedAt.Time))
+	g.Expect(spans[0].Status.GetCode()).To(Equal(tracepb.Status_STATUS_CODE_OK))
+}
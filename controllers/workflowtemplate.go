@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	observatoryv1alpha1 "github.com/example/observatory-operator/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// applyWorkflowTemplate makes the in-memory spec of a run with a
+// workflowTemplateRef describe the workflow it executes. The first time,
+// the template is fetched, its parameters are resolved with the run's
+// overrides, and both are recorded in status; afterwards the snapshot is
+// used, so template edits never change a started run. Only status is ever
+// written back, so the rewritten spec is never persisted.
+//
+// Problems the user has to fix (a missing template, parameters that do
+// not fit it) are returned as *UserError.
+func (r *ObservatoryRunReconciler) applyWorkflowTemplate(ctx context.Context, run *observatoryv1alpha1.ObservatoryRun) error {
+	ref := run.Spec.WorkflowTemplateRef
+	if ref == nil {
+		return nil
+	}
+	if run.Status.Workflow == nil {
+		tmpl, err := observatoryv1alpha1.GetWorkflowTemplate(ctx, r.Client, run.Namespace, ref)
+		if apierrors.IsNotFound(err) {
+			kind := "ObservatoryWorkflowTemplate"
+			if ref.ClusterScope {
+				kind = "ObservatoryClusterWorkflowTemplate"
+			}
+			return &UserError{
+				Operation: "resolve workflow template",
+				Cause:     err,
+				Message:   fmt.Sprintf("%s %q not found", kind, ref.Name),
+			}
+		} else if err != nil {
+			return err
+		}
+		merged, err := observatoryv1alpha1.MergeTemplateParameters(tmpl.Parameters, run.Spec.Parameters)
+		if err == nil {
+			run.Status.Parameters, err = observatoryv1alpha1.ResolveParameters(merged)
+		}
+		if err != nil {
+			return &UserError{
+				Operation: "resolve workflow template",
+				Message:   fmt.Sprintf("parameters do not fit template %q: %v", ref.Name, err),
+			}
+		}
+		run.Status.Workflow = tmpl.Workflow.DeepCopy()
+	}
+	run.Spec.Workflow = *run.Status.Workflow.DeepCopy()
+	return nil
+}
@@ -0,0 +1,39 @@
+#!/usr/bin/env bash
+
+# Copyright 2024 Observatory Operator Authors
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+# Runs BenchmarkActiveRunsAPIRequests against the working tree and against
+# the revision BASE, e.g. a release tag, so their reconcilers can be
+# compared.
+#
+# Usage: ./hack/bench-requeue.sh BASE
+
+REPO_ROOT=$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)
+cd "${REPO_ROOT}"
+
+if [[ $# -ne 1 ]]; then
+    echo "usage: $0 BASE (a tag, branch or commit to compare against)" >&2
+    exit 2
+fi
+BASE=$1
+: "${KUBEBUILDER_ASSETS:?set KUBEBUILDER_ASSETS to the envtest binaries, see make envtest}"
+export KUBEBUILDER_ASSETS
+
+BENCH=(go test ./controllers -run '^$' -bench ActiveRunsAPIRequests -benchtime 1x -timeout 30m)
+
+WORKTREE=$(mktemp -d)
+trap 'git worktree remove --force "${WORKTREE}"' EXIT
+git worktree add --detach "${WORKTREE}" "${BASE}" >/dev/null
+cp controllers/requeue_bench_test.go "${WORKTREE}/controllers/"
+
+echo "== ${BASE}"
+(cd "${WORKTREE}" && "${BENCH[@]}")
+echo "== working tree"
+"${BENCH[@]}"
@@ -0,0 +1,203 @@
+// internal/cron/cron.go
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed five-field cron expression:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Fields accept *, numbers, ranges (a-b), lists (a,b) and steps (*/n, a-b/n,
+// a/n). Months and weekdays also accept three-letter names (JAN, MON), and
+// Sunday is both 0 and 7. The descriptors @yearly, @annually, @monthly,
+// @weekly, @daily, @midnight and @hourly are shorthands for the usual
+// expressions. As in Vixie cron, when both day-of-month and day-of-week are
+// restricted a day matches if either does.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar record an unrestricted field, which changes how
+	// the two day fields combine.
+	domStar, dowStar bool
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type bounds struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minutes = bounds{name: "minute", min: 0, max: 59}
+	hours   = bounds{name: "hour", min: 0, max: 23}
+	doms    = bounds{name: "day-of-month", min: 1, max: 31}
+	months  = bounds{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is accepted as Sunday and folded onto 0 after parsing.
+	dows = bounds{name: "day-of-week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// Parse parses a cron expression.
+func Parse(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@") {
+		expanded, ok := descriptors[strings.ToLower(spec)]
+		if !ok {
+			return nil, fmt.Errorf("unknown descriptor %q", spec)
+		}
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields (minute hour day-of-month month day-of-week), got %d", len(fields))
+	}
+	var s Schedule
+	var err error
+	if s.minute, _, err = parseField(fields[0], minutes); err != nil {
+		return nil, err
+	}
+	if s.hour, _, err = parseField(fields[1], hours); err != nil {
+		return nil, err
+	}
+	if s.dom, s.domStar, err = parseField(fields[2], doms); err != nil {
+		return nil, err
+	}
+	if s.month, _, err = parseField(fields[3], months); err != nil {
+		return nil, err
+	}
+	if s.dow, s.dowStar, err = parseField(fields[4], dows); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	return &s, nil
+}
+
+// parseField returns the set of values matched by a field as a bitmask,
+// and whether the field is unrestricted (* or */n).
+func parseField(field string, b bounds) (uint64, bool, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		lo, hi, step := b.min, b.max, 1
+		rng := item
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, false, fmt.Errorf("%s: invalid step in %q", b.name, item)
+			}
+			step, rng = n, item[:i]
+		}
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			parts := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = b.value(parts[0]); err != nil {
+				return 0, false, err
+			}
+			if hi, err = b.value(parts[1]); err != nil {
+				return 0, false, err
+			}
+			if lo > hi {
+				return 0, false, fmt.Errorf("%s: range %q is backwards", b.name, rng)
+			}
+		default:
+			v, err := b.value(rng)
+			if err != nil {
+				return 0, false, err
+			}
+			lo = v
+			if step == 1 {
+				// A bare value is a single value; a/n runs to the maximum.
+				hi = v
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, strings.HasPrefix(field, "*"), nil
+}
+
+func (b bounds) value(s string) (int, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid value %q", b.name, s)
+	}
+	if v < b.min || v > b.max {
+		return 0, fmt.Errorf("%s: %d is out of range %d-%d", b.name, v, b.min, b.max)
+	}
+	return v, nil
+}
+
+// Next returns the first activation strictly after t, in t's location. It
+// returns the zero time when the schedule never fires (e.g. "0 0 30 2 *").
+// Wall-clock times skipped by a daylight-saving change do not fire.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every satisfiable schedule fires within a leap-year cycle.
+	limit := t.Year() + 5
+	for t.Year() <= limit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = after(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = after(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			// Step in absolute time: wall-clock arithmetic can stand still
+			// across a daylight-saving gap.
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// after returns next, or the first hour boundary after t when a midnight
+// that does not exist in t's location normalized next back to t or earlier.
+func after(t, next time.Time) time.Time {
+	for !next.After(t) {
+		next = next.Add(time.Hour)
+	}
+	return next
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,90 @@
+// internal/cron/cron_test.go
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Parse_Errors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * FOO *",
+		"@fortnightly",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q): expected error", spec)
+		}
+	}
+}
+
+func Test_Next(t *testing.T) {
+	utc := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	cases := []struct {
+		spec, from, want string
+	}{
+		{"* * * * *", "2024-03-01 10:00", "2024-03-01 10:01"},
+		{"*/15 * * * *", "2024-03-01 10:07", "2024-03-01 10:15"},
+		{"0 2 * * *", "2024-03-01 10:00", "2024-03-02 02:00"},
+		{"30 9 * * MON-FRI", "2024-03-01 10:00", "2024-03-04 09:30"}, // Friday -> Monday
+		{"0 0 1 */3 *", "2024-03-01 10:00", "2024-04-01 00:00"},
+		{"0 0 29 2 *", "2024-03-01 10:00", "2028-02-29 00:00"},
+		{"0 0 13 * 5", "2024-03-01 10:00", "2024-03-08 00:00"}, // day-of-month OR day-of-week
+		{"0 0 * * 7", "2024-03-01 10:00", "2024-03-03 00:00"},  // 7 is Sunday
+		{"@monthly", "2024-03-01 10:00", "2024-04-01 00:00"},
+		{"5 10 * * *", "2024-03-01 10:05", "2024-03-02 10:05"}, // strictly after
+	}
+	for _, c := range cases {
+		s, err := Parse(c.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.spec, err)
+		}
+		if got, want := s.Next(utc(c.from)), utc(c.want); !got.Equal(want) {
+			t.Errorf("%q from %s: got %s, want %s", c.spec, c.from, got, want)
+		}
+	}
+}
+
+func Test_Next_Location(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("tzdata not available:", err)
+	}
+	s, err := Parse("0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := s.Next(time.Date(2024, 1, 10, 12, 0, 0, 0, ny))
+	if want := time.Date(2024, 1, 11, 7, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("got %s, want %s", got.UTC(), want)
+	}
+	// 02:00 does not exist on the spring-forward day, so that day is skipped.
+	got = s.Next(time.Date(2024, 3, 10, 0, 0, 0, 0, ny))
+	if want := time.Date(2024, 3, 11, 2, 0, 0, 0, ny); !got.Equal(want) {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func Test_Next_Never(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Fatalf("got %s, want zero time", got)
+	}
+}
@@ -0,0 +1,102 @@
+// internal/metrics/metrics.go
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "observatory"
+
+// Collectors backing config/prometheus/prometheusrule.yaml. They are
+// registered with the controller-runtime registry so the manager's metrics
+// endpoint serves them alongside the built-in controller metrics.
+var (
+	ReconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_total",
+		Help:      "Total reconciliation attempts by result (success/error).",
+	}, []string{"result"})
+
+	ReconcileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Time spent in the reconciliation loop.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	ReconcileErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_errors_total",
+		Help:      "Total reconciliations that returned an error.",
+	})
+
+	JobCreateErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_create_errors_total",
+		Help:      "Failed Job creation attempts.",
+	})
+
+	JobCompleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_completed_total",
+		Help:      "Task Jobs that reached a terminal state by status (success/failure).",
+	}, []string{"status"})
+
+	JobTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_timeout_total",
+		Help:      "Task Jobs that exceeded their deadline.",
+	})
+
+	WorkflowRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workflow_retries_total",
+		Help:      "Task retries observed across all workflows.",
+	})
+
+	WorkflowDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "workflow_duration_seconds",
+		Help:      "End-to-end workflow execution time by final phase.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 16),
+	}, []string{"phase"})
+
+	WebhookErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_errors_total",
+		Help:      "ObservatoryRun admission requests rejected by the validating webhook.",
+	})
+)
+
+// Label values used with the vectors above.
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		ReconcileTotal,
+		ReconcileDuration,
+		ReconcileErrors,
+		JobCreateErrors,
+		JobCompleted,
+		JobTimeouts,
+		WorkflowRetries,
+		WorkflowDuration,
+		WebhookErrors,
+	)
+
+	// Pre-create the known label values so every series is exported from
+	// startup; alerts built on rate() otherwise see nothing until the first event.
+	ReconcileTotal.WithLabelValues(ResultSuccess)
+	ReconcileTotal.WithLabelValues(ResultError)
+	JobCompleted.WithLabelValues(StatusSuccess)
+	JobCompleted.WithLabelValues(StatusFailure)
+	WorkflowDuration.WithLabelValues("Succeeded")
+	WorkflowDuration.WithLabelValues("Failed")
+}
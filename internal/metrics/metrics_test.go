@@ -0,0 +1,74 @@
+// internal/metrics/metrics_test.go
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/yaml"
+)
+
+const ruleRelPath = "config/prometheus/prometheusrule.yaml"
+
+type prometheusRule struct {
+	Spec struct {
+		Groups []struct {
+			Rules []struct {
+				Alert string `json:"alert"`
+				Expr  string `json:"expr"`
+			} `json:"rules"`
+		} `json:"groups"`
+	} `json:"spec"`
+}
+
+var metricName = regexp.MustCompile(`observatory_[a-z_]+`)
+
+func repoRoot(t *testing.T) string {
+	t.Helper()
+	dir, err := os.Getwd()
+	if err != nil { t.Fatal(err) }
+	for i := 0; i < 4; i++ {
+		if _, err := os.Stat(filepath.Join(dir, ruleRelPath)); err == nil {
+			return dir
+		}
+		dir = filepath.Dir(dir)
+	}
+	t.Fatalf("could not locate repo root containing %s", ruleRelPath)
+	return ""
+}
+
+func Test_RuleMetricsRegistered(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join(repoRoot(t), ruleRelPath))
+	if err != nil { t.Fatal(err) }
+	var rule prometheusRule
+	if err := yaml.Unmarshal(b, &rule); err != nil { t.Fatal(err) }
+
+	families, err := ctrlmetrics.Registry.Gather()
+	if err != nil { t.Fatal(err) }
+	exported := map[string]bool{}
+	for _, f := range families {
+		exported[f.GetName()] = true
+	}
+
+	checked := 0
+	for _, g := range rule.Spec.Groups {
+		for _, r := range g.Rules {
+			for _, name := range metricName.FindAllString(r.Expr, -1) {
+				for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+					name = strings.TrimSuffix(name, suffix)
+				}
+				checked++
+				if !exported[name] {
+					t.Errorf("alert %s references %s, which is not registered", r.Alert, name)
+				}
+			}
+		}
+	}
+	if checked == 0 {
+		t.Fatalf("no observatory_* metrics found in %s", ruleRelPath)
+	}
+}
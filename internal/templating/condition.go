@@ -0,0 +1,281 @@
+// internal/templating/condition.go
+package templating
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Condition is a parsed `when` expression. The grammar is deliberately small:
+//
+//	expr    := and ('||' and)*
+//	and     := unary ('&&' unary)*
+//	unary   := '!' unary | primary
+//	primary := '(' expr ')' | operand [('==' | '!=') operand]
+//	operand := 'quoted' | "quoted" | word
+//
+// Operands may contain {{...}} placeholders, expanded at evaluation time.
+// A bare operand must expand to "true" or "false".
+type Condition struct {
+	root node
+}
+
+// ParseCondition parses a `when` expression.
+func ParseCondition(s string) (*Condition, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.toks[p.pos].text, p.toks[p.pos].at)
+	}
+	return &Condition{root: root}, nil
+}
+
+// Refs returns the placeholder expressions used by the condition.
+func (c *Condition) Refs() []string {
+	var out []string
+	c.root.walk(func(o operand) { out = append(out, Refs(o.text)...) })
+	return out
+}
+
+// Eval expands placeholders with resolve and evaluates the condition.
+func (c *Condition) Eval(resolve func(expr string) (string, error)) (bool, error) {
+	return c.root.eval(resolve)
+}
+
+type node interface {
+	eval(resolve func(string) (string, error)) (bool, error)
+	walk(fn func(operand))
+}
+
+type operand struct{ text string }
+
+func (o operand) value(resolve func(string) (string, error)) (string, error) {
+	return Expand(o.text, resolve)
+}
+
+type boolNode struct{ operand }
+
+func (n boolNode) eval(resolve func(string) (string, error)) (bool, error) {
+	v, err := n.value(resolve)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is not a boolean", v)
+}
+func (n boolNode) walk(fn func(operand)) { fn(n.operand) }
+
+type cmpNode struct {
+	left, right operand
+	negate      bool
+}
+
+func (n cmpNode) eval(resolve func(string) (string, error)) (bool, error) {
+	l, err := n.left.value(resolve)
+	if err != nil {
+		return false, err
+	}
+	r, err := n.right.value(resolve)
+	if err != nil {
+		return false, err
+	}
+	return (l == r) != n.negate, nil
+}
+func (n cmpNode) walk(fn func(operand)) { fn(n.left); fn(n.right) }
+
+type notNode struct{ x node }
+
+func (n notNode) eval(resolve func(string) (string, error)) (bool, error) {
+	v, err := n.x.eval(resolve)
+	return !v, err
+}
+func (n notNode) walk(fn func(operand)) { n.x.walk(fn) }
+
+type logicNode struct {
+	and         bool
+	left, right node
+}
+
+func (n logicNode) eval(resolve func(string) (string, error)) (bool, error) {
+	l, err := n.left.eval(resolve)
+	if err != nil {
+		return false, err
+	}
+	if n.and && !l || !n.and && l {
+		return l, nil
+	}
+	return n.right.eval(resolve)
+}
+func (n logicNode) walk(fn func(operand)) { n.left.walk(fn); n.right.walk(fn) }
+
+type tokKind int
+
+const (
+	tokOperand tokKind = iota
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	at   int
+}
+
+var operators = []string{"==", "!=", "&&", "||", "!", "(", ")"}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+			continue
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			toks = append(toks, token{kind: tokOperand, text: s[i+1 : i+1+end], at: i})
+			i += end + 2
+			continue
+		}
+		if op := operatorAt(s, i); op != "" {
+			toks = append(toks, token{kind: tokOp, text: op, at: i})
+			i += len(op)
+			continue
+		}
+		// A word runs until whitespace or an operator; {{...}} is kept whole
+		// even if it contains spaces.
+		start := i
+		for i < len(s) {
+			if strings.HasPrefix(s[i:], "{{") {
+				end := strings.Index(s[i:], "}}")
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated placeholder at position %d", i)
+				}
+				i += end + 2
+				continue
+			}
+			if s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || operatorAt(s, i) != "" {
+				break
+			}
+			i++
+		}
+		toks = append(toks, token{kind: tokOperand, text: s[start:i], at: start})
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	return toks, nil
+}
+
+func operatorAt(s string, i int) string {
+	for _, op := range operators {
+		if strings.HasPrefix(s[i:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peekOp(op string) bool {
+	return p.pos < len(p.toks) && p.toks[p.pos].kind == tokOp && p.toks[p.pos].text == op
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOp("||") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicNode{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOp("&&") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicNode{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peekOp("!") {
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	if p.peekOp("(") {
+		p.pos++
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peekOp(")") {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		p.pos++
+		return x, nil
+	}
+	t := p.toks[p.pos]
+	if t.kind != tokOperand {
+		return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.at)
+	}
+	p.pos++
+	left := operand{text: t.text}
+	if p.peekOp("==") || p.peekOp("!=") {
+		negate := p.toks[p.pos].text == "!="
+		p.pos++
+		if p.pos >= len(p.toks) || p.toks[p.pos].kind != tokOperand {
+			return nil, fmt.Errorf("missing right-hand side of comparison")
+		}
+		right := operand{text: p.toks[p.pos].text}
+		p.pos++
+		return cmpNode{left: left, right: right, negate: negate}, nil
+	}
+	return boolNode{operand: left}, nil
+}
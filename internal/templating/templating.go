@@ -0,0 +1,85 @@
+// internal/templating/templating.go
+package templating
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ref matches a {{ expression }} placeholder.
+var ref = regexp.MustCompile(`{{\s*([^{}]*?)\s*}}`)
+
+// Refs returns the expressions referenced by placeholders in s, in order
+// of appearance.
+func Refs(s string) []string {
+	var out []string
+	for _, m := range ref.FindAllStringSubmatch(s, -1) {
+		out = append(out, m[1])
+	}
+	return out
+}
+
+// Expand replaces each placeholder in s with the value returned by resolve.
+// The first resolve error aborts expansion.
+func Expand(s string, resolve func(expr string) (string, error)) (string, error) {
+	var firstErr error
+	out := ref.ReplaceAllStringFunc(s, func(m string) string {
+		if firstErr != nil {
+			return m
+		}
+		v, err := resolve(ref.FindStringSubmatch(m)[1])
+		if err != nil {
+			firstErr = err
+			return m
+		}
+		return v
+	})
+	return out, firstErr
+}
+
+// TaskOutputRef is a parsed tasks.<task>.outputs.<name> expression.
+type TaskOutputRef struct {
+	Task   string
+	Output string
+}
+
+// ParseTaskOutputRef parses expr as a task output reference. ok is false
+// when expr is not of the tasks.* form at all; err is set when it is, but
+// malformed.
+func ParseTaskOutputRef(expr string) (r TaskOutputRef, ok bool, err error) {
+	if !strings.HasPrefix(expr, "tasks.") {
+		return r, false, nil
+	}
+	parts := strings.Split(expr, ".")
+	if len(parts) != 4 || parts[2] != "outputs" || parts[1] == "" || parts[3] == "" {
+		return r, true, fmt.Errorf("malformed reference %q (want tasks.<task>.outputs.<name>)", expr)
+	}
+	return TaskOutputRef{Task: parts[1], Output: parts[3]}, true, nil
+}
+
+func (r TaskOutputRef) String() string {
+	return fmt.Sprintf("tasks.%s.outputs.%s", r.Task, r.Output)
+}
+
+// ParseParameterRef parses expr as an inputs.parameters.<name> reference.
+// ok and err follow the same convention as ParseTaskOutputRef.
+func ParseParameterRef(expr string) (name string, ok bool, err error) {
+	if !strings.HasPrefix(expr, "inputs.") {
+		return "", false, nil
+	}
+	parts := strings.Split(expr, ".")
+	if len(parts) != 3 || parts[1] != "parameters" || parts[2] == "" {
+		return "", true, fmt.Errorf("malformed reference %q (want inputs.parameters.<name>)", expr)
+	}
+	return parts[2], true, nil
+}
+
+const (
+	// RunPhaseRef is the expression for the outcome of a run's main tasks
+	// (Succeeded or Failed). Only finally tasks may reference it.
+	RunPhaseRef = "run.phase"
+	// ItemRef is the expression for the current item of a fan-out task's
+	// child. Only fan-out tasks may reference it.
+	ItemRef = "item"
+)
@@ -0,0 +1,114 @@
+// internal/templating/templating_test.go
+package templating
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_Refs(t *testing.T) {
+	got := Refs("echo {{ tasks.a.outputs.x }} and {{tasks.b.outputs.y}}")
+	want := []string{"tasks.a.outputs.x", "tasks.b.outputs.y"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func Test_Expand(t *testing.T) {
+	vals := map[string]string{"tasks.a.outputs.x": "1"}
+	resolve := func(expr string) (string, error) {
+		if v, ok := vals[expr]; ok {
+			return v, nil
+		}
+		return "", errors.New("missing " + expr)
+	}
+
+	out, err := Expand("x={{tasks.a.outputs.x}}", resolve)
+	if err != nil || out != "x=1" {
+		t.Fatalf("got %q, %v", out, err)
+	}
+	if _, err := Expand("{{tasks.a.outputs.nope}}", resolve); err == nil {
+		t.Fatal("expected error for unresolved reference")
+	}
+}
+
+func Test_ParseTaskOutputRef(t *testing.T) {
+	r, ok, err := ParseTaskOutputRef("tasks.build.outputs.digest")
+	if !ok || err != nil || r != (TaskOutputRef{Task: "build", Output: "digest"}) {
+		t.Fatalf("got %+v, %v, %v", r, ok, err)
+	}
+	if _, ok, err := ParseTaskOutputRef("tasks.build.digest"); !ok || err == nil {
+		t.Fatal("expected malformed tasks.* reference to error")
+	}
+	if _, ok, _ := ParseTaskOutputRef("inputs.parameters.x"); ok {
+		t.Fatal("non-task reference must not parse as a task output")
+	}
+}
+
+func Test_ParseParameterRef(t *testing.T) {
+	if name, ok, err := ParseParameterRef("inputs.parameters.env"); !ok || err != nil || name != "env" {
+		t.Fatalf("got %q, %v, %v", name, ok, err)
+	}
+	if _, ok, err := ParseParameterRef("inputs.env"); !ok || err == nil {
+		t.Fatal("expected malformed inputs.* reference to error")
+	}
+	if _, ok, _ := ParseParameterRef("tasks.a.outputs.x"); ok {
+		t.Fatal("task output reference must not parse as a parameter")
+	}
+}
+
+func Test_Condition(t *testing.T) {
+	vals := map[string]string{
+		"inputs.parameters.env":   "prod",
+		"tasks.a.outputs.changed": "true",
+	}
+	resolve := func(expr string) (string, error) {
+		if v, ok := vals[expr]; ok {
+			return v, nil
+		}
+		return "", errors.New("missing " + expr)
+	}
+
+	cases := map[string]bool{
+		"{{inputs.parameters.env}} == prod":                                      true,
+		"{{ inputs.parameters.env }} != 'prod'":                                  false,
+		"{{tasks.a.outputs.changed}}":                                            true,
+		"!{{tasks.a.outputs.changed}}":                                           false,
+		"{{inputs.parameters.env}} == dev || {{inputs.parameters.env}} == prod": true,
+		"({{inputs.parameters.env}} == dev || true) && \"a b\" == 'a b'":        true,
+	}
+	for expr, want := range cases {
+		c, err := ParseCondition(expr)
+		if err != nil {
+			t.Fatalf("%s: parse: %v", expr, err)
+		}
+		got, err := c.Eval(resolve)
+		if err != nil || got != want {
+			t.Errorf("%s: got %v, %v; want %v", expr, got, err, want)
+		}
+	}
+
+	c, _ := ParseCondition("{{inputs.parameters.env}} == prod && {{tasks.a.outputs.changed}}")
+	if refs := c.Refs(); !reflect.DeepEqual(refs, []string{"inputs.parameters.env", "tasks.a.outputs.changed"}) {
+		t.Errorf("unexpected refs %v", refs)
+	}
+	if _, err := mustParse(t, "{{inputs.parameters.env}}").Eval(resolve); err == nil {
+		t.Error("expected non-boolean operand to fail")
+	}
+
+	for _, bad := range []string{"", "a ==", "(a == b", "a == b)", "&& a", "'open"} {
+		if _, err := ParseCondition(bad); err == nil {
+			t.Errorf("%q: expected parse error", bad)
+		}
+	}
+}
+
+func mustParse(t *testing.T, s string) *Condition {
+	t.Helper()
+	c, err := ParseCondition(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
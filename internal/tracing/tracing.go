@@ -0,0 +1,162 @@
+// Package tracing exports run traces through the OpenTelemetry SDK.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TracesPath is the OTLP/HTTP path traces are posted to.
+	TracesPath = "/v1/traces"
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName = "observatory-operator"
+)
+
+// NewProvider returns a TracerProvider exporting to the OTLP/HTTP collector
+// at endpoint, a base URL such as http://otel-collector:4318. Spans are
+// queued by a BatchSpanProcessor and exported in the background, so
+// recording one never waits on the collector; when the queue is full new
+// spans are dropped. Shutdown flushes what is queued.
+func NewProvider(endpoint string) (*sdktrace.TracerProvider, error) {
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: %w", endpoint, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: want an http or https base URL", endpoint)
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(u.Path + TracesPath),
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP exporter: %w", err)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", ServiceName))),
+		sdktrace.WithIDGenerator(idGenerator{}),
+	), nil
+}
+
+// TraceIDFor derives the trace ID of a run from its UID. IDs are
+// deterministic so a task's span context can be handed to its container
+// before the span itself is recorded, and so restarts of the controller
+// keep emitting into the same trace.
+func TraceIDFor(runUID string) trace.TraceID {
+	var id trace.TraceID
+	sum := sha256.Sum256([]byte("trace/" + runUID))
+	copy(id[:], sum[:])
+	return id
+}
+
+// SpanIDFor derives the span ID of one attempt of a task within a retry
+// generation of a run. An empty task name yields the root span ID of that
+// generation.
+func SpanIDFor(runUID string, generation int64, task string, attempt int32) trace.SpanID {
+	var id trace.SpanID
+	sum := sha256.Sum256([]byte(fmt.Sprintf("span/%s/%d/%s/%d", runUID, generation, task, attempt)))
+	copy(id[:], sum[:])
+	return id
+}
+
+// Traceparent formats the W3C traceparent header of a sampled span.
+func Traceparent(traceID trace.TraceID, spanID trace.SpanID) string {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(trace.ContextWithSpanContext(context.Background(), sc), carrier)
+	return carrier.Get("traceparent")
+}
+
+// Span is a finished span to record.
+type Span struct {
+	TraceID       trace.TraceID
+	SpanID        trace.SpanID
+	ParentSpanID  trace.SpanID
+	Name          string
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]string
+	Status        codes.Code
+	StatusMessage string
+}
+
+// Record starts and ends s on tp with the IDs and times it carries. The
+// provider exports it; with NewProvider that happens in the background.
+func Record(tp trace.TracerProvider, s Span) {
+	ctx := context.Background()
+	if s.ParentSpanID.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: s.TraceID, SpanID: s.ParentSpanID, TraceFlags: trace.FlagsSampled, Remote: true,
+		}))
+	}
+	ctx = context.WithValue(ctx, idsKey{}, ids{trace: s.TraceID, span: s.SpanID})
+	_, span := tp.Tracer(ServiceName).Start(ctx, s.Name,
+		trace.WithTimestamp(s.Start),
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attributes(s.Attributes)...))
+	span.SetStatus(s.Status, s.StatusMessage)
+	span.End(trace.WithTimestamp(s.End))
+}
+
+func attributes(m map[string]string) []attribute.KeyValue {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]attribute.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, attribute.String(k, m[k]))
+	}
+	return out
+}
+
+// idsKey carries the IDs Record wants its span to have.
+type idsKey struct{}
+
+type ids struct {
+	trace trace.TraceID
+	span  trace.SpanID
+}
+
+// idGenerator hands out the IDs Record put in the context, and random ones
+// to spans started any other way.
+type idGenerator struct{}
+
+func (idGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	if v, ok := ctx.Value(idsKey{}).(ids); ok {
+		return v.trace, v.span
+	}
+	var t trace.TraceID
+	_, _ = rand.Read(t[:])
+	return t, idGenerator{}.NewSpanID(ctx, t)
+}
+
+func (idGenerator) NewSpanID(ctx context.Context, _ trace.TraceID) trace.SpanID {
+	if v, ok := ctx.Value(idsKey{}).(ids); ok {
+		return v.span
+	}
+	var s trace.SpanID
+	_, _ = rand.Read(s[:])
+	return s
+}
@@ -0,0 +1,148 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// receiver is a stand-in OTLP/HTTP collector that records the spans it is
+// sent.
+func receiver(t *testing.T) (*httptest.Server, func() []*tracepb.Span) {
+	t.Helper()
+	var mu sync.Mutex
+	var spans []*tracepb.Span
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != TracesPath {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read: %v", err)
+		}
+		var req coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []*tracepb.Span {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*tracepb.Span(nil), spans...)
+	}
+}
+
+func Test_RecordExportsSpan(t *testing.T) {
+	srv, received := receiver(t)
+	tp, err := NewProvider(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tp.Shutdown(context.Background())
+
+	start := time.Unix(100, 0)
+	span := Span{
+		TraceID:      TraceIDFor("uid"),
+		SpanID:       SpanIDFor("uid", 0, "build", 0),
+		ParentSpanID: SpanIDFor("uid", 0, "", 0),
+		Name:         "build",
+		Start:        start,
+		End:          start.Add(2 * time.Second),
+		Attributes:   map[string]string{"team": "infra"},
+		Status:       codes.Error,
+	}
+	Record(tp, span)
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := received()
+	if len(got) != 1 {
+		t.Fatalf("expected one span, got %d", len(got))
+	}
+	ws := got[0]
+	if string(ws.TraceId) != string(span.TraceID[:]) || string(ws.SpanId) != string(span.SpanID[:]) || string(ws.ParentSpanId) != string(span.ParentSpanID[:]) {
+		t.Errorf("ids not propagated: %+v", ws)
+	}
+	if ws.StartTimeUnixNano != 100000000000 || ws.EndTimeUnixNano != 102000000000 {
+		t.Errorf("unexpected times %d..%d", ws.StartTimeUnixNano, ws.EndTimeUnixNano)
+	}
+	if ws.Status.GetCode() != tracepb.Status_STATUS_CODE_ERROR {
+		t.Errorf("expected error status, got %v", ws.Status.GetCode())
+	}
+	if len(ws.Attributes) != 1 || ws.Attributes[0].Key != "team" || ws.Attributes[0].Value.GetStringValue() != "infra" {
+		t.Errorf("unexpected attributes %+v", ws.Attributes)
+	}
+}
+
+func Test_RecordDoesNotWaitForCollector(t *testing.T) {
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		<-hang
+	}))
+	defer srv.Close()
+	defer close(hang)
+	tp, err := NewProvider(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	begin := time.Now()
+	for i := 0; i < 1000; i++ {
+		Record(tp, Span{TraceID: TraceIDFor("uid"), SpanID: SpanIDFor("uid", 0, "t", int32(i)), Name: "t"})
+	}
+	if d := time.Since(begin); d > time.Second {
+		t.Fatalf("recording spans took %s with the collector hanging", d)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_ = tp.Shutdown(ctx)
+}
+
+func Test_SpanIDs(t *testing.T) {
+	root := SpanIDFor("uid", 0, "", 0)
+	seen := map[string]bool{root.String(): true}
+	for _, id := range []string{
+		SpanIDFor("uid", 0, "a", 0).String(),
+		SpanIDFor("uid", 0, "a", 1).String(),
+		SpanIDFor("uid", 1, "a", 0).String(),
+		SpanIDFor("uid", 0, "b", 0).String(),
+		SpanIDFor("uid", 1, "", 0).String(),
+	} {
+		if seen[id] {
+			t.Fatalf("span ID %s reused across tasks, attempts or retry generations", id)
+		}
+		seen[id] = true
+	}
+
+	tp := Traceparent(TraceIDFor("uid"), root)
+	want := "00-" + TraceIDFor("uid").String() + "-" + root.String() + "-01"
+	if tp != want {
+		t.Fatalf("traceparent %q, want %q", tp, want)
+	}
+}
+
+func Test_NewProviderRejectsBadEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"otel-collector:4318", "grpc://otel-collector:4317", "http://"} {
+		if _, err := NewProvider(endpoint); err == nil {
+			t.Errorf("expected %q to be rejected", endpoint)
+		}
+	}
+}